@@ -0,0 +1,486 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+const configDesc = `This command manages the plugin configuration.
+
+The configuration is stored in ~/.config/helm_plugin_oss.yaml. The values are
+overridden by HELM_OSS_* environment variables.
+
+'set' and 'unset' change the profile selected with '--profile' flag or
+HELM_OSS_PROFILE environment variable, or the top-level configuration if no
+profile is selected. Besides the keys listed by 'helm oss config set --help',
+'defaultProfile' and 'repositories.REPO_OR_URI' keys are supported to select
+the default profile and to bind the repository to the profile.
+`
+
+const configExample = `  helm oss config view my-repo                              - shows the configuration used for repository 'my-repo'
+  helm oss --profile prod config set region cn-shanghai     - sets the region of profile 'prod'
+  helm oss config set repositories.prod-charts prod         - binds repository 'prod-charts' to profile 'prod'
+  helm oss config unset sessionToken                        - removes the session token
+  helm oss config profiles list                             - lists profiles
+  helm oss config doctor oss://bucket/charts                - checks access to OSS URI`
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "config",
+		Short:   "Manage the plugin configuration.",
+		Long:    configDesc,
+		Example: configExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+
+	profilesCmd := &cobra.Command{
+		Use:   "profiles",
+		Short: "Manage configuration profiles.",
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+	profilesCmd.AddCommand(newConfigProfilesListCommand())
+
+	cmd.AddCommand(
+		newConfigViewCommand(),
+		newConfigSetCommand(),
+		newConfigUnsetCommand(),
+		profilesCmd,
+		newConfigDoctorCommand(),
+	)
+
+	return cmd
+}
+
+func newConfigViewCommand() *cobra.Command {
+	act := &configViewAction{
+		printer:   nil,
+		repoOrURI: "",
+		output:    "text",
+	}
+
+	cmd := &cobra.Command{
+		Use:   "view [REPO_OR_URI]",
+		Short: "Show the effective configuration and the source of each value.",
+		Long: `Show the effective configuration and the source of each value.
+
+If REPO_OR_URI is provided, the configuration is shown as used for the
+repository, with the profile bound to it. Secrets are masked.`,
+		Args: wrapPositionalArgsBadUsage(cobra.MaximumNArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			if len(args) > 0 {
+				act.repoOrURI = args[0]
+			}
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&act.output, "output", "o", act.output, "Output format, one of: text, json.")
+
+	return cmd
+}
+
+type configViewAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+
+	// flags
+
+	output string
+}
+
+func (act *configViewAction) run(ctx context.Context) error {
+	if act.output != "text" && act.output != "json" {
+		return newBadUsageError(fmt.Errorf("unsupported output format %q, must be one of: text, json", act.output))
+	}
+
+	uri := ""
+	if act.repoOrURI != "" {
+		repo, err := helmutil.NewRepository(act.repoOrURI)
+		if err != nil {
+			return err
+		}
+		uri = repo.URL()
+	}
+
+	exp, err := oss.ExplainConfig(uri, configOptions(ctx))
+	if err != nil {
+		return err
+	}
+
+	if act.output == "json" {
+		b, err := json.MarshalIndent(exp, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal config")
+		}
+		act.printer.Printf("%s\n", b)
+		return nil
+	}
+
+	act.printer.Printf("Config file: %s\n", exp.Path)
+	if exp.Profile != "" {
+		act.printer.Printf("Profile: %s (%s)\n", exp.Profile, exp.ProfileReason)
+	} else {
+		act.printer.Printf("Profile: none (%s)\n", exp.ProfileReason)
+	}
+	if len(exp.Values) == 0 {
+		act.printer.Printf("No values are set.\n")
+		return nil
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, v := range exp.Values {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	act.printer.Printf("%s", b.String())
+	return nil
+}
+
+func newConfigSetCommand() *cobra.Command {
+	act := &configSetAction{
+		printer: nil,
+		key:     "",
+		value:   "",
+		unset:   false,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "set KEY VALUE",
+		Short: "Set the configuration value.",
+		Long: `Set the configuration value.
+
+Lists, e.g. credentials.providers, are comma-separated. The supported keys are:
+` + configKeysHelp(),
+		Args: wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.key = args[0]
+			act.value = args[1]
+			return act.run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+func newConfigUnsetCommand() *cobra.Command {
+	act := &configSetAction{
+		printer: nil,
+		key:     "",
+		value:   "",
+		unset:   true,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "unset KEY",
+		Short: "Remove the configuration value.",
+		Args:  wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.key = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+// configSetAction sets or, if unset is true, removes the configuration value.
+type configSetAction struct {
+	printer printer
+
+	// args
+
+	key   string
+	value string
+
+	unset bool
+}
+
+func (act *configSetAction) run(ctx context.Context) error {
+	path, err := oss.ConfigFilePath()
+	if err != nil {
+		return err
+	}
+	f, err := oss.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	target := "the top-level configuration"
+	switch repo, ok := strings.CutPrefix(act.key, "repositories."); {
+	case act.key == "defaultProfile":
+		f.DefaultProfile = act.value
+	case ok && repo != "":
+		if act.unset {
+			delete(f.Repositories, repo)
+		} else {
+			if f.Repositories == nil {
+				f.Repositories = make(map[string]string)
+			}
+			f.Repositories[repo] = act.value
+		}
+	default:
+		key, ok := oss.LookupConfigKey(act.key)
+		if !ok {
+			return newBadUsageError(fmt.Errorf("unknown config key %q", act.key))
+		}
+
+		profile := cmp.Or(configOptions(ctx).Profile, os.Getenv(oss.ProfileEnv))
+		conf := f.Config
+		if profile != "" {
+			target = "profile " + profile
+			if c, ok := f.Profiles[profile]; ok {
+				conf = c
+			} else {
+				conf = oss.Config{}
+			}
+		}
+
+		if act.unset {
+			key.Unset(&conf)
+		} else if err := key.Set(&conf, act.value); err != nil {
+			return newBadUsageError(err)
+		}
+
+		if profile != "" {
+			if f.Profiles == nil {
+				f.Profiles = make(map[string]oss.Config)
+			}
+			f.Profiles[profile] = conf
+		} else {
+			f.Config = conf
+		}
+	}
+
+	if err := oss.SaveConfigFile(path, f); err != nil {
+		return err
+	}
+
+	if act.unset {
+		act.printer.Printf("Removed %s from %s.\n", act.key, target)
+	} else {
+		act.printer.Printf("Set %s in %s.\n", act.key, target)
+	}
+	return nil
+}
+
+// configKeysHelp returns the list of the configuration keys for the help.
+func configKeysHelp() string {
+	var b strings.Builder
+	for _, k := range oss.ConfigKeys() {
+		b.WriteString("- " + k.Name)
+		if len(k.Env) > 0 {
+			b.WriteString(" (overridden by " + strings.Join(k.Env, ", ") + ")")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func newConfigProfilesListCommand() *cobra.Command {
+	act := &configProfilesListAction{
+		printer: nil,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List configuration profiles.",
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			return act.run()
+		},
+	}
+
+	return cmd
+}
+
+type configProfilesListAction struct {
+	printer printer
+}
+
+func (act *configProfilesListAction) run() error {
+	path, err := oss.ConfigFilePath()
+	if err != nil {
+		return err
+	}
+	f, err := oss.LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	if len(f.Profiles) == 0 {
+		act.printer.Printf("No profiles are defined in %s.\n", path)
+		return nil
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDEFAULT\tREPOSITORIES")
+	for _, name := range slices.Sorted(maps.Keys(f.Profiles)) {
+		var repos []string
+		for repo, profile := range f.Repositories {
+			if profile == name {
+				repos = append(repos, repo)
+			}
+		}
+		slices.Sort(repos)
+
+		def := ""
+		if name == f.DefaultProfile {
+			def = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, def, strings.Join(repos, ","))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	act.printer.Printf("%s", b.String())
+	return nil
+}
+
+func newConfigDoctorCommand() *cobra.Command {
+	act := &configDoctorAction{
+		printer:   nil,
+		repoOrURI: "",
+		output:    "text",
+	}
+
+	cmd := &cobra.Command{
+		Use:   "doctor REPO_OR_URI",
+		Short: "Check access to the repository with the configuration.",
+		Long: `Check access to the repository with the configuration.
+
+The following checks are run: configuration loading, credentials, endpoint
+reachability, bucket existence, read permission and write permission. The
+write check creates and deletes a temporary object in the repository.
+
+The command exits with non-zero code if any check fails.`,
+		Args: wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&act.output, "output", "o", act.output, "Output format, one of: text, json.")
+
+	return cmd
+}
+
+type configDoctorAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+
+	// flags
+
+	output string
+}
+
+func (act *configDoctorAction) run(ctx context.Context) error {
+	if act.output != "text" && act.output != "json" {
+		return newBadUsageError(fmt.Errorf("unsupported output format %q, must be one of: text, json", act.output))
+	}
+
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(repo.URL(), "oss://") {
+		return newBadUsageError(fmt.Errorf("repository %s is not in OSS, doctor only checks oss:// repositories", repo.URL()))
+	}
+
+	checks := act.check(ctx, repo.URL())
+
+	if act.output == "json" {
+		b, err := json.MarshalIndent(checks, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal checks")
+		}
+		act.printer.Printf("%s\n", b)
+	} else {
+		act.printer.Printf("Checking %s.\n", repo.URL())
+		for _, c := range checks {
+			act.printer.Printf("  %-8s %-12s %s\n", c.Status, c.Name, c.Message)
+			if c.Hint != "" {
+				act.printer.Printf("  %-8s %-12s hint: %s\n", "", "", c.Hint)
+			}
+		}
+	}
+
+	if slices.ContainsFunc(checks, func(c oss.Check) bool { return c.Status == oss.CheckFailed }) {
+		return newSilentError()
+	}
+	return nil
+}
+
+// check runs the configuration check and, if it passes, the repository access
+// checks.
+func (act *configDoctorAction) check(ctx context.Context, uri string) []oss.Check {
+	configCheck := oss.Check{Name: "config", Status: oss.CheckFailed}
+	fail := func(err error) []oss.Check {
+		configCheck.Message = err.Error()
+		configCheck.Hint = "Fix the configuration file, see `helm oss config view`."
+		return []oss.Check{configCheck}
+	}
+
+	exp, err := oss.ExplainConfig(uri, configOptions(ctx))
+	if err != nil {
+		return fail(err)
+	}
+	conf, err := oss.LoadConfig(uri, configOptions(ctx))
+	if err != nil {
+		return fail(err)
+	}
+	storage, err := oss.New(conf)
+	if err != nil {
+		return fail(err)
+	}
+
+	configCheck.Status = oss.CheckOK
+	configCheck.Message = "loaded from " + exp.Path
+	if exp.Profile != "" {
+		configCheck.Message += fmt.Sprintf(", profile %s (%s)", exp.Profile, exp.ProfileReason)
+	}
+
+	return append([]oss.Check{configCheck}, storage.Doctor(ctx, uri)...)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/oss"
+	"helm-oss/internal/osstest"
+)
+
+func TestConfigSetAction(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(oss.ProfileEnv, "")
+
+	ctx := context.Background()
+
+	set := func(key, value string) error {
+		act := &configSetAction{printer: &testPrinter{}, key: key, value: value}
+		return act.run(ctx)
+	}
+	unset := func(key string) error {
+		act := &configSetAction{printer: &testPrinter{}, key: key, unset: true}
+		return act.run(ctx)
+	}
+
+	require.NoError(t, set("region", "cn-hangzhou"))
+	require.NoError(t, set("accessKeySecret", "secret"))
+	require.NoError(t, set("credentials.providers", "static, env"))
+	require.NoError(t, set("defaultProfile", "dev"))
+	require.NoError(t, set("repositories.prod-charts", "prod"))
+	require.NoError(t, unset("accessKeySecret"))
+
+	ctx = withConfigOptions(context.Background(), oss.ConfigOptions{Profile: "prod"})
+	require.NoError(t, set("region", "cn-shanghai"))
+	require.NoError(t, set("credentials.durationSeconds", "3600"))
+
+	err := set("credentials.durationSeconds", "hour")
+	assert.True(t, errorTypeBadUsage.Is(err))
+	err = set("foo", "bar")
+	assert.True(t, errorTypeBadUsage.Is(err))
+
+	path, err := oss.ConfigFilePath()
+	require.NoError(t, err)
+	f, err := oss.LoadConfigFile(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, "cn-hangzhou", f.Region)
+	assert.Empty(t, f.AccessKeySecret)
+	assert.Equal(t, []string{"static", "env"}, f.Credentials.Providers)
+	assert.Equal(t, "dev", f.DefaultProfile)
+	assert.Equal(t, map[string]string{"prod-charts": "prod"}, f.Repositories)
+	assert.Equal(t, "cn-shanghai", f.Profiles["prod"].Region)
+	assert.Equal(t, 3600, f.Profiles["prod"].Credentials.DurationSeconds)
+}
+
+func TestConfigViewAction(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(oss.ProfileEnv, "")
+
+	ctx := context.Background()
+
+	set := &configSetAction{printer: &testPrinter{}, key: "accessKeySecret", value: "very-secret-value"}
+	require.NoError(t, set.run(ctx))
+	t.Setenv("HELM_OSS_REGION", "cn-beijing")
+
+	p := &testPrinter{}
+	act := &configViewAction{printer: p, output: "text"}
+	require.NoError(t, act.run(ctx))
+
+	out := p.out.String()
+	assert.Contains(t, out, "Profile: none")
+	assert.Regexp(t, `region +cn-beijing +env HELM_OSS_REGION`, out)
+	assert.Regexp(t, `accessKeySecret +\*\*\*\*alue +config file`, out)
+	assert.NotContains(t, out, "very-secret-value")
+}
+
+func TestConfigDoctorAction(t *testing.T) {
+	srv := osstest.NewTestServer(t, "test-bucket")
+	ctx := context.Background()
+
+	p := &testPrinter{}
+	act := &configDoctorAction{printer: p, repoOrURI: testRepoURI, output: "text"}
+	require.NoError(t, act.run(ctx))
+	assert.Regexp(t, `ok +credentials +credentials are available from provider static`, p.out.String())
+	assert.Regexp(t, `warning +read +index.yaml not found`, p.out.String())
+	assert.Regexp(t, `ok +write`, p.out.String())
+
+	// The temporary object is removed.
+	assert.Empty(t, srv.Keys("test-bucket"))
+
+	p = &testPrinter{}
+	act = &configDoctorAction{printer: p, repoOrURI: "oss://missing-bucket/charts", output: "text"}
+	err := act.run(ctx)
+	assert.True(t, errorTypeSilent.Is(err))
+	assert.Regexp(t, `failed +bucket`, p.out.String())
+	assert.Contains(t, p.out.String(), "hint: Check the bucket name in the repository URI, or create the bucket.")
+	assert.Regexp(t, `skipped +write`, p.out.String())
+}
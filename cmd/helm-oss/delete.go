@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -73,41 +72,78 @@ func (act *deleteAction) run(ctx context.Context) error {
 		return err
 	}
 
-	storage := oss.New()
-
-	b, err := storage.FetchRaw(ctx, repo.IndexURL())
+	storage, err := newBackend(ctx, repo.URL())
 	if err != nil {
-		return errors.WithMessage(err, "fetch current repo index")
+		return err
 	}
 
-	idx := helmutil.NewIndex()
-	if err := idx.UnmarshalBinary(b); err != nil {
-		return errors.WithMessage(err, "load index from downloaded file")
-	}
+	var idx *helmutil.Index
+	err = withRepoLock(ctx, act.printer, storage, repo, "delete", func(ctx context.Context) error {
+		// Find out the chart URL to record it in the journal.
+		current, _, err := fetchIndex(ctx, storage, repo)
+		if err != nil {
+			return err
+		}
+		url, err := current.Delete(act.chartName, act.version)
+		if err != nil {
+			return err
+		}
 
-	url, err := idx.Delete(act.chartName, act.version)
-	if err != nil {
-		return err
-	}
-	idx.UpdateGeneratedTime()
+		journal, err := beginJournal(ctx, storage, repo, "delete", &oss.JournalChart{
+			Name:    act.chartName,
+			Version: act.version,
+			URL:     url,
+		})
+		if err != nil {
+			return err
+		}
 
-	idxReader, err := idx.Reader()
-	if err != nil {
-		return errors.Wrap(err, "get index reader")
-	}
+		// The index is updated first, so that it never references a missing chart.
+		idx, err = updateIndex(ctx, storage, repo, false, func(idx *helmutil.Index) (*helmutil.Index, error) {
+			if _, err := idx.Delete(act.chartName, act.version); err != nil {
+				return nil, err
+			}
+			idx.UpdateGeneratedTime()
+			return idx, nil
+		})
+		if err != nil {
+			if !indexUnchanged(err) {
+				// The index may have been updated, keep the journal, so that
+				// the deletion can be completed by recover.
+				return errors.WithMessagef(
+					err, "update index (run `helm oss recover %s` to finish the deletion)", repo.URL(),
+				)
+			}
+			// The index has not been changed, so there is nothing to recover.
+			completeJournal(ctx, act.printer, storage, repo, journal)
+			return err
+		}
 
-	if url != "" {
-		if !strings.HasPrefix(url, repo.URL()) {
-			url = strings.TrimSuffix(repo.URL(), "/") + "/" + url
+		if url != "" {
+			// The index no longer references the chart, so the deletion is
+			// completed even if the cancellation has been requested since.
+			deleteCtx, cancel := cleanupContext(ctx)
+			defer cancel()
+			if err := storage.DeleteChart(deleteCtx, resolveChartURL(repo, url)); err != nil {
+				// Keep the journal, so that the chart can be deleted by recover.
+				return errors.WithMessagef(
+					err, "delete chart file from oss (run `helm oss recover %s` to finish the deletion)", repo.URL(),
+				)
+			}
 		}
 
-		if err := storage.DeleteChart(ctx, url); err != nil {
-			return errors.WithMessage(err, "delete chart file from oss")
+		if url != "" {
+			updateReindexState(ctx, act.printer, storage, repo, func(state *reindexState) error {
+				delete(state.Charts, chartFileName(repo, url))
+				return nil
+			})
 		}
-	}
 
-	if err := storage.PutIndex(ctx, repo.URL(), idxReader); err != nil {
-		return errors.WithMessage(err, "upload new index to oss")
+		completeJournal(ctx, act.printer, storage, repo, journal)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	if repo.ShouldUpdateCache() {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/oss"
+)
+
+func TestDeleteAction(t *testing.T) {
+	b := setupRepo(t)
+
+	push := &pushAction{
+		printer:   &testPrinter{},
+		chartPath: testChartPath,
+		repoOrURI: testRepoURI,
+	}
+	require.NoError(t, push.run(context.Background()))
+
+	act := &deleteAction{
+		printer:   &testPrinter{},
+		chartName: "foo",
+		repoOrURI: testRepoURI,
+		version:   "1.2.3",
+	}
+	require.NoError(t, act.run(context.Background()))
+
+	exists, err := b.Exists(context.Background(), testRepoURI+"/foo-1.2.3.tgz")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.False(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+
+	t.Run("should fail on missing chart version", func(t *testing.T) {
+		assert.Error(t, act.run(context.Background()))
+	})
+}
+
+func TestDeleteAction_IndexUpdateFailed(t *testing.T) {
+	old := indexUpdateBaseBackoff
+	indexUpdateBaseBackoff = time.Millisecond
+	t.Cleanup(func() { indexUpdateBaseBackoff = old })
+
+	ctx := context.Background()
+
+	setup := func(t *testing.T) *oss.MemoryBackend {
+		b := setupRepo(t)
+		push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+		require.NoError(t, push.run(ctx))
+		return b
+	}
+
+	act := &deleteAction{
+		printer:   &testPrinter{},
+		chartName: "foo",
+		repoOrURI: testRepoURI,
+		version:   "1.2.3",
+	}
+
+	t.Run("should keep journal if index may have been updated", func(t *testing.T) {
+		b := setup(t)
+		mockBackend(t, &failingIndexBackend{MemoryBackend: b})
+
+		err := act.run(ctx)
+		assert.ErrorContains(t, err, "helm oss recover")
+
+		journals, err := b.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		require.Len(t, journals, 1)
+		assert.Equal(t, "delete", journals[0].Operation)
+	})
+
+	t.Run("should complete journal if index is unchanged", func(t *testing.T) {
+		b := setup(t)
+		mockBackend(t, &stalePutBackend{MemoryBackend: b, conflicts: indexUpdateMaxAttempts})
+
+		err := act.run(ctx)
+		assert.ErrorIs(t, err, oss.ErrIndexConflict)
+
+		journals, err := b.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		assert.Empty(t, journals)
+
+		exists, err := b.Exists(ctx, testRepoURI+"/foo-1.2.3.tgz")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -21,6 +22,10 @@ https://helm.sh/docs/topics/plugins/#downloader-plugins
 - KEY - key file,
 - CA - certificate authority file,
 - URL - full url.
+
+CERT, KEY and CA are the TLS files of the repository, as passed to
+'helm repo add --cert-file --key-file --ca-file'. They take precedence over
+the TLS files in the configuration.
 `
 
 func newDownloadCommand() *cobra.Command {
@@ -69,9 +74,26 @@ type downloadAction struct {
 func (act *downloadAction) run(ctx context.Context) error {
 	const indexYaml = "index.yaml"
 
-	storage := oss.New()
+	// The files take precedence over the configuration.
+	opts := configOptions(ctx)
+	opts.Overrides = slices.Clone(opts.Overrides)
+	for key, file := range map[string]string{
+		"tls.certFile": act.certFile,
+		"tls.keyFile":  act.keyFile,
+		"tls.caFile":   act.caFile,
+	} {
+		if file != "" {
+			opts.Overrides = append(opts.Overrides, oss.ConfigOverride{Key: key, Value: file, Source: "helm downloader"})
+		}
+	}
+	ctx = withConfigOptions(ctx, opts)
+
+	storage, err := newBackend(ctx, act.url)
+	if err != nil {
+		return err
+	}
 
-	b, err := storage.FetchRaw(ctx, act.url)
+	b, _, err := storage.FetchRaw(ctx, act.url)
 	if err != nil {
 		if strings.HasSuffix(act.url, indexYaml) && err == oss.ErrObjectNotFound {
 			act.printer.PrintErrf(
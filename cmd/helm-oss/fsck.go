@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+const fsckDesc = `This command checks the repository index against the repository contents.
+
+'helm oss fsck' takes one argument:
+- REPO_OR_URI - target repository name or OSS URI.
+
+The following issues are reported:
+- missing-object: the index entry has no chart object,
+- unindexed-chart: the chart object is not in the index,
+- orphan-prov: the provenance file has no chart object,
+- digest-mismatch: the index entry digest differs from the chart digest,
+- name-mismatch: the chart name and version disagree with its file name.
+
+[Repair]
+
+With --repair flag, the index is fixed in place, keeping the rest of the
+entries intact: entries with missing objects are removed, unindexed charts
+are added and digests are updated. Orphan provenance files and file names are
+not changed. Unlike 'helm oss reindex', hand-maintained index data is kept.
+
+The command exits with non-zero code if there are issues left unrepaired.
+`
+
+const fsckExample = `  helm oss fsck my-repo                       - checks repository 'my-repo'
+  helm oss fsck -o json oss://bucket/charts   - checks OSS URI and prints the report in JSON
+  helm oss fsck --repair oss://bucket/charts  - checks OSS URI and repairs the index`
+
+// Issue types reported by fsck.
+const (
+	fsckMissingObject  = "missing-object"
+	fsckUnindexedChart = "unindexed-chart"
+	fsckOrphanProv     = "orphan-prov"
+	fsckDigestMismatch = "digest-mismatch"
+	fsckNameMismatch   = "name-mismatch"
+)
+
+func newFsckCommand() *cobra.Command {
+	act := &fsckAction{
+		printer:     nil,
+		repoOrURI:   "",
+		output:      "text",
+		repair:      false,
+		concurrency: oss.DefaultConcurrency,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "fsck REPO_OR_URI",
+		Short:   "Check the repository index consistency.",
+		Long:    fsckDesc,
+		Example: fsckExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO_OR_URI argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if act.concurrency < 1 {
+				return newBadUsageError(errors.New("--concurrency must be at least 1"))
+			}
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&act.output, "output", "o", act.output, "Report format, one of: text, json.")
+	flags.BoolVar(&act.repair, "repair", act.repair, "Repair the index.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Number of chart objects inspected in parallel.")
+
+	return cmd
+}
+
+type fsckAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+
+	// flags
+
+	output      string
+	repair      bool
+	concurrency int
+}
+
+// fsckReport is the result of the repository check.
+type fsckReport struct {
+	Repository string      `json:"repository"`
+	Entries    int         `json:"entries"`
+	Charts     int         `json:"charts"`
+	Issues     []fsckIssue `json:"issues"`
+}
+
+// fsckIssue is an inconsistency found in the repository.
+type fsckIssue struct {
+	Type     string `json:"type"`
+	Name     string `json:"name,omitempty"`
+	Version  string `json:"version,omitempty"`
+	File     string `json:"file"`
+	Message  string `json:"message"`
+	Repaired bool   `json:"repaired"`
+
+	// fix repairs the issue in the index. It is nil if the issue cannot be
+	// repaired in the index.
+	fix func(idx *helmutil.Index) error
+}
+
+// fsckContents is the repository contents the index is checked against.
+type fsckContents struct {
+	// files are names of all objects in the repository root.
+	files map[string]bool
+
+	// charts are charts in the repository root by file name.
+	charts map[string]oss.ChartInfo
+}
+
+func (act *fsckAction) run(ctx context.Context) error {
+	if act.output != "text" && act.output != "json" {
+		return newBadUsageError(fmt.Errorf("unsupported output format %q, must be one of: text, json", act.output))
+	}
+
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	var report *fsckReport
+	if act.repair {
+		report, err = act.checkAndRepair(ctx, storage, repo)
+	} else {
+		report, err = act.check(ctx, storage, repo)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := act.printReport(report); err != nil {
+		return err
+	}
+
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			return newSilentError()
+		}
+	}
+	return nil
+}
+
+// check checks the repository without changing it.
+func (act *fsckAction) check(ctx context.Context, storage oss.Backend, repo helmutil.Repository) (*fsckReport, error) {
+	contents, err := act.scan(ctx, storage, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, _, err := fetchIndex(ctx, storage, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return act.inspect(repo, idx, contents), nil
+}
+
+// checkAndRepair checks the repository and repairs the index under the
+// repository lock.
+func (act *fsckAction) checkAndRepair(ctx context.Context, storage oss.Backend, repo helmutil.Repository) (*fsckReport, error) {
+	var report *fsckReport
+	err := withRepoLock(ctx, act.printer, storage, repo, "fsck", func(ctx context.Context) error {
+		contents, err := act.scan(ctx, storage, repo)
+		if err != nil {
+			return err
+		}
+
+		current, _, err := fetchIndex(ctx, storage, repo)
+		if err != nil {
+			return err
+		}
+		report = act.inspect(repo, current, contents)
+		if !slices.ContainsFunc(report.Issues, func(issue fsckIssue) bool { return issue.fix != nil }) {
+			return nil
+		}
+
+		idx, err := updateIndex(ctx, storage, repo, false, func(idx *helmutil.Index) (*helmutil.Index, error) {
+			// The index may differ from the checked one after a conflict,
+			// so check it again.
+			report = act.inspect(repo, idx, contents)
+			for i, issue := range report.Issues {
+				if issue.fix == nil {
+					continue
+				}
+				if err := issue.fix(idx); err != nil {
+					return nil, errors.WithMessagef(err, "repair %s %s", issue.Type, issue.File)
+				}
+				report.Issues[i].Repaired = true
+			}
+			idx.SortEntries()
+			idx.UpdateGeneratedTime()
+			return idx, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if repo.ShouldUpdateCache() {
+			if err := idx.WriteFile(repo.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+				return errors.WithMessage(err, "update local index")
+			}
+		}
+		return nil
+	})
+
+	return report, err
+}
+
+// scan lists the repository objects and loads the charts.
+func (act *fsckAction) scan(ctx context.Context, storage oss.Backend, repo helmutil.Repository) (*fsckContents, error) {
+	objects, err := storage.ListObjects(ctx, repo.URL())
+	if err != nil {
+		return nil, errors.WithMessage(err, "list repository objects")
+	}
+
+	contents := &fsckContents{
+		files:  make(map[string]bool, len(objects)),
+		charts: make(map[string]oss.ChartInfo),
+	}
+	for _, obj := range objects {
+		contents.files[path.Base(obj.URI)] = true
+	}
+
+	items, errs := storage.Traverse(ctx, repo.URL(), act.concurrency)
+	for item := range items {
+		contents.charts[item.Filename] = item
+	}
+	for err := range errs {
+		return nil, fmt.Errorf("traverse the chart repository: %v", err)
+	}
+
+	return contents, nil
+}
+
+// inspect compares the index with the repository contents.
+func (act *fsckAction) inspect(repo helmutil.Repository, idx *helmutil.Index, contents *fsckContents) *fsckReport {
+	report := &fsckReport{
+		Repository: repo.URL(),
+		Charts:     len(contents.charts),
+		Issues:     []fsckIssue{},
+	}
+
+	indexed := make(map[string]bool)
+	for _, entry := range idx.Entries() {
+		report.Entries++
+
+		file := chartFileName(repo, entry.URL)
+		indexed[file] = true
+
+		item, ok := contents.charts[file]
+		switch {
+		case !ok:
+			report.Issues = append(report.Issues, fsckIssue{
+				Type:    fsckMissingObject,
+				Name:    entry.Name,
+				Version: entry.Version,
+				File:    file,
+				Message: "the index entry has no chart object",
+				fix: func(idx *helmutil.Index) error {
+					_, err := idx.Delete(entry.Name, entry.Version)
+					return err
+				},
+			})
+		case item.Hash != entry.Digest:
+			report.Issues = append(report.Issues, fsckIssue{
+				Type:    fsckDigestMismatch,
+				Name:    entry.Name,
+				Version: entry.Version,
+				File:    file,
+				Message: fmt.Sprintf("the index digest %s differs from the chart digest %s", entry.Digest, item.Hash),
+				fix: func(idx *helmutil.Index) error {
+					idx.SetDigest(entry.Name, entry.Version, item.Hash)
+					return nil
+				},
+			})
+		}
+	}
+
+	for _, file := range slices.Sorted(maps.Keys(contents.charts)) {
+		item := contents.charts[file]
+		name, version := item.Meta.Name(), item.Meta.Version()
+
+		if expected := name + "-" + version + ".tgz"; file != expected {
+			report.Issues = append(report.Issues, fsckIssue{
+				Type:    fsckNameMismatch,
+				Name:    name,
+				Version: version,
+				File:    file,
+				Message: fmt.Sprintf("the chart is %s %s, expected file name %s", name, version, expected),
+			})
+		}
+
+		if indexed[file] {
+			continue
+		}
+
+		issue := fsckIssue{
+			Type:    fsckUnindexedChart,
+			Name:    name,
+			Version: version,
+			File:    file,
+			Message: "the chart object is not in the index",
+		}
+		if idx.Has(name, version) {
+			issue.Message += ", and another index entry has the same version"
+		} else {
+			issue.fix = func(idx *helmutil.Index) error {
+				return idx.Add(item.Meta.Value(), file, "", item.Hash)
+			}
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	for _, file := range slices.Sorted(maps.Keys(contents.files)) {
+		chart, ok := strings.CutSuffix(file, ".prov")
+		if !ok || contents.files[chart] {
+			continue
+		}
+		report.Issues = append(report.Issues, fsckIssue{
+			Type:    fsckOrphanProv,
+			File:    file,
+			Message: "the provenance file has no chart object",
+		})
+	}
+
+	return report
+}
+
+func (act *fsckAction) printReport(report *fsckReport) error {
+	if act.output == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal report")
+		}
+		act.printer.Printf("%s\n", b)
+		return nil
+	}
+
+	act.printer.Printf(
+		"Checked %d index entries and %d charts in %s.\n",
+		report.Entries, report.Charts, report.Repository,
+	)
+	if len(report.Issues) == 0 {
+		act.printer.Printf("No issues found.\n")
+		return nil
+	}
+
+	repaired := 0
+	for _, issue := range report.Issues {
+		status := ""
+		if issue.Repaired {
+			status = " (repaired)"
+			repaired++
+		}
+		act.printer.Printf("  %-16s %s: %s%s\n", issue.Type, issue.File, issue.Message, status)
+	}
+	act.printer.Printf("Found %d issues, %d repaired.\n", len(report.Issues), repaired)
+	return nil
+}
+
+// chartFileName returns the chart file name in the repository by the chart
+// URL from the index.
+func chartFileName(repo helmutil.Repository, url string) string {
+	url = helmutil.TrimURLQuery(url)
+	if rel, ok := strings.CutPrefix(url, strings.TrimSuffix(helmutil.TrimURLQuery(repo.URL()), "/")+"/"); ok {
+		return rel
+	}
+	// Relative URL or an URL of the repository served via HTTP.
+	return path.Base(url)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+func TestFsckAction(t *testing.T) {
+	ctx := context.Background()
+	b := setupRepo(t)
+
+	push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+	require.NoError(t, push.run(ctx))
+
+	t.Run("should report no issues", func(t *testing.T) {
+		p := &testPrinter{}
+		act := &fsckAction{printer: p, repoOrURI: testRepoURI, output: "text"}
+		require.NoError(t, act.run(ctx))
+		assert.Contains(t, p.out.String(), "No issues found.")
+	})
+
+	// Break the repository: the pushed chart is unindexed, the index has an
+	// entry without chart object, and there is a provenance file without
+	// chart.
+	f, err := os.Open(testSignedChartPath)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = b.PutChart(ctx, testRepoURI+"/foo-1.3.1.tgz", f, "", "", "application/gzip", false, nil)
+	require.NoError(t, err)
+	_, err = b.PutChart(ctx, testRepoURI+"/bar-0.1.0.tgz.prov", strings.NewReader("prov"), "", "", "", false, nil)
+	require.NoError(t, err)
+
+	_, err = updateIndex(ctx, b, mustRepo(t), false, func(idx *helmutil.Index) (*helmutil.Index, error) {
+		meta := helmutil.NewChartMetadata()
+		if err := meta.UnmarshalJSON([]byte(`{"name":"baz","version":"0.1.0"}`)); err != nil {
+			return nil, err
+		}
+		if err := idx.Add(meta.Value(), "baz-0.1.0.tgz", "", "sha256:baz"); err != nil {
+			return nil, err
+		}
+		idx.SetDigest("foo", "1.2.3", "sha256:stale")
+		return idx, nil
+	})
+	require.NoError(t, err)
+
+	t.Run("should report issues", func(t *testing.T) {
+		p := &testPrinter{}
+		act := &fsckAction{printer: p, repoOrURI: testRepoURI, output: "json"}
+		assert.True(t, errorTypeSilent.Is(act.run(ctx)))
+
+		var report fsckReport
+		require.NoError(t, json.Unmarshal(p.out.Bytes(), &report))
+		assert.Equal(t, 2, report.Entries)
+		assert.Equal(t, 2, report.Charts)
+
+		var types []string
+		for _, issue := range report.Issues {
+			types = append(types, issue.Type+" "+issue.File)
+			assert.False(t, issue.Repaired)
+		}
+		assert.ElementsMatch(t, []string{
+			fsckMissingObject + " baz-0.1.0.tgz",
+			fsckDigestMismatch + " foo-1.2.3.tgz",
+			fsckUnindexedChart + " foo-1.3.1.tgz",
+			fsckOrphanProv + " bar-0.1.0.tgz.prov",
+		}, types)
+	})
+
+	t.Run("should repair the index", func(t *testing.T) {
+		p := &testPrinter{}
+		act := &fsckAction{printer: p, repoOrURI: testRepoURI, output: "text", repair: true}
+		assert.True(t, errorTypeSilent.Is(act.run(ctx)), "orphan provenance file cannot be repaired")
+		assert.Contains(t, p.out.String(), "Found 4 issues, 3 repaired.")
+
+		idx := loadRepoIndex(t, b)
+		assert.False(t, idx.Has("baz", "0.1.0"))
+		assert.True(t, idx.Has("foo", "1.3.1"))
+
+		digest, err := helmutil.DigestFile(testChartPath)
+		require.NoError(t, err)
+		for _, entry := range idx.Entries() {
+			if entry.Version == "1.2.3" {
+				assert.Equal(t, digest, entry.Digest)
+			}
+		}
+	})
+}
+
+// traverseRecordingBackend records the concurrency charts are traversed with.
+type traverseRecordingBackend struct {
+	*oss.MemoryBackend
+
+	concurrency int
+}
+
+func (b *traverseRecordingBackend) Traverse(ctx context.Context, repoURI string, concurrency int) (<-chan oss.ChartInfo, <-chan error) {
+	b.concurrency = concurrency
+	return b.MemoryBackend.Traverse(ctx, repoURI, concurrency)
+}
+
+func TestFsckAction_Concurrency(t *testing.T) {
+	b := &traverseRecordingBackend{MemoryBackend: setupRepo(t)}
+	mockBackend(t, b)
+
+	act := &fsckAction{printer: &testPrinter{}, repoOrURI: testRepoURI, output: "text", concurrency: 3}
+	require.NoError(t, act.run(context.Background()))
+	assert.Equal(t, 3, b.concurrency)
+}
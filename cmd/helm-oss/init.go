@@ -51,9 +51,12 @@ type initAction struct {
 }
 
 func (act *initAction) run(ctx context.Context) error {
-	storage := oss.New()
+	storage, err := newBackend(ctx, act.uri)
+	if err != nil {
+		return err
+	}
 
-	exists, err := storage.IndexExists(ctx, act.uri)
+	exists, err := oss.IndexExists(ctx, storage, act.uri)
 	if err != nil {
 		return fmt.Errorf("check if index exists in the storage: %v", err)
 	}
@@ -66,7 +69,10 @@ func (act *initAction) run(ctx context.Context) error {
 		return errors.WithMessage(err, "get index reader")
 	}
 
-	if err := storage.PutIndex(ctx, act.uri, r); err != nil {
+	if err := storage.PutIndex(ctx, act.uri, "", r); err != nil {
+		if errors.Is(err, oss.ErrIndexConflict) {
+			return act.alreadyExistsInStorageError()
+		}
 		return errors.WithMessage(err, "upload index to oss")
 	}
 
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+	"sigs.k8s.io/yaml"
+)
+
+const listDesc = `This command lists the charts in the repository.
+
+'helm oss list' takes two arguments:
+- REPO_OR_URI - target repository name or OSS URI,
+- CHART - optional chart name to list the versions of.
+
+The charts are read from the repository index, so OSS URIs can be listed
+without 'helm repo add' and 'helm repo update'. The size of each chart and
+whether it is signed, i.e. has a provenance file, are taken from the
+repository contents.
+
+With --version flag, only the versions matching the semver constraint are
+listed, e.g. '^1.2' or '>=1.0.0, <2.0.0'. Like in Helm, prerelease versions
+match only the constraints with a prerelease.
+
+The charts are sorted with --sort flag:
+- name: by name, newest versions first (default),
+- version: newest versions first,
+- created: newest charts first.
+`
+
+const listExample = `  helm oss list my-repo                                    - lists all charts in repository 'my-repo'
+  helm oss list my-repo foo --version '^1.2'               - lists versions of chart 'foo' matching the constraint
+  helm oss list --sort created -o json oss://bucket/charts - lists charts of OSS URI, newest first, in JSON`
+
+// Sort orders of the listed charts.
+const (
+	listSortName    = "name"
+	listSortVersion = "version"
+	listSortCreated = "created"
+)
+
+func newListCommand() *cobra.Command {
+	act := &listAction{
+		printer:   nil,
+		repoOrURI: "",
+		chart:     "",
+		version:   "",
+		sort:      listSortName,
+		reverse:   false,
+		output:    "table",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "list REPO_OR_URI [CHART]",
+		Aliases: []string{"ls"},
+		Short:   "List the charts in the repository.",
+		Long:    listDesc,
+		Example: listExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.RangeArgs(1, 2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO_OR_URI and CHART arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			if len(args) > 1 {
+				act.chart = args[1]
+			}
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Semver constraint of the listed versions.")
+	flags.StringVar(&act.sort, "sort", act.sort, "Sort order, one of: name, version, created.")
+	flags.BoolVar(&act.reverse, "reverse", act.reverse, "Reverse the sort order.")
+	flags.StringVarP(&act.output, "output", "o", act.output, "Output format, one of: table, json, yaml.")
+
+	return cmd
+}
+
+type listAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+	chart     string
+
+	// flags
+
+	version string
+	sort    string
+	reverse bool
+	output  string
+}
+
+// listItem is a chart version in the repository.
+type listItem struct {
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	AppVersion string    `json:"appVersion,omitempty"`
+	Created    time.Time `json:"created,omitzero"`
+	Digest     string    `json:"digest"`
+
+	// Size is the size of the chart object. It is zero if the chart is not
+	// stored in the repository.
+	Size int64 `json:"size,omitempty"`
+
+	// Signed is true if the chart has a provenance file.
+	Signed bool   `json:"signed"`
+	URL    string `json:"url"`
+}
+
+func (act *listAction) run(ctx context.Context) error {
+	switch act.output {
+	case "table", "json", "yaml":
+	default:
+		return newBadUsageError(fmt.Errorf("unsupported output format %q, must be one of: table, json, yaml", act.output))
+	}
+	switch act.sort {
+	case listSortName, listSortVersion, listSortCreated:
+	default:
+		return newBadUsageError(fmt.Errorf(
+			"unsupported sort order %q, must be one of: %s, %s, %s",
+			act.sort, listSortName, listSortVersion, listSortCreated,
+		))
+	}
+
+	var constraint *semver.Constraints
+	if act.version != "" {
+		c, err := semver.NewConstraint(act.version)
+		if err != nil {
+			return newBadUsageError(fmt.Errorf("invalid --version constraint %q: %w", act.version, err))
+		}
+		constraint = c
+	}
+
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, storage, repo)
+	if err != nil {
+		return err
+	}
+
+	objects, err := storage.ListObjects(ctx, repo.URL())
+	if err != nil {
+		return errors.WithMessage(err, "list repository objects")
+	}
+	sizes := make(map[string]int64, len(objects))
+	for _, obj := range objects {
+		sizes[path.Base(obj.URI)] = obj.Size
+	}
+
+	items := []listItem{}
+	for _, entry := range idx.Entries() {
+		if act.chart != "" && entry.Name != act.chart {
+			continue
+		}
+		if constraint != nil {
+			v, err := semver.NewVersion(entry.Version)
+			if err != nil || !constraint.Check(v) {
+				continue
+			}
+		}
+
+		file := chartFileName(repo, entry.URL)
+		_, signed := sizes[file+".prov"]
+		items = append(items, listItem{
+			Name:       entry.Name,
+			Version:    entry.Version,
+			AppVersion: entry.AppVersion,
+			Created:    entry.Created,
+			Digest:     entry.Digest,
+			Size:       sizes[file],
+			Signed:     signed,
+			URL:        entry.URL,
+		})
+	}
+
+	act.sortItems(items)
+	return act.printItems(items)
+}
+
+// sortItems sorts the items in the order set by the flags.
+func (act *listAction) sortItems(items []listItem) {
+	compare := func(a, b listItem) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), compareVersions(b.Version, a.Version))
+	}
+	switch act.sort {
+	case listSortVersion:
+		compare = func(a, b listItem) int {
+			return cmp.Or(compareVersions(b.Version, a.Version), strings.Compare(a.Name, b.Name))
+		}
+	case listSortCreated:
+		compare = func(a, b listItem) int {
+			return cmp.Or(b.Created.Compare(a.Created), strings.Compare(a.Name, b.Name))
+		}
+	}
+
+	slices.SortStableFunc(items, compare)
+	if act.reverse {
+		slices.Reverse(items)
+	}
+}
+
+func (act *listAction) printItems(items []listItem) error {
+	switch act.output {
+	case "json":
+		b, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal charts")
+		}
+		act.printer.Printf("%s\n", b)
+		return nil
+	case "yaml":
+		b, err := yaml.Marshal(items)
+		if err != nil {
+			return errors.Wrap(err, "marshal charts")
+		}
+		act.printer.Printf("%s", b)
+		return nil
+	}
+
+	if len(items) == 0 {
+		act.printer.Printf("No charts found.\n")
+		return nil
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tAPP VERSION\tCREATED\tSIZE\tDIGEST\tSIGNED")
+	for _, item := range items {
+		created, size, signed := "-", "-", "no"
+		if !item.Created.IsZero() {
+			created = item.Created.UTC().Format(time.RFC3339)
+		}
+		if item.Size > 0 {
+			size = formatSize(item.Size)
+		}
+		if item.Signed {
+			signed = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			item.Name, item.Version, cmp.Or(item.AppVersion, "-"), created, size, shortDigest(item.Digest), signed)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	act.printer.Printf("%s", b.String())
+	return nil
+}
+
+// compareVersions compares the chart versions as semver. Versions which are
+// not semver are compared as strings and precede the semver ones.
+func compareVersions(a, b string) int {
+	va, errA := semver.NewVersion(a)
+	vb, errB := semver.NewVersion(b)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// shortDigest returns the beginning of the digest, enough to tell the charts
+// apart.
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return cmp.Or(digest, "-")
+}
+
+// formatSize returns the human-readable size in bytes.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
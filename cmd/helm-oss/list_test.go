@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+func TestListAction(t *testing.T) {
+	ctx := context.Background()
+	setupRepo(t)
+
+	pushTestCharts(t)
+
+	list := func(t *testing.T, act listAction) []listItem {
+		t.Helper()
+
+		p := &testPrinter{}
+		act.printer = p
+		act.repoOrURI = testRepoURI
+		if act.sort == "" {
+			act.sort = listSortName
+		}
+		act.output = "json"
+		require.NoError(t, act.run(ctx))
+
+		var items []listItem
+		require.NoError(t, json.Unmarshal(p.out.Bytes(), &items))
+		return items
+	}
+
+	t.Run("should list all charts, newest versions first", func(t *testing.T) {
+		items := list(t, listAction{})
+		require.Len(t, items, 2)
+		assert.Equal(t, "1.3.1", items[0].Version)
+		assert.True(t, items[0].Signed)
+		assert.Equal(t, "1.2.3", items[1].Version)
+		assert.False(t, items[1].Signed)
+		for _, item := range items {
+			assert.Equal(t, "foo", item.Name)
+			assert.NotEmpty(t, item.Digest)
+			assert.Positive(t, item.Size)
+			assert.False(t, item.Created.IsZero())
+		}
+	})
+
+	t.Run("should filter by semver constraint", func(t *testing.T) {
+		items := list(t, listAction{chart: "foo", version: "~1.2"})
+		require.Len(t, items, 1)
+		assert.Equal(t, "1.2.3", items[0].Version)
+
+		assert.Empty(t, list(t, listAction{chart: "bar"}))
+	})
+
+	t.Run("should sort in reverse", func(t *testing.T) {
+		items := list(t, listAction{sort: listSortVersion, reverse: true})
+		require.Len(t, items, 2)
+		assert.Equal(t, "1.2.3", items[0].Version)
+	})
+
+	t.Run("should print table", func(t *testing.T) {
+		p := &testPrinter{}
+		act := &listAction{printer: p, repoOrURI: testRepoURI, sort: listSortName, output: "table"}
+		require.NoError(t, act.run(ctx))
+
+		lines := strings.Split(strings.TrimSpace(p.out.String()), "\n")
+		require.Len(t, lines, 3)
+		assert.Equal(t, []string{"NAME", "VERSION", "APP", "VERSION", "CREATED", "SIZE", "DIGEST", "SIGNED"}, strings.Fields(lines[0]))
+		fields := strings.Fields(lines[1])
+		assert.Equal(t, "foo", fields[0])
+		assert.Equal(t, "1.3.1", fields[1])
+		assert.Equal(t, "yes", fields[len(fields)-1])
+	})
+
+	t.Run("should print yaml", func(t *testing.T) {
+		p := &testPrinter{}
+		act := &listAction{printer: p, repoOrURI: testRepoURI, sort: listSortName, output: "yaml"}
+		require.NoError(t, act.run(ctx))
+
+		var items []listItem
+		require.NoError(t, yaml.Unmarshal(p.out.Bytes(), &items))
+		assert.Len(t, items, 2)
+	})
+
+	t.Run("should reject bad usage", func(t *testing.T) {
+		for _, act := range []*listAction{
+			{printer: &testPrinter{}, repoOrURI: testRepoURI, sort: listSortName, output: "xml"},
+			{printer: &testPrinter{}, repoOrURI: testRepoURI, sort: "size", output: "table"},
+			{printer: &testPrinter{}, repoOrURI: testRepoURI, sort: listSortName, output: "table", version: "not a constraint"},
+		} {
+			assert.True(t, errorTypeBadUsage.Is(act.run(ctx)))
+		}
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Negative(t, compareVersions("1.2.3", "1.10.0"))
+	assert.Negative(t, compareVersions("1.0.0-rc.1", "1.0.0"))
+	assert.Positive(t, compareVersions("0.1.0", "latest"))
+	assert.Zero(t, compareVersions("1.0", "1.0.0"))
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512 B", formatSize(512))
+	assert.Equal(t, "1.5 KiB", formatSize(1536))
+	assert.Equal(t, "2.0 MiB", formatSize(2<<20))
+}
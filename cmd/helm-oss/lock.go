@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+)
+
+const lockDesc = `This command manages the repository lock.
+
+Push, delete and reindex hold the repository lock while they update the index,
+so that parallel jobs against one repository are serialised. The lock is stored
+as '.helm-oss.lock' object next to index.yaml and expires automatically. Its
+lease is renewed in '.helm-oss.lock.lease-<id>' object while the operation runs.
+`
+
+const lockStatusDesc = `This command shows the holder of the repository lock.
+
+'helm oss lock status' takes one argument:
+- REPO_OR_URI - target repository name or OSS URI.
+`
+
+const lockStatusExample = `  helm oss lock status my-repo              - shows lock of repository 'my-repo'
+  helm oss lock status oss://bucket/charts - shows lock of OSS URI directly`
+
+const lockBreakDesc = `This command removes the repository lock.
+
+'helm oss lock break' takes one argument:
+- REPO_OR_URI - target repository name or OSS URI.
+
+Only an expired lock is removed unless --force flag is specified. Breaking a lock
+that is still in use may lead to lost index updates; use it with care.
+`
+
+const lockBreakExample = `  helm oss lock break my-repo                     - removes expired lock of repository 'my-repo'
+  helm oss lock break --force oss://bucket/charts - removes lock of OSS URI even if it is not expired`
+
+func newLockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Manage the repository lock.",
+		Long:  lockDesc,
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+
+	cmd.AddCommand(
+		newLockStatusCommand(),
+		newLockBreakCommand(),
+	)
+
+	return cmd
+}
+
+func newLockStatusCommand() *cobra.Command {
+	act := &lockStatusAction{
+		printer:   nil,
+		repoOrURI: "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "status REPO_OR_URI",
+		Short:   "Show the repository lock holder.",
+		Long:    lockStatusDesc,
+		Example: lockStatusExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO_OR_URI argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+type lockStatusAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+}
+
+func (act *lockStatusAction) run(ctx context.Context) error {
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	lock, found, err := storage.ReadLock(ctx, repo.URL())
+	if err != nil {
+		return errors.WithMessage(err, "read repository lock")
+	}
+	if !found {
+		act.printer.Printf("Repository %s is not locked.\n", act.repoOrURI)
+		return nil
+	}
+
+	state := "active"
+	if lock.Expired(time.Now()) {
+		state = "expired"
+	}
+
+	act.printer.Printf("Repository %s is locked (%s).\n\n", act.repoOrURI, state)
+	act.printer.Printf("  Owner:     %s\n", lock.Owner)
+	act.printer.Printf("  Host:      %s\n", lock.Host)
+	act.printer.Printf("  PID:       %d\n", lock.PID)
+	act.printer.Printf("  Operation: %s\n", lock.Operation)
+	act.printer.Printf("  Acquired:  %s\n", lock.Acquired.Format(time.RFC3339))
+	act.printer.Printf("  Expires:   %s\n", lock.Expires.Format(time.RFC3339))
+	return nil
+}
+
+func newLockBreakCommand() *cobra.Command {
+	act := &lockBreakAction{
+		printer:   nil,
+		repoOrURI: "",
+		force:     false,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "break REPO_OR_URI",
+		Short:   "Remove the repository lock.",
+		Long:    lockBreakDesc,
+		Example: lockBreakExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO_OR_URI argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&act.force, "force", act.force, "Remove the lock even if it has not expired yet.")
+
+	return cmd
+}
+
+type lockBreakAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+
+	// flags
+
+	force bool
+}
+
+func (act *lockBreakAction) run(ctx context.Context) error {
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	lock, found, err := storage.ReadLock(ctx, repo.URL())
+	if err != nil {
+		return errors.WithMessage(err, "read repository lock")
+	}
+	if !found {
+		act.printer.Printf("Repository %s is not locked.\n", act.repoOrURI)
+		return nil
+	}
+
+	if !lock.Expired(time.Now()) && !act.force {
+		act.printer.PrintErrf(
+			"The lock is held by %s@%s (operation %q) and expires at %s.\n\n"+
+				"If you are sure the lock holder is gone, use --force flag:\n\n"+
+				"  helm oss lock break --force %s\n\n",
+			lock.Owner, lock.Host, lock.Operation, lock.Expires.Format(time.RFC3339), act.repoOrURI,
+		)
+		return newSilentError()
+	}
+
+	// Only the lock shown above is removed, not a lock which has been taken
+	// over since.
+	if err := storage.BreakLock(ctx, repo.URL(), lock.ID); err != nil {
+		return errors.WithMessage(err, "break repository lock")
+	}
+
+	act.printer.Printf("Successfully removed the lock held by %s@%s.\n", lock.Owner, lock.Host)
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
 
 	"helm-oss/internal/helmutil"
@@ -15,7 +17,17 @@ func main() {
 
 	cmd := newRootCmd()
 
-	if err := cmd.Execute(); err != nil {
+	ctx, stop := notifyContext(context.Background(), os.Stderr)
+	err := cmd.ExecuteContext(ctx)
+	stop()
+
+	if err != nil {
+		var sigErr signalError
+		if errors.As(context.Cause(ctx), &sigErr) {
+			cmd.PrintErrln("Error:", err.Error())
+			os.Exit(sigErr.exitCode())
+		}
+
 		if errorTypeSilent.Is(err) {
 			os.Exit(1)
 		}
@@ -1,19 +1,67 @@
 package main
 
 import (
+	"maps"
+	"slices"
 	"time"
+
+	"github.com/spf13/cobra"
+	"helm-oss/internal/oss"
+)
+
+// Environment variables setting the defaults of the global flags.
+const (
+	timeoutEnv   = "HELM_OSS_TIMEOUT"
+	logLevelEnv  = "HELM_OSS_LOG_LEVEL"
+	logFormatEnv = "HELM_OSS_LOG_FORMAT"
 )
 
 // options represents global command options (global flags).
 type options struct {
 	timeout time.Duration
 	verbose bool
+	profile string
+
+	// Logging options, see newLogger.
+	logLevel  string
+	logFormat string
+
+	// Connection options. The zero values keep the configured ones.
+	connectTimeout   time.Duration
+	readWriteTimeout time.Duration
+	retryMaxAttempts int
+	proxy            string
 }
 
 // newDefaultOptions returns default options.
 func newDefaultOptions() *options {
 	return &options{
-		timeout: 5 * time.Minute,
-		verbose: false,
+		timeout:          5 * time.Minute,
+		verbose:          false,
+		profile:          "",
+		logLevel:         "",
+		logFormat:        logFormatText,
+		connectTimeout:   0,
+		readWriteTimeout: 0,
+		retryMaxAttempts: 0,
+		proxy:            "",
+	}
+}
+
+// configOptions returns the options of the configuration set with the flags.
+// The flags in configFlags override the configuration keys if they are set.
+func (opts *options) configOptions(cmd *cobra.Command) oss.ConfigOptions {
+	co := oss.ConfigOptions{
+		Profile: opts.profile,
+	}
+	for _, name := range slices.Sorted(maps.Keys(configFlags)) {
+		if f := cmd.Flags().Lookup(name); f != nil && f.Changed {
+			co.Overrides = append(co.Overrides, oss.ConfigOverride{
+				Key:    configFlags[name],
+				Value:  f.Value.String(),
+				Source: "flag --" + name,
+			})
+		}
 	}
+	return co
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+const pullDesc = `This command downloads a chart from the repository.
+
+'helm oss pull' takes two arguments:
+- NAME - name of the chart,
+- REPO_OR_URI - target repository name or OSS URI.
+
+The version is resolved from the repository index. It is either exact or a
+semver constraint, e.g. '^1.2'. If it is not set, the newest version which is
+not a prerelease is pulled.
+
+[Verification]
+
+The SHA-256 digest of the downloaded chart is checked against the digest in
+the index, so that a chart replaced in the bucket is refused.
+
+With --verify flag, the provenance file of the chart is downloaded as well and
+its signature is verified with the public keys from the keyring (--keyring,
+defaults to ~/.gnupg/pubring.gpg). An unsigned chart is refused.
+
+Nothing is written to the destination unless all checks pass.
+`
+
+const pullExample = `  helm oss pull epicservice my-repo                                      - pulls the newest version from repository 'my-repo'
+  helm oss pull epicservice --version 0.5.1 --verify oss://bucket/charts - pulls the version from OSS URI and verifies its signature
+  helm oss pull epicservice --untar -d ./charts my-repo                  - pulls and extracts the chart into ./charts/epicservice`
+
+func newPullCommand() *cobra.Command {
+	act := &pullAction{
+		printer:     nil,
+		chartName:   "",
+		repoOrURI:   "",
+		version:     "",
+		verify:      false,
+		keyring:     helmutil.DefaultKeyring(),
+		untar:       false,
+		destination: ".",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "pull NAME REPO_OR_URI",
+		Aliases: []string{"fetch"},
+		Short:   "Download a chart from the repository.",
+		Long:    pullDesc,
+		Example: pullExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the NAME and REPO_OR_URI arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.chartName = args[0]
+			act.repoOrURI = args[1]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Version or semver constraint of the chart.")
+	flags.BoolVar(&act.verify, "verify", act.verify, "Verify the provenance file of the chart.")
+	flags.StringVar(&act.keyring, "keyring", act.keyring, "Keyring with the public keys used for verification.")
+	flags.BoolVar(&act.untar, "untar", act.untar, "Extract the chart after downloading it.")
+	flags.StringVarP(&act.destination, "destination", "d", act.destination, "Directory to write the chart to.")
+
+	return cmd
+}
+
+type pullAction struct {
+	printer printer
+
+	// args
+
+	chartName string
+	repoOrURI string
+
+	// flags
+
+	version     string
+	verify      bool
+	keyring     string
+	untar       bool
+	destination string
+}
+
+func (act *pullAction) run(ctx context.Context) error {
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, storage, repo)
+	if err != nil {
+		return err
+	}
+
+	entry, err := findChartVersion(idx, act.chartName, act.version)
+	if err != nil {
+		return err
+	}
+	uri := helmutil.JoinURL(repo.URL(), chartFileName(repo, entry.URL))
+	filename := path.Base(uri)
+
+	// The chart is downloaded to a temporary directory, and only copied to
+	// the destination when all checks pass.
+	tmpDir, err := os.MkdirTemp("", "helm-oss-pull-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	chartPath := filepath.Join(tmpDir, filename)
+	if err := download(ctx, storage, uri, chartPath); err != nil {
+		return errors.WithMessagef(err, "download chart %s", filename)
+	}
+
+	if err := act.checkDigest(entry, chartPath); err != nil {
+		return err
+	}
+
+	provPath := ""
+	if act.verify {
+		provPath = chartPath + ".prov"
+		err := download(ctx, storage, uri+".prov", provPath)
+		if errors.Is(err, oss.ErrObjectNotFound) {
+			return fmt.Errorf("chart %s %s is not signed: %s.prov not found", entry.Name, entry.Version, filename)
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "download provenance file %s.prov", filename)
+		}
+
+		v, err := helmutil.VerifyChart(chartPath, provPath, act.keyring)
+		if err != nil {
+			return errors.WithMessagef(err, "verify chart %s %s", entry.Name, entry.Version)
+		}
+		act.printer.Printf("Signed by: %s\n", strings.Join(v.SignedBy, ", "))
+		act.printer.Printf("Using Key With Fingerprint: %s\n", v.Fingerprint)
+		act.printer.Printf("Chart Hash Verified: %s\n", v.FileHash)
+	}
+
+	if err := os.MkdirAll(act.destination, 0o755); err != nil {
+		return errors.Wrap(err, "create destination directory")
+	}
+
+	if act.untar {
+		dir := filepath.Join(act.destination, entry.Name)
+		if _, err := os.Stat(dir); err == nil {
+			return fmt.Errorf("cannot extract the chart: %s already exists", dir)
+		}
+		if err := helmutil.ExpandChart(act.destination, chartPath); err != nil {
+			return err
+		}
+		act.printer.Printf("Pulled %s %s and extracted it to %s\n", entry.Name, entry.Version, dir)
+		return nil
+	}
+
+	dest := filepath.Join(act.destination, filename)
+	if err := copyFile(chartPath, dest); err != nil {
+		return err
+	}
+	if provPath != "" {
+		if err := copyFile(provPath, dest+".prov"); err != nil {
+			return err
+		}
+	}
+	act.printer.Printf("Pulled %s %s to %s\n", entry.Name, entry.Version, dest)
+	return nil
+}
+
+// checkDigest checks the SHA-256 digest of the downloaded chart against the
+// digest in the index.
+func (act *pullAction) checkDigest(entry helmutil.IndexEntry, chartPath string) error {
+	digest, err := helmutil.DigestFile(chartPath)
+	if err != nil {
+		return errors.Wrap(err, "compute chart digest")
+	}
+
+	expected := strings.TrimPrefix(strings.ToLower(entry.Digest), "sha256:")
+	if expected == "" {
+		act.printer.PrintErrf("WARNING: the index has no digest of %s %s, the digest is not checked\n", entry.Name, entry.Version)
+		return nil
+	}
+	if digest != expected {
+		return fmt.Errorf(
+			"digest mismatch of chart %s %s: the index has %s, but the downloaded chart has %s; "+
+				"the chart object may have been replaced, run `helm oss fsck` to check the repository",
+			entry.Name, entry.Version, expected, digest,
+		)
+	}
+	return nil
+}
+
+// download streams the object by uri to the file.
+func download(ctx context.Context, storage oss.Backend, uri, fpath string) error {
+	r, err := storage.Open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := os.Create(fpath)
+	if err != nil {
+		return errors.Wrap(err, "create file")
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return errors.Wrap(err, "write file")
+	}
+	return errors.Wrap(f.Close(), "write file")
+}
+
+// copyFile copies the file from src to dst, replacing dst.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+	if err := os.WriteFile(dst, data, 0o644); err != nil {
+		return errors.Wrapf(err, "write %s", dst)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKeyring = "../../testdata/pgp/test-public.gpg"
+
+func TestPullAction(t *testing.T) {
+	ctx := context.Background()
+	b := setupRepo(t)
+
+	pushTestCharts(t)
+
+	pull := func(t *testing.T, act pullAction) (*testPrinter, string, error) {
+		t.Helper()
+
+		p := &testPrinter{}
+		act.printer = p
+		act.chartName = "foo"
+		act.repoOrURI = testRepoURI
+		act.keyring = testKeyring
+		act.destination = t.TempDir()
+		return p, act.destination, act.run(ctx)
+	}
+
+	t.Run("should pull and verify the newest version", func(t *testing.T) {
+		p, dest, err := pull(t, pullAction{verify: true})
+		require.NoError(t, err)
+		assert.Contains(t, p.out.String(), "Signed by: Test Key (helm-s3) <test@example.org>")
+		assert.Contains(t, p.out.String(), "Chart Hash Verified: sha256:")
+
+		data, err := os.ReadFile(filepath.Join(dest, "foo-1.3.1.tgz"))
+		require.NoError(t, err)
+		expected, err := os.ReadFile(testSignedChartPath)
+		require.NoError(t, err)
+		assert.Equal(t, expected, data)
+		assert.FileExists(t, filepath.Join(dest, "foo-1.3.1.tgz.prov"))
+	})
+
+	t.Run("should untar", func(t *testing.T) {
+		_, dest, err := pull(t, pullAction{version: "1.2.3", untar: true})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dest, "foo", "Chart.yaml"))
+		assert.NoFileExists(t, filepath.Join(dest, "foo-1.2.3.tgz"))
+	})
+
+	t.Run("should refuse unsigned chart", func(t *testing.T) {
+		_, dest, err := pull(t, pullAction{version: "1.2.3", verify: true})
+		assert.ErrorContains(t, err, "chart foo 1.2.3 is not signed")
+		assert.NoFileExists(t, filepath.Join(dest, "foo-1.2.3.tgz"))
+	})
+
+	t.Run("should refuse provenance of another chart", func(t *testing.T) {
+		prov, err := os.ReadFile("../../testdata/foo-1.3.1.tgz.prov")
+		require.NoError(t, err)
+		require.NoError(t, b.PutObject(ctx, testRepoURI+"/foo-1.2.3.tgz.prov", bytes.NewReader(prov)))
+		t.Cleanup(func() {
+			require.NoError(t, b.DeleteChart(ctx, testRepoURI+"/foo-1.2.3.tgz.prov"))
+		})
+
+		_, dest, err := pull(t, pullAction{version: "1.2.3", verify: true})
+		assert.ErrorContains(t, err, "verify chart foo 1.2.3")
+		assert.NoFileExists(t, filepath.Join(dest, "foo-1.2.3.tgz"))
+	})
+
+	t.Run("should refuse tampered chart", func(t *testing.T) {
+		tampered, err := os.ReadFile(testChartPath)
+		require.NoError(t, err)
+		require.NoError(t, b.PutObject(ctx, testRepoURI+"/foo-1.3.1.tgz", bytes.NewReader(tampered)))
+
+		_, dest, err := pull(t, pullAction{version: "1.3.1"})
+		assert.ErrorContains(t, err, "digest mismatch of chart foo 1.3.1")
+		entries, err := os.ReadDir(dest)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
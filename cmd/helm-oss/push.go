@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -15,16 +18,24 @@ import (
 const pushDesc = `This command uploads a chart to the repository.
 
 'helm oss push' takes two arguments:
-- PATH - path to the chart file,
+- PATH - path to the chart file or the chart directory,
 - REPO_OR_URI - target repository name or OSS URI.
 
+A chart directory is packaged before the upload, like with 'helm package'.
+
 [Provenance]
 
 If the chart is signed, the provenance file is uploaded to the repository as well.
+
+With --sign flag, the chart is signed with the key --key from the secret keyring
+(--keyring, defaults to ~/.gnupg/secring.gpg) and the generated provenance file
+is uploaded instead. If the key is encrypted, its passphrase is read from the
+first line of --passphrase-file, or from stdin if it is '-'.
 `
 
-const pushExample = `  helm oss push ./epicservice-0.5.1.tgz my-repo              - uploads to repository 'my-repo' (configured via helm repo add)
-  helm oss push ./epicservice-0.5.1.tgz oss://bucket/charts - uploads directly to OSS URI`
+const pushExample = `  helm oss push ./epicservice-0.5.1.tgz my-repo                                - uploads to repository 'my-repo' (configured via helm repo add)
+  helm oss push ./epicservice-0.5.1.tgz oss://bucket/charts                   - uploads directly to OSS URI
+  helm oss push --sign --key 'CI' --keyring ci-secring.gpg ./epicservice my-repo - packages, signs and uploads the chart directory`
 
 func newPushCommand() *cobra.Command {
 	act := &pushAction{
@@ -33,6 +44,11 @@ func newPushCommand() *cobra.Command {
 		repoOrURI: "",
 		dryRun:    false,
 		force:     false,
+
+		sign:           false,
+		key:            "",
+		keyring:        helmutil.DefaultSecretKeyring(),
+		passphraseFile: "",
 	}
 
 	cmd := &cobra.Command{
@@ -60,6 +76,10 @@ func newPushCommand() *cobra.Command {
 	flags := cmd.Flags()
 	flags.BoolVar(&act.dryRun, "dry-run", act.dryRun, "Simulate push operation, but don't actually touch anything.")
 	flags.BoolVar(&act.force, "force", act.force, "Replace the chart if it already exists. This can cause the repository to lose existing chart; use it with care.")
+	flags.BoolVar(&act.sign, "sign", act.sign, "Sign the chart and upload the generated provenance file.")
+	flags.StringVar(&act.key, "key", act.key, "Name of the key used for signing.")
+	flags.StringVar(&act.keyring, "keyring", act.keyring, "Secret keyring with the key used for signing.")
+	flags.StringVar(&act.passphraseFile, "passphrase-file", act.passphraseFile, "File with the passphrase of the signing key, or '-' to read it from stdin.")
 
 	return cmd
 }
@@ -76,10 +96,36 @@ type pushAction struct {
 
 	dryRun bool
 	force  bool
+
+	sign           bool
+	key            string
+	keyring        string
+	passphraseFile string
 }
 
 func (act *pushAction) run(ctx context.Context) error {
-	chart, err := helmutil.LoadChart(act.chartPath)
+	if act.sign && act.key == "" {
+		return newBadUsageError(errors.New("--key is required with --sign"))
+	}
+
+	// The packaged chart and the provenance file are written to a temporary
+	// directory, so that nothing is left next to the chart.
+	tmpDir, err := os.MkdirTemp("", "helm-oss-push-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	chartPath := act.chartPath
+	if fi, err := os.Stat(chartPath); err == nil && fi.IsDir() {
+		chartPath, err = helmutil.PackageChart(chartPath, tmpDir)
+		if err != nil {
+			return err
+		}
+		act.printer.Printf("Packaged the chart to %s\n", filepath.Base(chartPath))
+	}
+
+	chart, err := helmutil.LoadChart(chartPath)
 	if err != nil {
 		return err
 	}
@@ -101,21 +147,28 @@ func (act *pushAction) run(ctx context.Context) error {
 		}
 	}
 
-	hasProv := false
-	provFile, err := os.Open(act.chartPath + ".prov")
-	switch {
-	case err == nil:
-		hasProv = true
-	case errors.Is(err, os.ErrNotExist):
+	provFile := chartPath + ".prov"
+	if act.sign {
+		provFile, err = act.signChart(chartPath, tmpDir)
+		if err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(provFile); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("open prov file: %w", err)
+		}
 		// No provenance file, ignore it.
-	default:
-		return fmt.Errorf("open prov file: %w", err)
+		provFile = ""
 	}
 
-	fname := filepath.Base(act.chartPath)
+	fname := filepath.Base(chartPath)
 
-	storage := oss.New()
-	exists, err := storage.Exists(ctx, repo.URL()+"/"+fname)
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+	chartURI := helmutil.JoinURL(repo.URL(), fname)
+	exists, err := storage.Exists(ctx, chartURI)
 	if err != nil {
 		return errors.WithMessage(err, "check if chart already exists in the repository")
 	}
@@ -124,74 +177,114 @@ func (act *pushAction) run(ctx context.Context) error {
 		return act.chartExistsError()
 	}
 
-	chartFile, err := os.Open(act.chartPath)
+	hash, err := helmutil.DigestFile(chartPath)
 	if err != nil {
-		return errors.Wrap(err, "open chart file")
+		return errors.WithMessage(err, "get chart digest")
 	}
 
-	hash, err := helmutil.DigestFile(act.chartPath)
-	if err != nil {
-		return errors.WithMessage(err, "get chart digest")
+	// Use relative URLs to support both OSS plugin and HTTP access
+	baseURL := ""
+
+	addChart := func(idx *helmutil.Index) (*helmutil.Index, error) {
+		if err := idx.AddOrReplace(chart.Metadata().Value(), fname, baseURL, hash); err != nil {
+			return nil, errors.WithMessage(err, "add/replace chart in the index")
+		}
+		idx.SortEntries()
+		idx.UpdateGeneratedTime()
+		return idx, nil
 	}
 
-	if !act.dryRun {
-		chartMetaJSON, err := chart.Metadata().MarshalJSON()
+	if act.dryRun {
+		idx, _, err := fetchIndex(ctx, storage, repo)
 		if err != nil {
 			return err
 		}
-		if _, err := storage.PutChart(
-			ctx,
-			repo.URL()+"/"+fname,
-			chartFile,
-			string(chartMetaJSON),
-			hash,
-			"application/gzip",
-			hasProv,
-			provFile,
-		); err != nil {
-			return errors.WithMessage(err, "upload chart to oss")
+		if _, err := addChart(idx); err != nil {
+			return err
 		}
+		act.printer.Printf("Successfully uploaded the chart to the repository.\n")
+		return nil
 	}
 
-	// The gap between index fetching and uploading should be as small as
-	// possible to make the best effort to avoid race conditions.
-	// See https://github.com/hypnoglow/helm-s3/issues/18 for more info.
-
-	// Fetch current index, update it and upload it back.
-
-	b, err := storage.FetchRaw(ctx, repo.IndexURL())
+	chartMetaJSON, err := chart.Metadata().MarshalJSON()
 	if err != nil {
-		return errors.WithMessage(err, "fetch current repo index")
-	}
-
-	idx := helmutil.NewIndex()
-	if err := idx.UnmarshalBinary(b); err != nil {
-		return errors.WithMessage(err, "load index from downloaded file")
+		return err
 	}
 
-	// Use relative URLs to support both OSS plugin and HTTP access
-	baseURL := ""
+	var idx *helmutil.Index
+	err = withRepoLock(ctx, act.printer, storage, repo, "push", func(ctx context.Context) error {
+		exists, err := storage.Exists(ctx, chartURI)
+		if err != nil {
+			return errors.WithMessage(err, "check if chart already exists in the repository")
+		}
+		if exists && !act.force {
+			return act.chartExistsError()
+		}
 
-	if err := idx.AddOrReplace(chart.Metadata().Value(), fname, baseURL, hash); err != nil {
-		return errors.WithMessage(err, "add/replace chart in the index")
-	}
-	idx.SortEntries()
-	idx.UpdateGeneratedTime()
+		// The chart objects and the index are updated under the lock, so
+		// nobody can change them between the snapshot and a rollback.
+		snapshot, err := takeChartSnapshot(ctx, storage, chartURI)
+		if err != nil {
+			return err
+		}
 
-	idxReader, err := idx.Reader()
-	if err != nil {
-		return errors.WithMessage(err, "get index reader")
-	}
+		journal, err := beginJournal(ctx, storage, repo, "push", &oss.JournalChart{
+			Name:    chart.Name(),
+			Version: chart.Version(),
+			URL:     fname,
+			Digest:  hash,
+			Prov:    provFile != "",
+		})
+		if err != nil {
+			return err
+		}
 
-	if !act.dryRun {
-		if err := storage.PutIndex(ctx, repo.URL(), idxReader); err != nil {
-			return errors.WithMessage(err, "upload index to oss")
+		err = act.upload(ctx, storage, chartURI, chartPath, string(chartMetaJSON), hash, provFile)
+		if err == nil {
+			// Fetch current index, update it and upload it back. The index is
+			// uploaded conditionally, so if somebody else has updated it in the
+			// meantime, the whole cycle is repeated with the fresh index.
+			// See https://github.com/hypnoglow/helm-s3/issues/18 for more info.
+			idx, err = updateIndex(ctx, storage, repo, false, addChart)
+			if err != nil && !indexUnchanged(err) {
+				// The index may reference the uploaded chart already, so it
+				// is not rolled back. Keep the journal, so that the push can
+				// be completed by recover.
+				return errors.WithMessagef(
+					err, "update index (run `helm oss recover %s` to finish the push)", repo.URL(),
+				)
+			}
+		}
+		if err != nil {
+			if rbErr := act.rollback(ctx, storage, snapshot); rbErr != nil {
+				// Keep the journal, so that the push can be recovered later.
+				return errors.WithMessagef(
+					err, "rollback failed (run `helm oss recover %s` to fix the repository): %v", repo.URL(), rbErr,
+				)
+			}
+			completeJournal(ctx, act.printer, storage, repo, journal)
+			return err
 		}
 
-		if repo.ShouldUpdateCache() {
-			if err := idx.WriteFile(repo.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
-				return errors.WithMessage(err, "update local index")
+		updateReindexState(ctx, act.printer, storage, repo, func(state *reindexState) error {
+			obj, err := storage.StatObject(ctx, chartURI)
+			if err != nil {
+				return errors.WithMessage(err, "get pushed chart info")
 			}
+			state.Charts[fname] = newReindexRecord(obj, hash)
+			return nil
+		})
+
+		completeJournal(ctx, act.printer, storage, repo, journal)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if repo.ShouldUpdateCache() {
+		if err := idx.WriteFile(repo.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+			return errors.WithMessage(err, "update local index")
 		}
 	}
 
@@ -209,3 +302,104 @@ func (act *pushAction) chartExistsError() error {
 	)
 	return newSilentError()
 }
+
+// signChart signs the chart file and writes the provenance file to dir.
+// It returns the path to the provenance file.
+func (act *pushAction) signChart(chartPath, dir string) (string, error) {
+	sig, err := helmutil.SignChart(chartPath, act.keyring, act.key, act.passphrase)
+	if err != nil {
+		return "", errors.WithMessage(err, "sign chart")
+	}
+
+	provFile := filepath.Join(dir, filepath.Base(chartPath)+".prov")
+	if err := os.WriteFile(provFile, []byte(sig), 0o644); err != nil {
+		return "", errors.Wrap(err, "write prov file")
+	}
+	act.printer.Printf("Signed the chart with key %q\n", act.key)
+	return provFile, nil
+}
+
+// passphrase reads the passphrase of the signing key from the first line of
+// the passphrase file. It is only called if the key is encrypted.
+func (act *pushAction) passphrase(name string) ([]byte, error) {
+	if act.passphraseFile == "" {
+		return nil, fmt.Errorf("key %q is encrypted, set its passphrase with --passphrase-file", name)
+	}
+
+	var r io.Reader = os.Stdin
+	if act.passphraseFile != "-" {
+		f, err := os.Open(act.passphraseFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "open passphrase file")
+		}
+		defer f.Close()
+		r = f
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrap(err, "read passphrase")
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// upload puts the chart file and its provenance file, if provFile is not
+// empty, to the repository.
+func (act *pushAction) upload(
+	ctx context.Context,
+	storage oss.Backend,
+	uri string,
+	chartPath string,
+	chartMeta string,
+	hash string,
+	provFile string,
+) error {
+	chartFile, err := os.Open(chartPath)
+	if err != nil {
+		return errors.Wrap(err, "open chart file")
+	}
+	defer chartFile.Close()
+
+	var prov io.Reader
+	if provFile != "" {
+		f, err := os.Open(provFile)
+		if err != nil {
+			return fmt.Errorf("open prov file: %w", err)
+		}
+		defer f.Close()
+		prov = f
+	}
+
+	if _, err := storage.PutChart(
+		ctx,
+		uri,
+		chartFile,
+		chartMeta,
+		hash,
+		"application/gzip",
+		prov != nil,
+		prov,
+	); err != nil {
+		return errors.WithMessage(err, "upload chart to oss")
+	}
+
+	return nil
+}
+
+// rollback restores the chart objects from snapshot after the push has
+// failed, and reports what was rolled back.
+func (act *pushAction) rollback(ctx context.Context, storage oss.Backend, snapshot *chartSnapshot) error {
+	rollbackCtx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	steps, err := snapshot.restore(rollbackCtx, storage)
+	for _, step := range steps {
+		act.printer.PrintErrf("Rolled back: %s\n", step)
+	}
+	if err != nil {
+		act.printer.PrintErrf("Rollback failed, the repository may contain chart objects not referenced by the index: %s\n", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+	"helm-oss/internal/osstest"
+)
+
+func TestPushAction(t *testing.T) {
+	b := setupRepo(t)
+
+	act := &pushAction{
+		printer:   &testPrinter{},
+		chartPath: testChartPath,
+		repoOrURI: testRepoURI,
+	}
+	require.NoError(t, act.run(context.Background()))
+
+	exists, err := b.Exists(context.Background(), testRepoURI+"/foo-1.2.3.tgz")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	assert.True(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+
+	t.Run("should refuse to overwrite existing chart", func(t *testing.T) {
+		err := act.run(context.Background())
+		assert.True(t, errorTypeSilent.Is(err))
+	})
+
+	t.Run("should overwrite existing chart with force", func(t *testing.T) {
+		act.force = true
+		defer func() { act.force = false }()
+
+		require.NoError(t, act.run(context.Background()))
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+	})
+}
+
+// conflictingBackend modifies the index right before the first PutIndex
+// call, emulating a concurrent writer.
+type conflictingBackend struct {
+	*oss.MemoryBackend
+
+	conflicted bool
+}
+
+func (b *conflictingBackend) PutIndex(ctx context.Context, uri string, etag string, r io.Reader) error {
+	if !b.conflicted {
+		b.conflicted = true
+
+		idx := helmutil.NewIndex()
+		data, _, err := b.FetchRaw(ctx, helmutil.IndexFileURL(uri))
+		if err != nil {
+			return err
+		}
+		if err := idx.UnmarshalBinary(data); err != nil {
+			return err
+		}
+		meta := helmutil.NewChartMetadata()
+		if err := meta.UnmarshalJSON([]byte(`{"name":"bar","version":"0.1.0"}`)); err != nil {
+			return err
+		}
+		if err := idx.AddOrReplace(meta.Value(), "bar-0.1.0.tgz", "", "sha256:bar"); err != nil {
+			return err
+		}
+		concurrent, err := idx.Reader()
+		if err != nil {
+			return err
+		}
+		if err := b.MemoryBackend.PutIndex(ctx, uri, etag, concurrent); err != nil {
+			return err
+		}
+	}
+
+	return b.MemoryBackend.PutIndex(ctx, uri, etag, r)
+}
+
+func TestPushAction_ConcurrentIndexUpdate(t *testing.T) {
+	b := &conflictingBackend{MemoryBackend: setupRepo(t)}
+	mockBackend(t, b)
+
+	act := &pushAction{
+		printer:   &testPrinter{},
+		chartPath: testChartPath,
+		repoOrURI: testRepoURI,
+	}
+	require.NoError(t, act.run(context.Background()))
+
+	idx := loadRepoIndex(t, b)
+	assert.True(t, idx.Has("foo", "1.2.3"), "pushed chart must be in the index")
+	assert.True(t, idx.Has("bar", "0.1.0"), "concurrently added chart must not be lost")
+}
+
+func TestPushAction_Locked(t *testing.T) {
+	b := setupRepo(t)
+	require.NoError(t, b.AcquireLock(context.Background(), testRepoURI, oss.NewLock("test", repoLockTTL)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	p := &testPrinter{}
+	act := &pushAction{
+		printer:   p,
+		chartPath: testChartPath,
+		repoOrURI: testRepoURI,
+	}
+	err := act.run(ctx)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "helm oss lock break"))
+	assert.False(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+}
+
+func TestPushAction_OSS(t *testing.T) {
+	srv := osstest.NewTestServer(t, "test-bucket")
+
+	initAct := &initAction{printer: &testPrinter{}, uri: testRepoURI}
+	require.NoError(t, initAct.run(context.Background()))
+
+	act := &pushAction{
+		printer:   &testPrinter{},
+		chartPath: testChartPath,
+		repoOrURI: testRepoURI,
+	}
+	require.NoError(t, act.run(context.Background()))
+
+	assert.Equal(t, []string{"charts/foo-1.2.3.tgz", "charts/index.yaml"}, srv.Keys("test-bucket"))
+
+	obj, ok := srv.GetObject("test-bucket", "charts/index.yaml")
+	require.True(t, ok)
+	idx := helmutil.NewIndex()
+	require.NoError(t, idx.UnmarshalBinary(obj.Data))
+	assert.True(t, idx.Has("foo", "1.2.3"))
+}
+
+// failingIndexBackend fails every index update.
+type failingIndexBackend struct {
+	*oss.MemoryBackend
+}
+
+func (b *failingIndexBackend) PutIndex(context.Context, string, string, io.Reader) error {
+	return errors.New("index update failed")
+}
+
+func TestPushAction_Rollback(t *testing.T) {
+	old := indexUpdateBaseBackoff
+	indexUpdateBaseBackoff = time.Millisecond
+	t.Cleanup(func() { indexUpdateBaseBackoff = old })
+
+	ctx := context.Background()
+	chartURI := testRepoURI + "/foo-1.2.3.tgz"
+
+	t.Run("should delete new chart", func(t *testing.T) {
+		mem := setupRepo(t)
+		mockBackend(t, &stalePutBackend{MemoryBackend: mem, conflicts: indexUpdateMaxAttempts})
+
+		p := &testPrinter{}
+		act := &pushAction{printer: p, chartPath: testChartPath, repoOrURI: testRepoURI}
+		assert.ErrorIs(t, act.run(ctx), oss.ErrIndexConflict)
+
+		exists, err := mem.Exists(ctx, chartURI)
+		require.NoError(t, err)
+		assert.False(t, exists)
+		assert.Contains(t, p.err.String(), "Rolled back: deleted uploaded foo-1.2.3.tgz\n")
+		assert.NotContains(t, p.err.String(), "provenance file", "no provenance file was uploaded")
+	})
+
+	t.Run("should restore overwritten chart", func(t *testing.T) {
+		mem := setupRepo(t)
+		_, err := mem.PutChart(
+			ctx, chartURI, strings.NewReader("previous"), `{"name":"foo","version":"1.2.3"}`, "previous-digest",
+			"application/gzip", true, strings.NewReader("previous prov"),
+		)
+		require.NoError(t, err)
+		mockBackend(t, &stalePutBackend{MemoryBackend: mem, conflicts: indexUpdateMaxAttempts})
+
+		p := &testPrinter{}
+		act := &pushAction{printer: p, chartPath: testChartPath, repoOrURI: testRepoURI, force: true}
+		assert.ErrorIs(t, act.run(ctx), oss.ErrIndexConflict)
+
+		data, _, err := mem.FetchRaw(ctx, chartURI)
+		require.NoError(t, err)
+		assert.Equal(t, "previous", string(data))
+		data, _, err = mem.FetchRaw(ctx, chartURI+".prov")
+		require.NoError(t, err)
+		assert.Equal(t, "previous prov", string(data))
+		assert.Contains(t, p.err.String(), "Rolled back: restored previous foo-1.2.3.tgz")
+
+		info, err := mem.StatObject(ctx, chartURI)
+		require.NoError(t, err)
+		assert.Equal(t, "previous-digest", info.ChartDigest(), "object metadata must be restored as is")
+		assert.JSONEq(t, `{"name":"foo","version":"1.2.3"}`, info.ChartMetadata())
+	})
+
+	t.Run("should keep chart if index may have been updated", func(t *testing.T) {
+		mem := setupRepo(t)
+		mockBackend(t, &failingIndexBackend{MemoryBackend: mem})
+
+		p := &testPrinter{}
+		act := &pushAction{printer: p, chartPath: testChartPath, repoOrURI: testRepoURI}
+		err := act.run(ctx)
+		assert.ErrorContains(t, err, "index update failed")
+		assert.ErrorContains(t, err, "helm oss recover")
+
+		exists, err := mem.Exists(ctx, chartURI)
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.NotContains(t, p.err.String(), "Rolled back")
+
+		journals, err := mem.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		assert.Len(t, journals, 1, "the journal must be kept for recover")
+	})
+
+	t.Run("should not download chart which is not replaced", func(t *testing.T) {
+		mem := setupRepo(t)
+		b := &downloadCountingBackend{MemoryBackend: mem, pushedWhileWaiting: chartURI}
+		mockBackend(t, b)
+
+		act := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+		assert.True(t, errorTypeSilent.Is(act.run(ctx)))
+		assert.Zero(t, b.downloads[chartURI])
+	})
+}
+
+// downloadCountingBackend counts the downloads of every object. The chart
+// pushedWhileWaiting is pushed by someone else before the lock is acquired.
+type downloadCountingBackend struct {
+	*oss.MemoryBackend
+
+	pushedWhileWaiting string
+	downloads          map[string]int
+}
+
+func (b *downloadCountingBackend) AcquireLock(ctx context.Context, repoURI string, lock oss.Lock) error {
+	if b.pushedWhileWaiting != "" {
+		_, err := b.PutChart(ctx, b.pushedWhileWaiting, strings.NewReader("concurrent"), "", "", "application/gzip", false, nil)
+		if err != nil {
+			return err
+		}
+		b.pushedWhileWaiting = ""
+	}
+	return b.MemoryBackend.AcquireLock(ctx, repoURI, lock)
+}
+
+func (b *downloadCountingBackend) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
+	if b.downloads == nil {
+		b.downloads = make(map[string]int)
+	}
+	b.downloads[uri]++
+	return b.MemoryBackend.FetchRaw(ctx, uri)
+}
+
+// cancelingBackend requests the cancellation once the chart is uploaded.
+type cancelingBackend struct {
+	*oss.MemoryBackend
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelingBackend) PutChart(
+	ctx context.Context, uri string, r io.Reader, chartMeta, chartDigest, contentType string, prov bool, provReader io.Reader,
+) (string, error) {
+	defer b.cancel(signalError{sig: syscall.SIGINT})
+	return b.MemoryBackend.PutChart(ctx, uri, r, chartMeta, chartDigest, contentType, prov, provReader)
+}
+
+func TestPushAction_Canceled(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	mem := setupRepo(t)
+	mockBackend(t, &cancelingBackend{MemoryBackend: mem, cancel: cancel})
+
+	p := &testPrinter{}
+	act := &pushAction{printer: p, chartPath: testChartPath, repoOrURI: testRepoURI}
+	err := act.run(ctx)
+	assert.ErrorContains(t, err, "canceled by signal: interrupt")
+
+	// The index is not written, and the upload is rolled back.
+	assert.False(t, loadRepoIndex(t, mem).Has("foo", "1.2.3"))
+	exists, err := mem.Exists(context.Background(), testRepoURI+"/foo-1.2.3.tgz")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	assert.Contains(t, p.err.String(), "Rolled back: deleted uploaded foo-1.2.3.tgz")
+
+	// The lock is released and the journal is completed.
+	_, locked, err := mem.ReadLock(context.Background(), testRepoURI)
+	require.NoError(t, err)
+	assert.False(t, locked)
+	journals, err := mem.ListJournals(context.Background(), testRepoURI)
+	require.NoError(t, err)
+	assert.Empty(t, journals)
+}
+
+func TestPushAction_Sign(t *testing.T) {
+	ctx := context.Background()
+	b := setupRepo(t)
+
+	newAct := func() *pushAction {
+		return &pushAction{
+			printer:   &testPrinter{},
+			chartPath: "../../testdata/foo",
+			repoOrURI: testRepoURI,
+			sign:      true,
+			key:       "Test Key",
+			keyring:   "../../testdata/pgp/test-private.gpg",
+		}
+	}
+
+	t.Run("should package and sign chart directory", func(t *testing.T) {
+		act := newAct()
+		require.NoError(t, act.run(ctx))
+
+		exists, err := b.Exists(ctx, testRepoURI+"/foo-0.1.0.tgz.prov")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "0.1.0"))
+
+		verify := &verifyAction{printer: &testPrinter{}, repoOrURI: testRepoURI, keyring: testKeyring, concurrency: 1, output: "text"}
+		assert.NoError(t, verify.run(ctx))
+	})
+
+	t.Run("should require key", func(t *testing.T) {
+		act := newAct()
+		act.key = ""
+		assert.True(t, errorTypeBadUsage.Is(act.run(ctx)))
+	})
+
+	t.Run("should fail on unknown key", func(t *testing.T) {
+		act := newAct()
+		act.key = "Unknown Key"
+		act.force = true
+		err := act.run(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `key "Unknown Key" not found`)
+	})
+
+	t.Run("should fail on missing chart dependencies", func(t *testing.T) {
+		act := newAct()
+		act.chartPath = "../../testdata/bar"
+		err := act.run(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing dependencies foo")
+	})
+}
+
+func TestPushAction_Passphrase(t *testing.T) {
+	act := &pushAction{}
+	_, err := act.passphrase("Test Key")
+	assert.ErrorContains(t, err, "--passphrase-file")
+
+	act.passphraseFile = filepath.Join(t.TempDir(), "passphrase")
+	require.NoError(t, os.WriteFile(act.passphraseFile, []byte("secret\nignored\n"), 0o600))
+	passphrase, err := act.passphrase("Test Key")
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(passphrase))
+}
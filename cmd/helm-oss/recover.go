@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+const recoverDesc = `This command recovers the repository after interrupted operations.
+
+'helm oss recover' takes one argument:
+- REPO_OR_URI - target repository name or OSS URI.
+
+Push, delete and reindex record themselves in the repository journal before
+they modify the repository, and remove the record once the index is updated.
+The journal is stored under '.helm-oss.journal/' next to index.yaml.
+
+This command finds the operations that did not finish, for example because the
+process was killed, and brings the repository to a consistent state:
+- push is completed if the chart has been fully uploaded, otherwise it is
+  reverted, as the chart objects have not been changed; a signed chart
+  uploaded without its provenance file is reported and kept in the journal
+  until it is pushed again,
+- delete is completed: the chart is removed from the index and deleted,
+- reindex is run again.
+
+Operations in progress hold the repository lock, so they are not recovered.
+With --dry-run they are listed as in progress.
+`
+
+const recoverExample = `  helm oss recover my-repo                        - recovers repository 'my-repo'
+  helm oss recover --dry-run oss://bucket/charts - lists interrupted operations of OSS URI`
+
+func newRecoverCommand() *cobra.Command {
+	act := &recoverAction{
+		printer:   nil,
+		repoOrURI: "",
+		dryRun:    false,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "recover REPO_OR_URI",
+		Short:   "Recover the repository after interrupted operations.",
+		Long:    recoverDesc,
+		Example: recoverExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO_OR_URI argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&act.dryRun, "dry-run", act.dryRun, "List interrupted operations, but don't recover them.")
+
+	return cmd
+}
+
+type recoverAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+
+	// flags
+
+	dryRun bool
+}
+
+func (act *recoverAction) run(ctx context.Context) error {
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	if act.dryRun {
+		journals, err := storage.ListJournals(ctx, repo.URL())
+		if err != nil {
+			return errors.WithMessage(err, "list operation journal")
+		}
+		if len(journals) == 0 {
+			act.printer.Printf("No interrupted operations found in %s.\n", act.repoOrURI)
+			return nil
+		}
+
+		// Operations in progress hold the repository lock.
+		holder, locked, err := storage.ReadLock(ctx, repo.URL())
+		if err != nil {
+			return errors.WithMessage(err, "read repository lock")
+		}
+		for _, journal := range journals {
+			if locked && journal.LockID == holder.ID && !holder.Expired(time.Now()) {
+				act.printer.Printf("Operation in progress: %s\n", journal)
+				continue
+			}
+			act.printer.Printf("Interrupted operation: %s\n", journal)
+		}
+		return nil
+	}
+
+	// Operations in progress hold the lock, and they stop before their lock
+	// expires, see withRepoLock. So all journals found under the lock belong
+	// to interrupted operations, even if the lock has been taken over.
+	var recovered, unrecoverable int
+	err = withRepoLock(ctx, act.printer, storage, repo, "recover", func(ctx context.Context) error {
+		journals, err := storage.ListJournals(ctx, repo.URL())
+		if err != nil {
+			return errors.WithMessage(err, "list operation journal")
+		}
+
+		for _, journal := range journals {
+			result, err := act.recoverOperation(ctx, storage, repo, journal)
+			if errors.Is(err, errUnrecoverable) {
+				act.printer.PrintErrf("Cannot recover %s: %s.\n", journal, err)
+				unrecoverable++
+				continue
+			}
+			if err != nil {
+				return errors.WithMessagef(err, "recover %s", journal)
+			}
+			if err := storage.CompleteJournal(ctx, repo.URL(), journal.ID); err != nil {
+				return errors.WithMessagef(err, "complete %s", journal)
+			}
+			act.printer.Printf("Recovered %s: %s.\n", journal, result)
+			recovered++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if recovered == 0 && unrecoverable == 0 {
+		act.printer.Printf("No interrupted operations found in %s.\n", act.repoOrURI)
+		return nil
+	}
+
+	// The index has been changed, so the cache is outdated.
+	if recovered > 0 && repo.ShouldUpdateCache() {
+		idx, _, err := fetchIndex(ctx, storage, repo)
+		if err != nil {
+			return err
+		}
+		if err := idx.WriteFile(repo.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+			return errors.WithMessage(err, "update local index")
+		}
+	}
+
+	if unrecoverable > 0 {
+		return errors.Errorf("%d interrupted operation(s) in %s cannot be recovered automatically", unrecoverable, act.repoOrURI)
+	}
+
+	act.printer.Printf("Repository %s was successfully recovered.\n", act.repoOrURI)
+	return nil
+}
+
+// errUnrecoverable marks the operations which cannot be recovered
+// automatically. Their journals are kept, so that they are reported again
+// until they are fixed, e.g. by pushing the chart again.
+var errUnrecoverable = errors.New("the operation cannot be recovered automatically")
+
+// recoverOperation completes or reverts the interrupted operation and returns
+// the description of what has been done.
+func (act *recoverAction) recoverOperation(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	journal oss.Journal,
+) (string, error) {
+	switch journal.Operation {
+	case "push":
+		return act.recoverPush(ctx, storage, repo, journal.Chart)
+	case "delete":
+		return act.recoverDelete(ctx, storage, repo, journal.Chart)
+	case "reindex":
+		reindex := &reindexAction{printer: act.printer, full: true, concurrency: oss.DefaultConcurrency}
+		if _, err := reindex.reindex(ctx, storage, repo); err != nil {
+			return "", err
+		}
+		return "completed, the index was rebuilt", nil
+	default:
+		return "", fmt.Errorf("unknown operation %q", journal.Operation)
+	}
+}
+
+// recoverPush completes the push if the chart has been uploaded. Otherwise
+// the chart objects are unchanged, as the chart is uploaded in one request,
+// and the push is reverted by just dropping it.
+func (act *recoverAction) recoverPush(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	chart *oss.JournalChart,
+) (string, error) {
+	if chart == nil {
+		return "", errors.New("journal has no chart")
+	}
+
+	data, err := fetchOptional(ctx, storage, resolveChartURL(repo, chart.URL))
+	if err != nil {
+		return "", errors.WithMessage(err, "fetch chart")
+	}
+
+	if data != nil {
+		digest, err := helmutil.Digest(bytes.NewReader(data))
+		if err != nil {
+			return "", errors.WithMessage(err, "get chart digest")
+		}
+		if digest != chart.Digest {
+			data = nil
+		}
+	}
+
+	if data == nil {
+		return "reverted, the chart had not been uploaded", nil
+	}
+
+	// The provenance file is uploaded after the chart, so it may be missing
+	// or left from the previous push of the chart. It cannot be restored, as
+	// only the pushing process had it.
+	if chart.Prov {
+		prov, err := fetchOptional(ctx, storage, resolveChartURL(repo, chart.URL)+".prov")
+		if err != nil {
+			return "", errors.WithMessage(err, "fetch provenance file")
+		}
+		hash := ""
+		if prov != nil {
+			hash, _ = helmutil.ProvenanceFileHash(prov, path.Base(chart.URL))
+		}
+		if hash != "sha256:"+chart.Digest {
+			return "", fmt.Errorf(
+				"%w: the chart was uploaded without its provenance file, push the signed chart again with --force",
+				errUnrecoverable,
+			)
+		}
+	}
+
+	ch, err := helmutil.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return "", errors.WithMessage(err, "load chart")
+	}
+
+	_, err = updateIndex(ctx, storage, repo, false, func(idx *helmutil.Index) (*helmutil.Index, error) {
+		if err := idx.AddOrReplace(ch.Metadata().Value(), chart.URL, "", chart.Digest); err != nil {
+			return nil, errors.WithMessage(err, "add/replace chart in the index")
+		}
+		idx.SortEntries()
+		idx.UpdateGeneratedTime()
+		return idx, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "completed, the chart was added to the index", nil
+}
+
+// recoverDelete removes the chart from the index, if it is still there, and
+// deletes the chart objects.
+func (act *recoverAction) recoverDelete(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	chart *oss.JournalChart,
+) (string, error) {
+	if chart == nil {
+		return "", errors.New("journal has no chart")
+	}
+
+	current, _, err := fetchIndex(ctx, storage, repo)
+	if err != nil {
+		return "", err
+	}
+
+	if current.Has(chart.Name, chart.Version) {
+		_, err := updateIndex(ctx, storage, repo, false, func(idx *helmutil.Index) (*helmutil.Index, error) {
+			if idx.Has(chart.Name, chart.Version) {
+				if _, err := idx.Delete(chart.Name, chart.Version); err != nil {
+					return nil, err
+				}
+				idx.UpdateGeneratedTime()
+			}
+			return idx, nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if chart.URL != "" {
+		if err := storage.DeleteChart(ctx, resolveChartURL(repo, chart.URL)); err != nil {
+			return "", errors.WithMessage(err, "delete chart file from oss")
+		}
+	}
+
+	return "completed, the chart was removed from the index and deleted", nil
+}
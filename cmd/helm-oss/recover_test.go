@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+func TestRecoverAction(t *testing.T) {
+	ctx := context.Background()
+	chartURI := testRepoURI + "/foo-1.2.3.tgz"
+
+	digest, err := helmutil.DigestFile(testChartPath)
+	require.NoError(t, err)
+	chart := &oss.JournalChart{Name: "foo", Version: "1.2.3", URL: "foo-1.2.3.tgz", Digest: digest}
+
+	t.Run("should complete uploaded push", func(t *testing.T) {
+		b := setupRepo(t)
+		require.NoError(t, b.WriteJournal(ctx, testRepoURI, oss.NewJournal("push", chart)))
+
+		f, err := os.Open(testChartPath)
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = b.PutChart(ctx, chartURI, f, "", "", "application/gzip", false, nil)
+		require.NoError(t, err)
+
+		p := &testPrinter{}
+		act := &recoverAction{printer: p, repoOrURI: testRepoURI}
+		require.NoError(t, act.run(ctx))
+
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+		assert.Contains(t, p.out.String(), "the chart was added to the index")
+
+		journals, err := b.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		assert.Empty(t, journals)
+	})
+
+	t.Run("should revert push without upload", func(t *testing.T) {
+		b := setupRepo(t)
+		require.NoError(t, b.WriteJournal(ctx, testRepoURI, oss.NewJournal("push", chart)))
+
+		p := &testPrinter{}
+		act := &recoverAction{printer: p, repoOrURI: testRepoURI}
+		require.NoError(t, act.run(ctx))
+
+		assert.False(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+		assert.Contains(t, p.out.String(), "reverted")
+	})
+
+	t.Run("should keep signed push without provenance file", func(t *testing.T) {
+		b := setupRepo(t)
+		digest, err := helmutil.DigestFile(testSignedChartPath)
+		require.NoError(t, err)
+		signed := &oss.JournalChart{Name: "foo", Version: "1.3.1", URL: "foo-1.3.1.tgz", Digest: digest, Prov: true}
+		require.NoError(t, b.WriteJournal(ctx, testRepoURI, oss.NewJournal("push", signed)))
+
+		f, err := os.Open(testSignedChartPath)
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = b.PutChart(ctx, testRepoURI+"/foo-1.3.1.tgz", f, "", "", "application/gzip", false, nil)
+		require.NoError(t, err)
+
+		p := &testPrinter{}
+		act := &recoverAction{printer: p, repoOrURI: testRepoURI}
+		err = act.run(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot be recovered automatically")
+		assert.Contains(t, p.err.String(), "without its provenance file")
+		assert.False(t, loadRepoIndex(t, b).Has("foo", "1.3.1"))
+
+		journals, err := b.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		assert.Len(t, journals, 1)
+
+		// The signed chart is uploaded with its provenance file by the next push.
+		_, err = f.Seek(0, 0)
+		require.NoError(t, err)
+		prov, err := os.Open(testSignedChartPath + ".prov")
+		require.NoError(t, err)
+		defer prov.Close()
+		_, err = b.PutChart(ctx, testRepoURI+"/foo-1.3.1.tgz", f, "", "", "application/gzip", true, prov)
+		require.NoError(t, err)
+
+		act = &recoverAction{printer: &testPrinter{}, repoOrURI: testRepoURI}
+		require.NoError(t, act.run(ctx))
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "1.3.1"))
+
+		journals, err = b.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		assert.Empty(t, journals)
+	})
+
+	t.Run("should complete delete", func(t *testing.T) {
+		b := setupRepo(t)
+		push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+		require.NoError(t, push.run(ctx))
+
+		require.NoError(t, b.WriteJournal(ctx, testRepoURI, oss.NewJournal("delete", &oss.JournalChart{
+			Name: "foo", Version: "1.2.3", URL: "foo-1.2.3.tgz",
+		})))
+
+		act := &recoverAction{printer: &testPrinter{}, repoOrURI: testRepoURI}
+		require.NoError(t, act.run(ctx))
+
+		assert.False(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+		exists, err := b.Exists(ctx, chartURI)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("dry run should not change anything", func(t *testing.T) {
+		b := setupRepo(t)
+		require.NoError(t, b.WriteJournal(ctx, testRepoURI, oss.NewJournal("reindex", nil)))
+
+		p := &testPrinter{}
+		act := &recoverAction{printer: p, repoOrURI: testRepoURI, dryRun: true}
+		require.NoError(t, act.run(ctx))
+		assert.Contains(t, p.out.String(), "Interrupted operation: reindex")
+
+		journals, err := b.ListJournals(ctx, testRepoURI)
+		require.NoError(t, err)
+		assert.Len(t, journals, 1)
+	})
+
+	t.Run("dry run should tell operations in progress", func(t *testing.T) {
+		b := setupRepo(t)
+		lock := oss.NewLock("push", time.Minute)
+		require.NoError(t, b.AcquireLock(ctx, testRepoURI, lock))
+
+		journal := oss.NewJournal("push", chart)
+		journal.LockID = lock.ID
+		require.NoError(t, b.WriteJournal(ctx, testRepoURI, journal))
+
+		p := &testPrinter{}
+		act := &recoverAction{printer: p, repoOrURI: testRepoURI, dryRun: true}
+		require.NoError(t, act.run(ctx))
+		assert.Contains(t, p.out.String(), "Operation in progress: push foo 1.2.3")
+		assert.NotContains(t, p.out.String(), "Interrupted operation")
+	})
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -14,16 +20,30 @@ const reindexDesc = `This command performs a reindex of the repository.
 
 'helm oss reindex' takes one argument:
 - REPO_OR_URI - target repository name or OSS URI.
+
+[Incremental reindex]
+
+The ETag, size and modification time of every chart object are recorded in
+'.helm-oss.reindex.json' next to index.yaml. On the next reindex, the index
+entries of unchanged objects are reused, and only new or changed objects are
+inspected. Push and delete update the records of the charts they change.
+Use --full flag to inspect all objects.
 `
 
-const reindexExample = `  helm oss reindex my-repo              - reindexes repository 'my-repo'
-  helm oss reindex oss://bucket/charts - reindexes OSS URI directly`
+const reindexExample = `  helm oss reindex my-repo                     - reindexes repository 'my-repo'
+  helm oss reindex oss://bucket/charts        - reindexes OSS URI directly
+  helm oss reindex --full oss://bucket/charts - reindexes OSS URI inspecting all charts`
+
+// reindexStateFileName is the name of the object with the state of the
+// chart objects recorded by the last reindex. It is stored next to index.yaml.
+const reindexStateFileName = ".helm-oss.reindex.json"
 
-func newReindexCommand(opts *options) *cobra.Command {
+func newReindexCommand() *cobra.Command {
 	act := &reindexAction{
-		printer:   nil,
-		verbose:   false,
-		repoOrURI: "",
+		printer:     nil,
+		repoOrURI:   "",
+		full:        false,
+		concurrency: oss.DefaultConcurrency,
 	}
 
 	cmd := &cobra.Command{
@@ -37,20 +57,58 @@ func newReindexCommand(opts *options) *cobra.Command {
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if act.concurrency < 1 {
+				return newBadUsageError(errors.New("--concurrency must be at least 1"))
+			}
 			act.printer = cmd
-			act.verbose = opts.verbose
 			act.repoOrURI = args[0]
 			return act.run(cmd.Context())
 		},
 	}
 
+	flags := cmd.Flags()
+	flags.BoolVar(&act.full, "full", act.full, "Inspect all chart objects instead of only new and changed ones.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Number of chart objects inspected in parallel.")
+
 	return cmd
 }
 
 type reindexAction struct {
-	printer   printer
-	verbose   bool
-	repoOrURI string
+	printer     printer
+	repoOrURI   string
+	full        bool
+	concurrency int
+}
+
+// reindexState is the state of the chart objects recorded by reindex.
+type reindexState struct {
+	// Charts are the chart object records by file name.
+	Charts map[string]reindexRecord `json:"charts"`
+}
+
+// reindexRecord is the state of the chart object.
+type reindexRecord struct {
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+
+	// Digest is the chart digest, as written to the index.
+	Digest string `json:"digest"`
+}
+
+// newReindexRecord returns the record of the chart object with the digest.
+func newReindexRecord(obj oss.ObjectInfo, digest string) reindexRecord {
+	return reindexRecord{
+		ETag:         obj.ETag,
+		Size:         obj.Size,
+		LastModified: obj.LastModified,
+		Digest:       digest,
+	}
+}
+
+// matches returns true if the record describes the object as it is now.
+func (r reindexRecord) matches(obj oss.ObjectInfo) bool {
+	return r.ETag == obj.ETag && r.Size == obj.Size && r.LastModified.Equal(obj.LastModified)
 }
 
 func (act *reindexAction) run(ctx context.Context) error {
@@ -59,53 +117,233 @@ func (act *reindexAction) run(ctx context.Context) error {
 		return err
 	}
 
-	storage := oss.New()
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
 
-	items, errs := storage.Traverse(ctx, repo.URL())
+	// The index is rebuilt from the repository contents, reusing the entries
+	// of the current one for unchanged charts. If it is modified
+	// concurrently, the repository is listed again to pick up the changes.
+	var idx *helmutil.Index
+	err = withRepoLock(ctx, act.printer, storage, repo, "reindex", func(ctx context.Context) error {
+		journal, err := beginJournal(ctx, storage, repo, "reindex", nil)
+		if err != nil {
+			return err
+		}
 
-	builtIndex := make(chan *helmutil.Index, 1)
-	go func() {
-		idx := helmutil.NewIndex()
-		for item := range items {
-			baseURL := ""
+		idx, err = act.reindex(ctx, storage, repo)
+		// Reindex does not touch charts, so a failed reindex leaves the
+		// repository intact.
+		completeJournal(ctx, act.printer, storage, repo, journal)
+		return err
+	})
+	if err != nil {
+		return err
+	}
 
-			if act.verbose {
-				act.printer.Printf("[DEBUG] Adding %s to index.\n", item.Filename)
-			}
+	if repo.ShouldUpdateCache() {
+		if err := idx.WriteFile(repo.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+			return errors.WithMessage(err, "update local index")
+		}
+	}
+
+	act.printer.Printf("Repository %s was successfully reindexed.\n", act.repoOrURI)
+	return nil
+}
+
+// reindex rebuilds and uploads the index, and records the state of the chart
+// objects for the next reindex.
+func (act *reindexAction) reindex(ctx context.Context, storage oss.Backend, repo helmutil.Repository) (*helmutil.Index, error) {
+	var state *reindexState
+	idx, err := updateIndex(ctx, storage, repo, true, func(current *helmutil.Index) (*helmutil.Index, error) {
+		var idx *helmutil.Index
+		var err error
+		idx, state, err = act.buildIndex(ctx, storage, repo, current)
+		return idx, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The index has been updated already, and a stale state only makes the
+	// next reindex inspect more charts, so the failure is only reported.
+	if err := saveReindexState(ctx, storage, repo, state); err != nil {
+		act.printer.PrintErrf("[WARN] failed to save the reindex state: %s\n", err)
+	}
+
+	return idx, nil
+}
+
+// buildIndex lists the repository and builds a new index from the found
+// charts. Entries of the charts that have not changed since the last reindex
+// are copied from the current index, which may be nil.
+// It returns the index along with the new state of the chart objects.
+func (act *reindexAction) buildIndex(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	current *helmutil.Index,
+) (*helmutil.Index, *reindexState, error) {
+	objects, err := storage.ListObjects(ctx, repo.URL())
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "list the chart repository")
+	}
+
+	prev := &reindexState{}
+	if !act.full && current != nil {
+		prev = loadReindexState(ctx, act.printer, storage, repo)
+	}
 
-			filename := item.Filename
+	// Current index entries by chart file name.
+	entries := make(map[string]helmutil.IndexEntry)
+	if current != nil {
+		for _, entry := range current.Entries() {
+			entries[chartFileName(repo, entry.URL)] = entry
+		}
+	}
+
+	idx := helmutil.NewIndex()
+	state := &reindexState{Charts: make(map[string]reindexRecord)}
 
-			if err := idx.Add(item.Meta.Value(), filename, baseURL, item.Hash); err != nil {
-				act.printer.PrintErrf("[ERROR] failed to add chart to the index: %s", err)
+	// Unchanged charts are copied from the current index, the rest is loaded
+	// concurrently.
+	var changed []oss.ObjectInfo
+	for _, obj := range objects {
+		filename := path.Base(obj.URI)
+		if !strings.HasSuffix(filename, ".tgz") {
+			continue
+		}
+
+		if rec, ok := prev.Charts[filename]; ok && rec.matches(obj) {
+			entry, ok := entries[filename]
+			if ok && entry.Digest == rec.Digest && idx.CopyFrom(current, entry.Name, entry.Version) {
+				slog.DebugContext(ctx, "keeping chart in index, unchanged", "file", filename)
+				state.Charts[filename] = rec
+				continue
 			}
 		}
-		idx.SortEntries()
-		idx.UpdateGeneratedTime()
 
-		builtIndex <- idx
-	}()
+		changed = append(changed, obj)
+	}
 
-	for err = range errs {
-		return fmt.Errorf("traverse the chart repository: %v", err)
+	uris := make([]string, len(changed))
+	for i, obj := range changed {
+		uris[i] = obj.URI
+	}
+	items, err := oss.LoadCharts(ctx, storage, uris, act.concurrency)
+	if err != nil {
+		return nil, nil, fmt.Errorf("traverse the chart repository: %v", err)
 	}
 
-	idx := <-builtIndex
+	for i, item := range items {
+		obj := changed[i]
+		filename := path.Base(obj.URI)
+
+		slog.DebugContext(ctx, "adding chart to index", "file", filename)
+
+		// The chart is as old as its object, unless it has already been in
+		// the index. Fields edited by hand in the index are kept as well.
+		created := obj.LastModified
+		if created.IsZero() {
+			created = time.Now()
+		}
 
-	r, err := idx.Reader()
+		baseURL := ""
+		if err := idx.AddWithCreated(item.Meta.Value(), filename, baseURL, item.Hash, created); err != nil {
+			act.printer.PrintErrf("[ERROR] failed to add chart to the index: %s", err)
+			continue
+		}
+		if current != nil {
+			idx.MergeFrom(current, item.Meta.Name(), item.Meta.Version())
+		}
+
+		state.Charts[filename] = newReindexRecord(obj, item.Hash)
+	}
+	idx.SortEntries()
+	idx.UpdateGeneratedTime()
+
+	return idx, state, nil
+}
+
+// loadReindexState loads the state of the chart objects recorded by the last
+// reindex. A missing or unreadable state results in the full reindex.
+func loadReindexState(ctx context.Context, p printer, storage oss.Backend, repo helmutil.Repository) *reindexState {
+	state := &reindexState{}
+
+	b, _, err := storage.FetchRaw(ctx, reindexStateURL(repo))
 	if err != nil {
-		return errors.Wrap(err, "get index reader")
+		if !errors.Is(err, oss.ErrObjectNotFound) {
+			p.PrintErrf("[WARN] failed to fetch the reindex state, inspecting all charts: %s\n", err)
+		}
+		return state
 	}
 
-	if err := storage.PutIndex(ctx, repo.URL(), r); err != nil {
-		return errors.Wrap(err, "upload index to the repository")
+	if err := json.Unmarshal(b, state); err != nil {
+		p.PrintErrf("[WARN] failed to load the reindex state, inspecting all charts: %s\n", err)
+		return &reindexState{}
 	}
 
-	if repo.ShouldUpdateCache() {
-		if err := idx.WriteFile(repo.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
-			return errors.WithMessage(err, "update local index")
-		}
+	return state
+}
+
+// saveReindexState records the state of the chart objects for the next
+// reindex.
+func saveReindexState(ctx context.Context, storage oss.Backend, repo helmutil.Repository, state *reindexState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "marshal reindex state")
+	}
+
+	if err := storage.PutObject(ctx, reindexStateURL(repo), bytes.NewReader(b)); err != nil {
+		return errors.WithMessage(err, "upload reindex state")
 	}
 
-	act.printer.Printf("Repository %s was successfully reindexed.\n", act.repoOrURI)
 	return nil
 }
+
+// updateReindexState applies update to the state recorded by the last
+// reindex, if any, so that the next reindex does not inspect the charts
+// changed by push or delete again. It must be called under the repository
+// lock after the index has been updated.
+//
+// The state is only an optimization, as reindex inspects every object which
+// does not match its record anyway, so a failure is only reported.
+func updateReindexState(
+	ctx context.Context,
+	p printer,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	update func(state *reindexState) error,
+) {
+	err := func() error {
+		b, _, err := storage.FetchRaw(ctx, reindexStateURL(repo))
+		if err != nil {
+			if errors.Is(err, oss.ErrObjectNotFound) {
+				// The repository has never been reindexed.
+				return nil
+			}
+			return errors.WithMessage(err, "fetch reindex state")
+		}
+
+		state := &reindexState{}
+		if err := json.Unmarshal(b, state); err != nil {
+			return errors.Wrap(err, "unmarshal reindex state")
+		}
+		if state.Charts == nil {
+			state.Charts = make(map[string]reindexRecord)
+		}
+
+		if err := update(state); err != nil {
+			return err
+		}
+		return saveReindexState(ctx, storage, repo, state)
+	}()
+	if err != nil {
+		p.PrintErrf("[WARN] failed to update the reindex state: %s\n", err)
+	}
+}
+
+func reindexStateURL(repo helmutil.Repository) string {
+	return helmutil.JoinURL(repo.URL(), reindexStateFileName)
+}
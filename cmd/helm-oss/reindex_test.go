@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+func TestReindexAction(t *testing.T) {
+	b := oss.NewMemoryBackend()
+	mockBackend(t, b)
+
+	// Upload the chart without metadata, so that reindex has to load it.
+	f, err := os.Open(testChartPath)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = b.PutChart(context.Background(), testRepoURI+"/foo-1.2.3.tgz", f, "", "", "application/gzip", false, nil)
+	require.NoError(t, err)
+
+	act := &reindexAction{
+		printer:   &testPrinter{},
+		repoOrURI: testRepoURI,
+	}
+	require.NoError(t, act.run(context.Background()))
+	assert.True(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+
+	t.Run("should replace existing index", func(t *testing.T) {
+		require.NoError(t, b.DeleteChart(context.Background(), testRepoURI+"/foo-1.2.3.tgz"))
+		require.NoError(t, act.run(context.Background()))
+		assert.False(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+	})
+}
+
+// countingBackend counts the charts loaded by reindex.
+type countingBackend struct {
+	*oss.MemoryBackend
+
+	loaded atomic.Int32
+}
+
+func (b *countingBackend) LoadChart(ctx context.Context, uri string) (oss.ChartInfo, error) {
+	b.loaded.Add(1)
+	return b.MemoryBackend.LoadChart(ctx, uri)
+}
+
+func TestReindexAction_Incremental(t *testing.T) {
+	ctx := context.Background()
+	b := &countingBackend{MemoryBackend: setupRepo(t)}
+	mockBackend(t, b)
+
+	push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+	require.NoError(t, push.run(ctx))
+
+	act := &reindexAction{printer: &testPrinter{}, repoOrURI: testRepoURI}
+	require.NoError(t, act.run(ctx))
+	assert.EqualValues(t, 1, b.loaded.Load(), "chart must be inspected without recorded state")
+
+	b.loaded.Store(0)
+	require.NoError(t, act.run(ctx))
+	assert.EqualValues(t, 0, b.loaded.Load(), "unchanged chart must not be inspected")
+	assert.True(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+
+	t.Run("should inspect changed chart", func(t *testing.T) {
+		f, err := os.Open(testSignedChartPath)
+		require.NoError(t, err)
+		defer f.Close()
+		_, err = b.PutChart(ctx, testRepoURI+"/foo-1.2.3.tgz", f, "", "", "application/gzip", false, nil)
+		require.NoError(t, err)
+
+		b.loaded.Store(0)
+		require.NoError(t, act.run(ctx))
+		assert.EqualValues(t, 1, b.loaded.Load())
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "1.3.1"))
+	})
+
+	t.Run("should inspect all charts with full flag", func(t *testing.T) {
+		act := &reindexAction{printer: &testPrinter{}, repoOrURI: testRepoURI, full: true}
+
+		b.loaded.Store(0)
+		require.NoError(t, act.run(ctx))
+		assert.EqualValues(t, 1, b.loaded.Load())
+	})
+}
+
+func TestReindexAction_PreservesEntries(t *testing.T) {
+	ctx := context.Background()
+	b := setupRepo(t)
+
+	push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+	require.NoError(t, push.run(ctx))
+
+	// Edit the index by hand.
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	data, etag, err := b.FetchRaw(ctx, helmutil.IndexFileURL(testRepoURI))
+	require.NoError(t, err)
+	var index repo.IndexFile
+	require.NoError(t, yaml.Unmarshal(data, &index))
+	cv := index.Entries["foo"][0]
+	cv.Created = created
+	cv.Removed = true
+	cv.Annotations = map[string]string{"owner": "team-a"}
+	data, err = yaml.Marshal(&index)
+	require.NoError(t, err)
+	require.NoError(t, b.PutIndex(ctx, testRepoURI, etag, bytes.NewReader(data)))
+
+	act := &reindexAction{printer: &testPrinter{}, repoOrURI: testRepoURI, full: true}
+	require.NoError(t, act.run(ctx))
+
+	data, _, err = b.FetchRaw(ctx, helmutil.IndexFileURL(testRepoURI))
+	require.NoError(t, err)
+	index = repo.IndexFile{}
+	require.NoError(t, yaml.Unmarshal(data, &index))
+	cv = index.Entries["foo"][0]
+	assert.True(t, created.Equal(cv.Created))
+	assert.True(t, cv.Removed)
+	assert.Equal(t, "team-a", cv.Annotations["owner"])
+}
+
+func TestReindexAction_StateUpdates(t *testing.T) {
+	ctx := context.Background()
+	b := &countingBackend{MemoryBackend: setupRepo(t)}
+	mockBackend(t, b)
+
+	push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+	require.NoError(t, push.run(ctx))
+
+	act := &reindexAction{printer: &testPrinter{}, repoOrURI: testRepoURI}
+	require.NoError(t, act.run(ctx))
+
+	loadState := func(t *testing.T) *reindexState {
+		data, _, err := b.FetchRaw(ctx, testRepoURI+"/"+reindexStateFileName)
+		require.NoError(t, err)
+		state := &reindexState{}
+		require.NoError(t, json.Unmarshal(data, state))
+		return state
+	}
+
+	t.Run("push should record pushed chart", func(t *testing.T) {
+		push := &pushAction{printer: &testPrinter{}, chartPath: testSignedChartPath, repoOrURI: testRepoURI}
+		require.NoError(t, push.run(ctx))
+		assert.Contains(t, loadState(t).Charts, "foo-1.3.1.tgz")
+
+		b.loaded.Store(0)
+		require.NoError(t, act.run(ctx))
+		assert.EqualValues(t, 0, b.loaded.Load(), "pushed chart must not be inspected")
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "1.3.1"))
+	})
+
+	t.Run("delete should drop deleted chart", func(t *testing.T) {
+		del := &deleteAction{printer: &testPrinter{}, chartName: "foo", version: "1.3.1", repoOrURI: testRepoURI}
+		require.NoError(t, del.run(ctx))
+		assert.NotContains(t, loadState(t).Charts, "foo-1.3.1.tgz")
+		assert.Contains(t, loadState(t).Charts, "foo-1.2.3.tgz")
+	})
+}
+
+// stateFailingBackend fails to save the reindex state.
+type stateFailingBackend struct {
+	*oss.MemoryBackend
+}
+
+func (b *stateFailingBackend) PutObject(ctx context.Context, uri string, r io.Reader) error {
+	if strings.HasSuffix(uri, reindexStateFileName) {
+		return errors.New("state upload failed")
+	}
+	return b.MemoryBackend.PutObject(ctx, uri, r)
+}
+
+func TestReindexAction_SaveStateFailed(t *testing.T) {
+	ctx := context.Background()
+	mem := setupRepo(t)
+
+	push := &pushAction{printer: &testPrinter{}, chartPath: testChartPath, repoOrURI: testRepoURI}
+	require.NoError(t, push.run(ctx))
+	mockBackend(t, &stateFailingBackend{MemoryBackend: mem})
+
+	p := &testPrinter{}
+	act := &reindexAction{printer: p, repoOrURI: testRepoURI}
+	require.NoError(t, act.run(ctx), "index has been updated, so reindex must succeed")
+	assert.Contains(t, p.err.String(), "[WARN] failed to save the reindex state: upload reindex state: state upload failed")
+	assert.True(t, loadRepoIndex(t, mem).Has("foo", "1.2.3"))
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -27,11 +30,46 @@ Basic usage:
 
 For detailed documentation, see README at https://github.com/Timozer/helm-oss
 
-[Verbose output]
+[Logging]
 
-You can enable verbose output with '--verbose' flag.
+The logs are written to stderr. The level is set with '--log-level' flag or
+HELM_OSS_LOG_LEVEL environment variable, and the format with '--log-format'
+flag or HELM_OSS_LOG_FORMAT environment variable. At debug level, which is
+also enabled with '--verbose' flag, every OSS request is logged with its
+method, bucket, key, status, request ID and latency:
+
+  $ helm oss push --log-level debug --log-format json ./chart-0.1.0.tgz my-repo
+
+[Profiles]
+
+The configuration profile from ~/.config/helm_plugin_oss.yaml is selected with
+'--profile' flag or HELM_OSS_PROFILE environment variable. Otherwise, the
+profile bound to the repository or the default profile is used.
+
+[Timeouts, retries and proxy]
+
+The whole command is limited by '--timeout' flag or HELM_OSS_TIMEOUT
+environment variable. The connection settings are taken, in order of
+precedence, from the flags, from the environment variables and from the
+configuration file:
+
+  --connect-timeout     HELM_OSS_CONNECT_TIMEOUT     connectTimeout
+  --read-write-timeout  HELM_OSS_READ_WRITE_TIMEOUT  readWriteTimeout
+  --retry-max-attempts  HELM_OSS_RETRY_MAX_ATTEMPTS  retry.maxAttempts
+  --proxy               HELM_OSS_PROXY               proxy
+
+By default, HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are
+used to select the proxy.
 `
 
+// configFlags are the flags overriding the configuration keys.
+var configFlags = map[string]string{
+	"connect-timeout":    "connectTimeout",
+	"read-write-timeout": "readWriteTimeout",
+	"retry-max-attempts": "retry.maxAttempts",
+	"proxy":              "proxy",
+}
+
 func newRootCmd() *cobra.Command {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -41,9 +79,24 @@ func newRootCmd() *cobra.Command {
 		Use:   "oss",
 		Short: "Manage chart repositories on Alibaba Cloud OSS",
 		Long:  rootDesc,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if v := os.Getenv(timeoutEnv); v != "" && !cmd.Flags().Changed("timeout") {
+				timeout, err := time.ParseDuration(v)
+				if err != nil {
+					return newBadUsageError(fmt.Errorf("invalid %s: %w", timeoutEnv, err))
+				}
+				opts.timeout = timeout
+			}
+
+			logger, err := opts.newLogger(cmd)
+			if err != nil {
+				return newBadUsageError(err)
+			}
+			slog.SetDefault(logger)
+
 			ctx, cancel = context.WithTimeout(cmd.Context(), opts.timeout)
-			cmd.SetContext(ctx)
+			cmd.SetContext(withConfigOptions(ctx, opts.configOptions(cmd)))
+			return nil
 		},
 		PersistentPostRun: func(cmd *cobra.Command, args []string) {
 			cancel()
@@ -60,7 +113,18 @@ func newRootCmd() *cobra.Command {
 	}
 
 	flags := cmd.PersistentFlags()
-	flags.BoolVar(&opts.verbose, "verbose", opts.verbose, "Enable verbose output.")
+	flags.BoolVar(&opts.verbose, "verbose", opts.verbose, "Enable verbose output, same as '--log-level debug'.")
+	flags.StringVar(&opts.logLevel, "log-level", opts.logLevel, "Log level: debug, info, warn or error. Defaults to warn.")
+	flags.StringVar(&opts.logFormat, "log-format", opts.logFormat, "Log format: text or json.")
+	flags.StringVar(&opts.profile, "profile", opts.profile, "Configuration profile to use.")
+	flags.DurationVar(&opts.timeout, "timeout", opts.timeout, "Timeout of the whole command.")
+	flags.DurationVar(&opts.connectTimeout, "connect-timeout", opts.connectTimeout,
+		"Timeout of establishing connections to OSS. Defaults to 10s.")
+	flags.DurationVar(&opts.readWriteTimeout, "read-write-timeout", opts.readWriteTimeout,
+		"Timeout of reading or writing data of connections to OSS. Defaults to 20s.")
+	flags.IntVar(&opts.retryMaxAttempts, "retry-max-attempts", opts.retryMaxAttempts,
+		"Maximum number of attempts of a failed OSS request. Defaults to 3.")
+	flags.StringVar(&opts.proxy, "proxy", opts.proxy, "Proxy URL for OSS requests.")
 
 	cmd.SetFlagErrorFunc(func(command *cobra.Command, err error) error {
 		return newBadUsageError(err)
@@ -73,8 +137,16 @@ func newRootCmd() *cobra.Command {
 		newDownloadCommand(),
 		newInitCommand(),
 		newPushCommand(),
-		newReindexCommand(opts),
+		newReindexCommand(),
 		newDeleteCommand(),
+		newListCommand(),
+		newShowCommand(),
+		newPullCommand(),
+		newLockCommand(),
+		newRecoverCommand(),
+		newFsckCommand(),
+		newVerifyCommand(),
+		newConfigCommand(),
 		newVersionCommand(),
 	)
 
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/oss"
+)
+
+func TestRootCmd_Flags(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(oss.ConnectTimeoutEnv, "")
+	t.Setenv(oss.RetryMaxAttemptsEnv, "2")
+
+	var stdout bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"--connect-timeout", "3s", "--retry-max-attempts", "5", "config", "view"})
+	require.NoError(t, cmd.Execute())
+
+	// The flags override the configuration, and the environment is kept.
+	assert.Regexp(t, `connectTimeout +3s +flag --connect-timeout`, stdout.String())
+	assert.Regexp(t, `retry.maxAttempts +5 +flag --retry-max-attempts`, stdout.String())
+	assert.NotContains(t, stdout.String(), "proxy")
+	assert.Empty(t, os.Getenv(oss.ConnectTimeoutEnv))
+	assert.Equal(t, "2", os.Getenv(oss.RetryMaxAttemptsEnv))
+}
+
+func TestRootCmd_Profile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(oss.ProfileEnv, "dev")
+
+	var stdout bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"--profile", "prod", "config", "set", "region", "cn-shanghai"})
+	require.NoError(t, cmd.Execute())
+	assert.Equal(t, "Set region in profile prod.\n", stdout.String())
+	assert.Equal(t, "dev", os.Getenv(oss.ProfileEnv))
+
+	stdout.Reset()
+	cmd = newRootCmd()
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"--profile", "prod", "config", "view"})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, stdout.String(), "Profile: prod (selected with --profile)")
+	assert.Regexp(t, `region +cn-shanghai +profile prod`, stdout.String())
+}
+
+func TestRootCmd_TimeoutEnv(t *testing.T) {
+	t.Setenv(timeoutEnv, "soon")
+
+	cmd := newRootCmd()
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"version"})
+	err := cmd.Execute()
+	assert.True(t, errorTypeBadUsage.Is(err))
+
+	// The flag takes precedence.
+	cmd = newRootCmd()
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"--timeout", "1m", "version"})
+	require.NoError(t, cmd.Execute())
+}
+
+func TestRootCmd_Logging(t *testing.T) {
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	t.Setenv(logLevelEnv, "")
+	t.Setenv(logFormatEnv, "json")
+
+	var stderr bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--log-level", "debug", "version"})
+	require.NoError(t, cmd.Execute())
+
+	slog.Debug("test message", "key", "value")
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(stderr.Bytes(), &record))
+	assert.Equal(t, "DEBUG", record["level"])
+	assert.Equal(t, "test message", record["msg"])
+
+	// The default level is warn, --verbose is a shorthand for debug.
+	cmd = newRootCmd()
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"version"})
+	require.NoError(t, cmd.Execute())
+	assert.False(t, slog.Default().Enabled(context.Background(), slog.LevelInfo))
+	assert.True(t, slog.Default().Enabled(context.Background(), slog.LevelWarn))
+
+	cmd = newRootCmd()
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"--verbose", "version"})
+	require.NoError(t, cmd.Execute())
+	assert.True(t, slog.Default().Enabled(context.Background(), slog.LevelDebug))
+
+	for _, args := range [][]string{{"--log-level", "loud"}, {"--log-format", "xml"}} {
+		cmd = newRootCmd()
+		cmd.SetOut(io.Discard)
+		cmd.SetArgs(append(args, "version"))
+		err := cmd.Execute()
+		assert.True(t, errorTypeBadUsage.Is(err), args)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm-oss/internal/helmutil"
+	"sigs.k8s.io/yaml"
+)
+
+const showDesc = `This command shows information about a chart in the repository.
+
+The chart is read directly from the repository, without 'helm pull', so the
+published versions can be inspected by OSS URI as well.
+`
+
+const showPartDesc = `This command shows the %s.
+
+'helm oss show %s' takes two arguments:
+- NAME - name of the chart,
+- REPO_OR_URI - target repository name or OSS URI.
+
+The version is either exact or a semver constraint, e.g. '^1.2'. If it is not
+set, the newest version which is not a prerelease is shown.
+`
+
+// Parts of the chart shown by the show subcommands.
+const (
+	showChart  = "chart"
+	showValues = "values"
+	showReadme = "readme"
+	showFiles  = "files"
+)
+
+func newShowCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show information about a chart in the repository.",
+		Long:  showDesc,
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+
+	cmd.AddCommand(
+		newShowPartCommand(showChart, "chart metadata (Chart.yaml)",
+			"Chart.yaml is taken from the chart object metadata when present, so the chart is not downloaded."),
+		newShowPartCommand(showValues, "chart values (values.yaml)", ""),
+		newShowPartCommand(showReadme, "chart README", ""),
+		newShowPartCommand(showFiles, "files in the chart archive with their sizes", ""),
+	)
+
+	return cmd
+}
+
+func newShowPartCommand(part, what, note string) *cobra.Command {
+	act := &showAction{
+		printer:   nil,
+		part:      part,
+		chartName: "",
+		repoOrURI: "",
+		version:   "",
+	}
+
+	long := fmt.Sprintf(showPartDesc, what, part)
+	if note != "" {
+		long += "\n" + note + "\n"
+	}
+
+	cmd := &cobra.Command{
+		Use:   part + " NAME REPO_OR_URI",
+		Short: fmt.Sprintf("Show the %s.", what),
+		Long:  long,
+		Example: fmt.Sprintf(`  helm oss show %[1]s epicservice my-repo                             - shows the newest version in repository 'my-repo'
+  helm oss show %[1]s epicservice --version 0.5.1 oss://bucket/charts - shows the version from OSS URI directly`, part),
+		Args: wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the NAME and REPO_OR_URI arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.chartName = args[0]
+			act.repoOrURI = args[1]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Version or semver constraint of the chart.")
+
+	return cmd
+}
+
+type showAction struct {
+	printer printer
+
+	// part is the part of the chart to show, e.g. showValues.
+	part string
+
+	// args
+
+	chartName string
+	repoOrURI string
+
+	// flags
+
+	version string
+}
+
+func (act *showAction) run(ctx context.Context) error {
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, storage, repo)
+	if err != nil {
+		return err
+	}
+
+	entry, err := findChartVersion(idx, act.chartName, act.version)
+	if err != nil {
+		return err
+	}
+	uri := helmutil.JoinURL(repo.URL(), chartFileName(repo, entry.URL))
+
+	if act.part == showChart {
+		// The metadata is taken from the object metadata when present.
+		info, err := storage.LoadChart(ctx, uri)
+		if err != nil {
+			return errors.WithMessage(err, "load chart")
+		}
+		b, err := info.Meta.MarshalJSON()
+		if err != nil {
+			return errors.Wrap(err, "marshal chart metadata")
+		}
+		b, err = yaml.JSONToYAML(b)
+		if err != nil {
+			return errors.Wrap(err, "marshal chart metadata")
+		}
+		act.printer.Printf("%s", b)
+		return nil
+	}
+
+	r, err := storage.Open(ctx, uri)
+	if err != nil {
+		return errors.WithMessage(err, "fetch chart")
+	}
+	defer r.Close()
+
+	chart, err := helmutil.LoadArchive(r)
+	if err != nil {
+		return err
+	}
+
+	switch act.part {
+	case showValues:
+		act.printer.Printf("%s", chart.Values())
+	case showReadme:
+		readme := chart.Readme()
+		if readme == nil {
+			return fmt.Errorf("chart %s %s has no README", entry.Name, entry.Version)
+		}
+		act.printer.Printf("%s", readme)
+	case showFiles:
+		var b strings.Builder
+		w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE")
+		for _, f := range chart.Files() {
+			fmt.Fprintf(w, "%s\t%d\n", f.Name, f.Size)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		act.printer.Printf("%s", b.String())
+	}
+	return nil
+}
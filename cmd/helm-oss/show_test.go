@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+func TestShowAction(t *testing.T) {
+	ctx := context.Background()
+	setupRepo(t)
+
+	// The newest chart has a README and a prerelease version.
+	ch, err := loader.Load("../../testdata/foo")
+	require.NoError(t, err)
+	ch.Metadata.Version = "2.0.0-rc.1"
+	ch.Files = append(ch.Files, &chart.File{Name: "README.md", Data: []byte("# foo\n")})
+	rcPath, err := chartutil.Save(ch, t.TempDir())
+	require.NoError(t, err)
+
+	pushTestCharts(t, rcPath)
+
+	show := func(t *testing.T, part, version string) (string, error) {
+		t.Helper()
+
+		p := &testPrinter{}
+		act := &showAction{printer: p, part: part, chartName: "foo", repoOrURI: testRepoURI, version: version}
+		err := act.run(ctx)
+		return p.out.String(), err
+	}
+
+	t.Run("should show chart metadata of the newest stable version", func(t *testing.T) {
+		out, err := show(t, showChart, "")
+		require.NoError(t, err)
+		assert.Contains(t, out, "name: foo\n")
+		assert.Contains(t, out, "version: 1.3.1\n")
+	})
+
+	t.Run("should show values", func(t *testing.T) {
+		out, err := show(t, showValues, "~1.2")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(out, "# Default values for foo."))
+		assert.Contains(t, out, "replicaCount: 1")
+	})
+
+	t.Run("should show readme", func(t *testing.T) {
+		out, err := show(t, showReadme, "2.0.0-rc.1")
+		require.NoError(t, err)
+		assert.Equal(t, "# foo\n", out)
+
+		_, err = show(t, showReadme, "1.3.1")
+		assert.ErrorContains(t, err, "has no README")
+	})
+
+	t.Run("should show files", func(t *testing.T) {
+		out, err := show(t, showFiles, "1.2.3")
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		assert.Equal(t, []string{"NAME", "SIZE"}, strings.Fields(lines[0]))
+		assert.Contains(t, out, "Chart.yaml")
+		assert.Contains(t, out, "templates/deployment.yaml")
+	})
+
+	t.Run("should fail for missing version", func(t *testing.T) {
+		_, err := show(t, showValues, "9.9.9")
+		assert.ErrorContains(t, err, "chart foo version 9.9.9 not found")
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+// this file contains utilities for testing code in this package.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+const (
+	testRepoURI         = "oss://test-bucket/charts"
+	testChartPath       = "../../testdata/foo-1.2.3.tgz"
+	testSignedChartPath = "../../testdata/foo-1.3.1.tgz"
+)
+
+func TestMain(m *testing.M) {
+	// The actions log to the default logger, which is only configured by the
+	// root command.
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	os.Exit(m.Run())
+}
+
+// testPrinter implements printer and records the output.
+type testPrinter struct {
+	out bytes.Buffer
+	err bytes.Buffer
+}
+
+func (p *testPrinter) Printf(format string, v ...any) {
+	fmt.Fprintf(&p.out, format, v...)
+}
+
+func (p *testPrinter) PrintErrf(format string, i ...any) {
+	fmt.Fprintf(&p.err, format, i...)
+}
+
+// mockBackend makes commands use the provided backend for the duration of
+// the test.
+func mockBackend(t *testing.T, b oss.Backend) {
+	t.Helper()
+
+	old := newBackend
+	newBackend = func(context.Context, string) (oss.Backend, error) {
+		return b, nil
+	}
+	t.Cleanup(func() {
+		newBackend = old
+	})
+}
+
+// setupRepo returns an in-memory backend with an initialized repository
+// at testRepoURI.
+func setupRepo(t *testing.T) *oss.MemoryBackend {
+	t.Helper()
+
+	b := oss.NewMemoryBackend()
+	mockBackend(t, b)
+
+	r, err := helmutil.NewIndex().Reader()
+	require.NoError(t, err)
+	require.NoError(t, b.PutIndex(context.Background(), testRepoURI, "", r))
+
+	return b
+}
+
+// pushTestCharts pushes the unsigned chart at testChartPath, the signed one
+// at testSignedChartPath and then the extra charts to the repository at
+// testRepoURI.
+func pushTestCharts(t *testing.T, extra ...string) {
+	t.Helper()
+
+	for _, chartPath := range append([]string{testChartPath, testSignedChartPath}, extra...) {
+		push := &pushAction{printer: &testPrinter{}, chartPath: chartPath, repoOrURI: testRepoURI}
+		require.NoError(t, push.run(context.Background()))
+	}
+}
+
+// loadRepoIndex loads the index of the repository at testRepoURI.
+func loadRepoIndex(t *testing.T, b oss.Backend) *helmutil.Index {
+	t.Helper()
+
+	data, _, err := b.FetchRaw(context.Background(), helmutil.IndexFileURL(testRepoURI))
+	require.NoError(t, err)
+
+	idx := helmutil.NewIndex()
+	require.NoError(t, idx.UnmarshalBinary(data))
+	return idx
+}
+
+// mustRepo returns the repository at testRepoURI.
+func mustRepo(t *testing.T) helmutil.Repository {
+	t.Helper()
+
+	repo, err := helmutil.NewRepository(testRepoURI)
+	require.NoError(t, err)
+	return repo
+}
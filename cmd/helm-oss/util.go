@@ -1,5 +1,50 @@
 package main
 
+import (
+	"context"
+	"time"
+
+	"helm-oss/internal/oss"
+)
+
+// newBackend returns the storage backend serving the repository URL,
+// configured with the options from ctx, see withConfigOptions.
+// Defined for testing purposes.
+var newBackend = func(ctx context.Context, uri string) (oss.Backend, error) {
+	return oss.NewBackend(uri, configOptions(ctx))
+}
+
+// configOptionsKey is the context key of the configuration options.
+type configOptionsKey struct{}
+
+// withConfigOptions returns the context passing the configuration options,
+// set with the global flags, to the commands.
+func withConfigOptions(ctx context.Context, opts oss.ConfigOptions) context.Context {
+	return context.WithValue(ctx, configOptionsKey{}, opts)
+}
+
+// configOptions returns the configuration options from ctx, or the zero
+// options if ctx has none.
+func configOptions(ctx context.Context) oss.ConfigOptions {
+	opts, _ := ctx.Value(configOptionsKey{}).(oss.ConfigOptions)
+	return opts
+}
+
+// cleanupTimeout limits the cleanup done after the command has been canceled.
+const cleanupTimeout = 30 * time.Second
+
+// cleanupContext returns the context for the cleanup, such as a rollback or
+// the release of the repository lock, which has to run even if ctx is
+// already done. It keeps the values of ctx, but not its cancellation, and
+// limits the cleanup to cleanupTimeout.
+//
+// There are no partial uploads to clean up: the charts and the index are
+// uploaded with single PutObject requests, not multipart uploads, so an
+// aborted upload leaves no object behind.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
+
 type printer interface {
 	Printf(format string, v ...any)
 	PrintErrf(format string, i ...any)
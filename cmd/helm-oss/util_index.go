@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+// indexUpdateMaxAttempts is the maximum number of attempts to update the
+// index when concurrent modifications are detected.
+const indexUpdateMaxAttempts = 5
+
+// indexUpdateBaseBackoff is the initial delay between index update attempts.
+// It doubles with every attempt. It is a variable, so that tests can shorten
+// it.
+var indexUpdateBaseBackoff = 200 * time.Millisecond
+
+// indexMutation applies a change to the repository index and returns the
+// resulting index. It may be called several times, each time with a freshly
+// fetched index, so it must not have side effects other than on the index.
+// current is nil if the repository has no index yet.
+type indexMutation func(current *helmutil.Index) (*helmutil.Index, error)
+
+// indexUnchangedError is returned by updateIndex when it has failed before
+// the index was written, so the index is known to be unchanged. Other errors
+// of updateIndex leave the index in an unknown state, e.g. the upload may
+// have succeeded even though the response has not been received.
+type indexUnchangedError struct {
+	err error
+}
+
+func (e *indexUnchangedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *indexUnchangedError) Unwrap() error {
+	return e.err
+}
+
+// indexUnchanged returns true if err returned by updateIndex proves that the
+// index has not been written.
+func indexUnchanged(err error) bool {
+	var unchangedErr *indexUnchangedError
+	return errors.As(err, &unchangedErr)
+}
+
+// fetchIndex downloads and parses the repository index.
+// It returns the index along with its ETag.
+func fetchIndex(ctx context.Context, storage oss.Backend, repo helmutil.Repository) (*helmutil.Index, string, error) {
+	b, etag, err := storage.FetchRaw(ctx, repo.IndexURL())
+	if err != nil {
+		return nil, "", errors.WithMessage(err, "fetch current repo index")
+	}
+
+	idx := helmutil.NewIndex()
+	if err := idx.UnmarshalBinary(b); err != nil {
+		return nil, "", errors.WithMessage(err, "load index from downloaded file")
+	}
+
+	return idx, etag, nil
+}
+
+// updateIndex performs optimistic fetch-modify-put cycle on the repository
+// index. If the index was modified concurrently, it is fetched again and the
+// mutation is re-applied, up to indexUpdateMaxAttempts times.
+// It must be called under the repository lock, see withRepoLock: OSS cannot
+// replace the index conditionally, so the ETag check alone does not prevent
+// concurrent writers from overwriting each other.
+// If allowMissing is true, a missing index is not an error and the mutation
+// receives nil index.
+// If the index is known to be unchanged on error, *indexUnchangedError is
+// returned, see indexUnchanged.
+func updateIndex(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	allowMissing bool,
+	mutate indexMutation,
+) (*helmutil.Index, error) {
+	backoff := indexUpdateBaseBackoff
+	for attempt := 1; ; attempt++ {
+		current, etag, err := fetchIndex(ctx, storage, repo)
+		if err != nil {
+			if !allowMissing || !errors.Is(err, oss.ErrObjectNotFound) {
+				return nil, &indexUnchangedError{err: err}
+			}
+			current, etag = nil, ""
+		}
+
+		idx, err := mutate(current)
+		if err != nil {
+			return nil, &indexUnchangedError{err: err}
+		}
+
+		r, err := idx.Reader()
+		if err != nil {
+			return nil, &indexUnchangedError{err: errors.Wrap(err, "get index reader")}
+		}
+
+		// Once the cancellation is requested, the index is not written, so
+		// that the operation is rolled back rather than completed.
+		if err := context.Cause(ctx); err != nil {
+			return nil, &indexUnchangedError{err: err}
+		}
+
+		err = storage.PutIndex(ctx, repo.URL(), etag, r)
+		if err == nil {
+			slog.InfoContext(ctx, "updated repository index", "repo", repo.URL(), "attempt", attempt)
+			return idx, nil
+		}
+		if !errors.Is(err, oss.ErrIndexConflict) {
+			return nil, errors.WithMessage(err, "upload index to oss")
+		}
+		if attempt == indexUpdateMaxAttempts {
+			return nil, &indexUnchangedError{err: errors.WithMessage(err, "upload index to oss")}
+		}
+
+		// Add jitter so that concurrent writers do not retry in lockstep.
+		delay := backoff + rand.N(backoff)
+		slog.InfoContext(ctx, "index was modified concurrently, retrying", "repo", repo.URL(), "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return nil, &indexUnchangedError{err: ctx.Err()}
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// findChartVersion returns the index entry of the chart version. The version
+// is either exact or a semver constraint, e.g. '^1.2', in which case the
+// newest matching version is returned. If the version is empty, the newest
+// version which is not a prerelease is returned.
+func findChartVersion(idx *helmutil.Index, name, version string) (helmutil.IndexEntry, error) {
+	var versions []helmutil.IndexEntry
+	for _, entry := range idx.Entries() {
+		if entry.Name != name {
+			continue
+		}
+		if entry.Version == version {
+			return entry, nil
+		}
+		versions = append(versions, entry)
+	}
+	if len(versions) == 0 {
+		return helmutil.IndexEntry{}, fmt.Errorf("chart %s not found in the repository", name)
+	}
+
+	constraint := "*"
+	if version != "" {
+		constraint = version
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return helmutil.IndexEntry{}, fmt.Errorf("chart %s version %s not found in the repository", name, version)
+	}
+
+	var found *helmutil.IndexEntry
+	for i, entry := range versions {
+		v, err := semver.NewVersion(entry.Version)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if found == nil || compareVersions(entry.Version, found.Version) > 0 {
+			found = &versions[i]
+		}
+	}
+	if found == nil {
+		if version == "" {
+			return helmutil.IndexEntry{}, fmt.Errorf("chart %s has only prerelease versions, set the version explicitly", name)
+		}
+		return helmutil.IndexEntry{}, fmt.Errorf("chart %s version %s not found in the repository", name, version)
+	}
+	return *found, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+// stalePutBackend fails as many index uploads as set by conflicts with
+// ErrIndexConflict, emulating an index modified since it has been fetched.
+type stalePutBackend struct {
+	*oss.MemoryBackend
+
+	conflicts int
+	puts      int
+}
+
+func (b *stalePutBackend) PutIndex(ctx context.Context, uri string, etag string, r io.Reader) error {
+	b.puts++
+	if b.puts <= b.conflicts {
+		return oss.ErrIndexConflict
+	}
+	return b.MemoryBackend.PutIndex(ctx, uri, etag, r)
+}
+
+func TestUpdateIndex(t *testing.T) {
+	old := indexUpdateBaseBackoff
+	indexUpdateBaseBackoff = time.Millisecond
+	t.Cleanup(func() { indexUpdateBaseBackoff = old })
+
+	ctx := context.Background()
+	repo := mustRepo(t)
+
+	addChart := func(calls *int) indexMutation {
+		return func(idx *helmutil.Index) (*helmutil.Index, error) {
+			*calls++
+			meta := helmutil.NewChartMetadata()
+			if err := meta.UnmarshalJSON([]byte(`{"name":"foo","version":"1.2.3"}`)); err != nil {
+				return nil, err
+			}
+			return idx, idx.AddOrReplace(meta.Value(), "foo-1.2.3.tgz", "", "sha256:foo")
+		}
+	}
+
+	t.Run("should retry with fresh index on conflict", func(t *testing.T) {
+		b := &stalePutBackend{MemoryBackend: setupRepo(t), conflicts: 2}
+
+		calls := 0
+		idx, err := updateIndex(ctx, b, repo, false, addChart(&calls))
+		require.NoError(t, err)
+		assert.True(t, idx.Has("foo", "1.2.3"))
+		assert.Equal(t, 3, calls)
+		assert.True(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+	})
+
+	t.Run("should give up after max attempts", func(t *testing.T) {
+		b := &stalePutBackend{MemoryBackend: setupRepo(t), conflicts: indexUpdateMaxAttempts}
+
+		calls := 0
+		_, err := updateIndex(ctx, b, repo, false, addChart(&calls))
+		assert.ErrorIs(t, err, oss.ErrIndexConflict)
+		assert.True(t, indexUnchanged(err))
+		assert.Equal(t, indexUpdateMaxAttempts, calls)
+		assert.False(t, loadRepoIndex(t, b).Has("foo", "1.2.3"))
+	})
+
+	t.Run("should not retry mutation error", func(t *testing.T) {
+		b := &stalePutBackend{MemoryBackend: setupRepo(t)}
+
+		mutateErr := errors.New("mutation failed")
+		_, err := updateIndex(ctx, b, repo, false, func(*helmutil.Index) (*helmutil.Index, error) {
+			return nil, mutateErr
+		})
+		assert.ErrorIs(t, err, mutateErr)
+		assert.True(t, indexUnchanged(err))
+		assert.Zero(t, b.puts)
+	})
+
+	t.Run("should not report failed upload as unchanged", func(t *testing.T) {
+		b := &failingIndexBackend{MemoryBackend: setupRepo(t)}
+
+		_, err := updateIndex(ctx, b, repo, false, addChart(new(int)))
+		assert.ErrorContains(t, err, "index update failed")
+		assert.False(t, indexUnchanged(err), "the index may have been written")
+	})
+
+	t.Run("should create missing index if allowed", func(t *testing.T) {
+		b := oss.NewMemoryBackend()
+
+		_, err := updateIndex(ctx, b, repo, false, addChart(new(int)))
+		assert.ErrorIs(t, err, oss.ErrObjectNotFound)
+
+		_, err = updateIndex(ctx, b, repo, true, func(idx *helmutil.Index) (*helmutil.Index, error) {
+			assert.Nil(t, idx)
+			return helmutil.NewIndex(), nil
+		})
+		require.NoError(t, err)
+		exists, err := oss.IndexExists(ctx, b, repo.URL())
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+// beginJournal records the operation in the repository journal before it
+// modifies the repository. It must be called under the repository lock, see
+// withRepoLock, so that recover can tell whether the operation is in progress.
+func beginJournal(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	operation string,
+	chart *oss.JournalChart,
+) (oss.Journal, error) {
+	journal := oss.NewJournal(operation, chart)
+	journal.LockID = repoLockID(ctx)
+	if err := storage.WriteJournal(ctx, repo.URL(), journal); err != nil {
+		return oss.Journal{}, errors.WithMessage(err, "write operation journal")
+	}
+	slog.DebugContext(ctx, "wrote operation journal", "repo", repo.URL(), "journal_id", journal.ID, "operation", operation)
+	return journal, nil
+}
+
+// completeJournal marks the journaled operation as completed. The operation
+// itself has already succeeded at this point, and recovering a completed
+// operation is harmless, so a failure is only reported.
+func completeJournal(ctx context.Context, p printer, storage oss.Backend, repo helmutil.Repository, journal oss.Journal) {
+	ctx, cancel := cleanupContext(ctx)
+	defer cancel()
+
+	if err := storage.CompleteJournal(ctx, repo.URL(), journal.ID); err != nil {
+		p.PrintErrf("Failed to mark the operation as completed, `helm oss recover %s` will check it again: %s\n", repo.URL(), err)
+	}
+}
+
+// resolveChartURL returns the absolute URL of the chart by its URL from the
+// index, which may be relative to the repository.
+func resolveChartURL(repo helmutil.Repository, url string) string {
+	if strings.HasPrefix(url, helmutil.TrimURLQuery(repo.URL())) {
+		return url
+	}
+	return helmutil.JoinURL(repo.URL(), url)
+}
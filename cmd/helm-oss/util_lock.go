@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+// repoLockPollInterval is the delay between attempts to acquire the
+// repository lock held by someone else.
+const repoLockPollInterval = 2 * time.Second
+
+// repoLockTTL is the lease duration of the repository lock. The lease is
+// renewed every third of it while the lock is held, so that a crashed process
+// does not block the repository for longer than that. It is a variable, so
+// that tests can shorten it.
+var repoLockTTL = 10 * time.Minute
+
+// repoLockKey is the context key of the ID of the held repository lock.
+type repoLockKey struct{}
+
+// repoLockID returns the ID of the repository lock held by the operation
+// running with ctx, or an empty string if the lock is not held.
+func repoLockID(ctx context.Context) string {
+	id, _ := ctx.Value(repoLockKey{}).(string)
+	return id
+}
+
+// withRepoLock runs fn while holding the repository lock. If the lock is held
+// by someone else, it waits until the lock is released or ctx is done.
+//
+// The lock lease is renewed while fn runs. If the lock is lost, e.g. because
+// it has been taken over, or it is about to expire as it could not be
+// renewed, the context passed to fn is canceled with oss.ErrLockLost as the
+// cause. So fn never runs after its lock has expired, and whoever acquires
+// the lock next may assume that fn has stopped.
+func withRepoLock(
+	ctx context.Context,
+	p printer,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	operation string,
+	fn func(ctx context.Context) error,
+) error {
+	lock := oss.NewLock(operation, repoLockTTL)
+
+	waiting := false
+	for {
+		err := storage.AcquireLock(ctx, repo.URL(), lock)
+		if err == nil {
+			break
+		}
+
+		var lockedErr *oss.LockedError
+		if !errors.As(err, &lockedErr) {
+			return errors.WithMessage(err, "acquire repository lock")
+		}
+
+		if !waiting {
+			p.PrintErrf("Waiting for the repository lock: %s\n", lockedErr)
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(
+				lockedErr,
+				"acquire repository lock (if the lock holder is gone, run `helm oss lock break %s`)",
+				repo.URL(),
+			)
+		case <-time.After(repoLockPollInterval):
+		}
+	}
+
+	slog.InfoContext(ctx, "acquired repository lock", "repo", repo.URL(), "lock_id", lock.ID, "operation", operation)
+
+	lockCtx, cancelLock := context.WithCancelCause(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		renewRepoLock(lockCtx, cancelLock, storage, repo, lock)
+	}()
+
+	fnErr := fn(context.WithValue(lockCtx, repoLockKey{}, lock.ID))
+	lost := errors.Is(context.Cause(lockCtx), oss.ErrLockLost)
+	cancelLock(nil)
+	<-renewed
+
+	if lost {
+		// The lock is held by someone else now, or it cannot be renewed and
+		// expires shortly, so it is not released.
+		if fnErr != nil {
+			return errors.WithMessage(fnErr, oss.ErrLockLost.Error())
+		}
+		return nil
+	}
+
+	releaseCtx, cancel := cleanupContext(ctx)
+	defer cancel()
+	if err := storage.ReleaseLock(releaseCtx, repo.URL(), lock.ID); err != nil {
+		if fnErr != nil {
+			return fnErr
+		}
+		return errors.WithMessage(err, "release repository lock")
+	}
+	slog.InfoContext(ctx, "released repository lock", "repo", repo.URL(), "lock_id", lock.ID)
+
+	return fnErr
+}
+
+// renewRepoLock renews the lease of the held repository lock until ctx is
+// done. If the lock has been lost, it cancels ctx with oss.ErrLockLost. Other
+// renewal failures are retried on the next renewal, unless the lease would
+// expire before it: then ctx is canceled with oss.ErrLockLost as well, so
+// that the holder stops while the lock is still held.
+func renewRepoLock(
+	ctx context.Context,
+	cancel context.CancelCauseFunc,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	lock oss.Lock,
+) {
+	id := lock.ID
+	period := repoLockTTL / 3
+	expires := lock.Expires
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed := time.Now().Add(repoLockTTL)
+		err := storage.RenewLock(ctx, repo.URL(), id, repoLockTTL)
+		switch {
+		case err == nil:
+			expires = renewed
+			slog.DebugContext(ctx, "renewed repository lock", "repo", repo.URL(), "lock_id", id)
+		case errors.Is(err, oss.ErrLockLost):
+			slog.ErrorContext(ctx, "repository lock was lost", "repo", repo.URL(), "lock_id", id)
+			cancel(err)
+			return
+		case ctx.Err() != nil:
+			return
+		case time.Until(expires) < period:
+			slog.ErrorContext(ctx, "repository lock is about to expire", "repo", repo.URL(), "lock_id", id, "error", err)
+			cancel(oss.ErrLockLost)
+			return
+		default:
+			slog.WarnContext(ctx, "failed to renew repository lock", "repo", repo.URL(), "lock_id", id, "error", err)
+		}
+	}
+}
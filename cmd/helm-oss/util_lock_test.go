@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/oss"
+)
+
+// unrenewableBackend fails to renew the repository lock, e.g. because OSS is
+// unreachable.
+type unrenewableBackend struct {
+	*oss.MemoryBackend
+}
+
+func (b *unrenewableBackend) RenewLock(context.Context, string, string, time.Duration) error {
+	return errors.New("renewal failed")
+}
+
+func TestWithRepoLock(t *testing.T) {
+	old := repoLockTTL
+	repoLockTTL = 300 * time.Millisecond
+	t.Cleanup(func() { repoLockTTL = old })
+
+	ctx := context.Background()
+	repo := mustRepo(t)
+
+	t.Run("should renew lock while running", func(t *testing.T) {
+		b := setupRepo(t)
+
+		err := withRepoLock(ctx, &testPrinter{}, b, repo, "reindex", func(ctx context.Context) error {
+			time.Sleep(2 * repoLockTTL)
+			err := b.AcquireLock(ctx, repo.URL(), oss.NewLock("push", time.Minute))
+			assert.ErrorIs(t, err, oss.ErrLocked, "renewed lock must not be taken over")
+			return ctx.Err()
+		})
+		require.NoError(t, err)
+
+		_, found, err := b.ReadLock(ctx, repo.URL())
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("should cancel when lock is lost", func(t *testing.T) {
+		b := setupRepo(t)
+		other := oss.NewLock("push", time.Minute)
+
+		err := withRepoLock(ctx, &testPrinter{}, b, repo, "reindex", func(ctx context.Context) error {
+			require.NoError(t, b.BreakLock(ctx, repo.URL(), repoLockID(ctx)))
+			require.NoError(t, b.AcquireLock(ctx, repo.URL(), other))
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("context is not canceled")
+			}
+			assert.ErrorIs(t, context.Cause(ctx), oss.ErrLockLost)
+			return ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorContains(t, err, "repository lock was lost")
+
+		holder, found, err := b.ReadLock(ctx, repo.URL())
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, other.ID, holder.ID, "lock of someone else must be kept")
+	})
+
+	t.Run("should cancel before lock expires if it cannot be renewed", func(t *testing.T) {
+		b := &unrenewableBackend{MemoryBackend: setupRepo(t)}
+
+		var expires time.Time
+		err := withRepoLock(ctx, &testPrinter{}, b, repo, "reindex", func(ctx context.Context) error {
+			holder, _, err := b.ReadLock(ctx, repo.URL())
+			require.NoError(t, err)
+			expires = holder.Expires
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("context is not canceled")
+			}
+			assert.True(t, time.Now().Before(expires), "must stop while the lock is held")
+			assert.ErrorIs(t, context.Cause(ctx), oss.ErrLockLost)
+			return ctx.Err()
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("should pass lock id to fn", func(t *testing.T) {
+		b := setupRepo(t)
+
+		err := withRepoLock(ctx, &testPrinter{}, b, repo, "reindex", func(ctx context.Context) error {
+			holder, _, err := b.ReadLock(ctx, repo.URL())
+			require.NoError(t, err)
+			assert.Equal(t, holder.ID, repoLockID(ctx))
+			return nil
+		})
+		require.NoError(t, err)
+	})
+}
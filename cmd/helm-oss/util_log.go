@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// defaultLogLevel is the log level if neither '--log-level' nor '--verbose'
+// is set. The errors are reported by the commands themselves, so only the
+// warnings are logged.
+const defaultLogLevel = "warn"
+
+// newLogger returns the logger writing to w with the level (debug, info,
+// warn or error) and the format (text or json).
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, must be one of: debug, info, warn, error", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be one of: %s, %s", format, logFormatText, logFormatJSON)
+	}
+}
+
+// newLogger returns the logger configured by the flags, or by the
+// environment variables if the flags are not set.
+func (opts *options) newLogger(cmd *cobra.Command) (*slog.Logger, error) {
+	level := opts.logLevel
+	if v := os.Getenv(logLevelEnv); v != "" && !cmd.Flags().Changed("log-level") {
+		level = v
+	}
+	if level == "" {
+		level = defaultLogLevel
+		if opts.verbose {
+			level = "debug"
+		}
+	}
+
+	format := opts.logFormat
+	if v := os.Getenv(logFormatEnv); v != "" && !cmd.Flags().Changed("log-format") {
+		format = v
+	}
+
+	return newLogger(cmd.ErrOrStderr(), level, format)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+	"helm-oss/internal/oss"
+)
+
+// chartSnapshot is the state of the chart objects before they are
+// overwritten. It is used to undo the upload if the operation fails later.
+type chartSnapshot struct {
+	uri string
+
+	// chart and prov are the previous object contents,
+	// nil if the object did not exist.
+	chart []byte
+	prov  []byte
+
+	// chartInfo is the previous chart object, including its metadata.
+	chartInfo oss.ObjectInfo
+}
+
+// takeChartSnapshot saves the current state of the chart object by uri and
+// its provenance file. The objects are only downloaded if they exist.
+func takeChartSnapshot(ctx context.Context, storage oss.Backend, uri string) (*chartSnapshot, error) {
+	snapshot := &chartSnapshot{uri: uri}
+
+	info, err := storage.StatObject(ctx, uri)
+	if errors.Is(err, oss.ErrObjectNotFound) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "save current chart state")
+	}
+	snapshot.chartInfo = info
+
+	snapshot.chart, err = fetchOptional(ctx, storage, uri)
+	if err != nil {
+		return nil, errors.WithMessage(err, "save current chart state")
+	}
+	snapshot.prov, err = fetchOptional(ctx, storage, uri+".prov")
+	if err != nil {
+		return nil, errors.WithMessage(err, "save current provenance state")
+	}
+
+	return snapshot, nil
+}
+
+// restore brings the chart objects back to the saved state.
+// It returns the list of performed steps, suitable for reporting to the user.
+func (s *chartSnapshot) restore(ctx context.Context, storage oss.Backend) ([]string, error) {
+	name := path.Base(s.uri)
+
+	// DeleteChart and PutChart cannot tell whether the provenance file has
+	// been uploaded, so check it to report the performed steps.
+	provUploaded := false
+	if s.prov == nil {
+		exists, err := storage.Exists(ctx, s.uri+".prov")
+		if err != nil {
+			return nil, errors.WithMessagef(err, "check uploaded %s.prov", name)
+		}
+		provUploaded = exists
+	}
+
+	if s.chart == nil {
+		if err := storage.DeleteChart(ctx, s.uri); err != nil {
+			return nil, errors.WithMessagef(err, "delete uploaded %s", name)
+		}
+		if provUploaded {
+			return []string{"deleted uploaded " + name + " and its provenance file"}, nil
+		}
+		return []string{"deleted uploaded " + name}, nil
+	}
+
+	var steps []string
+
+	// PutChart cannot remove the provenance file, so if there was none,
+	// remove both objects first and put the chart back.
+	if provUploaded {
+		if err := storage.DeleteChart(ctx, s.uri); err != nil {
+			return nil, errors.WithMessagef(err, "delete uploaded %s", name)
+		}
+		steps = append(steps, "deleted uploaded "+name+".prov")
+	}
+
+	// The chart metadata recorded at the previous push is restored as is,
+	// even if it does not match the archive.
+	if _, err := storage.PutChart(
+		ctx,
+		s.uri,
+		bytes.NewReader(s.chart),
+		s.chartInfo.ChartMetadata(),
+		s.chartInfo.ChartDigest(),
+		"application/gzip",
+		s.prov != nil,
+		bytes.NewReader(s.prov),
+	); err != nil {
+		return steps, errors.WithMessagef(err, "restore previous %s", name)
+	}
+
+	steps = append(steps, "restored previous "+name)
+	if s.prov != nil {
+		steps = append(steps, "restored previous "+name+".prov")
+	}
+
+	return steps, nil
+}
+
+// fetchOptional downloads the object by uri.
+// It returns nil content and <nil> error if the object does not exist.
+func fetchOptional(ctx context.Context, storage oss.Backend, uri string) ([]byte, error) {
+	data, _, err := storage.FetchRaw(ctx, uri)
+	if errors.Is(err, oss.ErrObjectNotFound) {
+		return nil, nil
+	}
+	return data, err
+}
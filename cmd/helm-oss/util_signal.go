@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// signalError is the cancellation cause of the command interrupted by a
+// signal.
+type signalError struct {
+	sig syscall.Signal
+}
+
+func (e signalError) Error() string {
+	return "canceled by signal: " + e.sig.String()
+}
+
+// exitCode returns the exit code of the command canceled by the signal. It
+// follows the shell convention for the processes killed by a signal, e.g. 130
+// for SIGINT, so that the cancellation can be told apart from the failure.
+func (e signalError) exitCode() int {
+	return 128 + int(e.sig)
+}
+
+// notifyContext returns a copy of ctx which is canceled with signalError as
+// the cause on SIGINT or SIGTERM. The operations in progress are aborted and
+// cleaned up, e.g. the uploaded chart is rolled back and the repository lock
+// is released. After the first signal the default behavior is restored, so
+// the second one terminates the process immediately.
+//
+// The stop function releases the resources and must be called when the
+// command is completed.
+func notifyContext(ctx context.Context, w io.Writer) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			fmt.Fprintf(w, "Received %s, canceling. Send it again to exit immediately.\n", sig)
+			cancel(signalError{sig: sig.(syscall.Signal)})
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyContext(t *testing.T) {
+	out := &bytes.Buffer{}
+	ctx, stop := notifyContext(context.Background(), out)
+	defer stop()
+
+	proc, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, proc.Signal(os.Interrupt))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context is not canceled")
+	}
+
+	var sigErr signalError
+	require.True(t, errors.As(context.Cause(ctx), &sigErr))
+	assert.Equal(t, 130, sigErr.exitCode())
+	assert.Equal(t, "Received interrupt, canceling. Send it again to exit immediately.\n", out.String())
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+const verifyDesc = `This command verifies the integrity of the charts in the repository.
+
+'helm oss verify' takes one argument:
+- REPO_OR_URI - target repository name or OSS URI.
+
+Every chart version in the index, or a random sample of them with --sample
+flag, is downloaded and checked:
+- the SHA-256 digest of the chart must match the index entry and the
+  'chart-digest' object metadata written at push,
+- the provenance file must be signed by a key from the keyring (--keyring,
+  defaults to ~/.gnupg/pubring.gpg) and match the chart.
+
+Each version is reported with one of the statuses:
+- ok: all checks pass,
+- unsigned: the chart has no provenance file,
+- unverifiable: the signature cannot be verified, e.g. the signer key is not
+  in the keyring, or the index has no digest,
+- corrupted: the chart object is missing or differs from the published one.
+
+The command exits with non-zero code unless all verified charts are ok. Use
+--allow-unsigned flag if not all charts of the repository are signed.
+`
+
+const verifyExample = `  helm oss verify my-repo                                      - verifies all charts in repository 'my-repo'
+  helm oss verify --keyring ci.gpg -o json oss://bucket/charts - verifies OSS URI with the CI keyring and prints the report in JSON
+  helm oss verify --sample 20 --allow-unsigned my-repo         - verifies 20 random charts, accepting unsigned ones`
+
+// Statuses of the verified charts, from the best to the worst.
+const (
+	verifyOK           = "ok"
+	verifyUnsigned     = "unsigned"
+	verifyUnverifiable = "unverifiable"
+	verifyCorrupted    = "corrupted"
+)
+
+// verifyStatuses are the statuses ordered from the best to the worst.
+var verifyStatuses = []string{verifyOK, verifyUnsigned, verifyUnverifiable, verifyCorrupted}
+
+func newVerifyCommand() *cobra.Command {
+	act := &verifyAction{
+		printer:       nil,
+		repoOrURI:     "",
+		keyring:       helmutil.DefaultKeyring(),
+		sample:        0,
+		allowUnsigned: false,
+		concurrency:   oss.DefaultConcurrency,
+		output:        "text",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "verify REPO_OR_URI",
+		Short:   "Verify the integrity of the charts in the repository.",
+		Long:    verifyDesc,
+		Example: verifyExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO_OR_URI argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoOrURI = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.keyring, "keyring", act.keyring, "Keyring with the public keys used for verification.")
+	flags.IntVar(&act.sample, "sample", act.sample, "Number of randomly chosen chart versions to verify, all if 0.")
+	flags.BoolVar(&act.allowUnsigned, "allow-unsigned", act.allowUnsigned, "Do not fail if charts are unsigned.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Number of charts verified in parallel.")
+	flags.StringVarP(&act.output, "output", "o", act.output, "Report format, one of: text, json.")
+
+	return cmd
+}
+
+type verifyAction struct {
+	printer printer
+
+	// args
+
+	repoOrURI string
+
+	// flags
+
+	keyring       string
+	sample        int
+	allowUnsigned bool
+	concurrency   int
+	output        string
+}
+
+// verifyReport is the result of the repository verification.
+type verifyReport struct {
+	Repository string `json:"repository"`
+
+	// Entries is the number of chart versions in the index.
+	Entries int `json:"entries"`
+
+	// Summary is the number of verified chart versions by status.
+	Summary map[string]int `json:"summary"`
+
+	Results []verifyResult `json:"results"`
+}
+
+// verifyResult is the verification result of a chart version.
+type verifyResult struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	File    string `json:"file"`
+	Status  string `json:"status"`
+
+	// Digest is the SHA-256 digest of the downloaded chart.
+	Digest string `json:"digest,omitempty"`
+
+	// SignedBy are the identities of the key the chart is signed with.
+	SignedBy []string `json:"signedBy,omitempty"`
+
+	// Problems describe why the status is not ok.
+	Problems []string `json:"problems,omitempty"`
+
+	// Notes describe the checks which have been skipped, e.g. because the
+	// backend does not store the object metadata.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// fail records the problem and sets the status, unless the current one is
+// worse.
+func (r *verifyResult) fail(status, problem string) {
+	if slices.Index(verifyStatuses, status) > slices.Index(verifyStatuses, r.Status) {
+		r.Status = status
+	}
+	r.Problems = append(r.Problems, problem)
+}
+
+func (act *verifyAction) run(ctx context.Context) error {
+	if act.output != "text" && act.output != "json" {
+		return newBadUsageError(fmt.Errorf("unsupported output format %q, must be one of: text, json", act.output))
+	}
+	if act.sample < 0 {
+		return newBadUsageError(errors.New("--sample must not be negative"))
+	}
+	if act.concurrency < 1 {
+		return newBadUsageError(errors.New("--concurrency must be at least 1"))
+	}
+
+	repo, err := helmutil.NewRepository(act.repoOrURI)
+	if err != nil {
+		return err
+	}
+
+	storage, err := newBackend(ctx, repo.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, storage, repo)
+	if err != nil {
+		return err
+	}
+
+	entries := idx.Entries()
+	report := &verifyReport{
+		Repository: repo.URL(),
+		Entries:    len(entries),
+		Summary:    make(map[string]int),
+	}
+
+	if act.sample > 0 && act.sample < len(entries) {
+		// Keep the index order of the sampled entries.
+		picked := rand.Perm(len(entries))[:act.sample]
+		slices.Sort(picked)
+		sampled := make([]helmutil.IndexEntry, len(picked))
+		for i, j := range picked {
+			sampled[i] = entries[j]
+		}
+		entries = sampled
+	}
+
+	tmpDir, err := os.MkdirTemp("", "helm-oss-verify-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	report.Results = make([]verifyResult, len(entries))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(act.concurrency)
+	for i, entry := range entries {
+		g.Go(func() error {
+			dir := filepath.Join(tmpDir, fmt.Sprint(i))
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				return errors.Wrap(err, "create temporary directory")
+			}
+			defer os.RemoveAll(dir)
+
+			result, err := act.verifyChart(gctx, storage, repo, entry, dir)
+			if err != nil {
+				return errors.WithMessagef(err, "verify chart %s %s", entry.Name, entry.Version)
+			}
+			report.Results[i] = result
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	for _, result := range report.Results {
+		report.Summary[result.Status]++
+	}
+
+	if err := act.printReport(report); err != nil {
+		return err
+	}
+
+	for _, result := range report.Results {
+		if result.Status == verifyUnsigned && act.allowUnsigned {
+			continue
+		}
+		if result.Status != verifyOK {
+			return newSilentError()
+		}
+	}
+	return nil
+}
+
+// verifyChart downloads the chart version to dir and verifies it. Failed
+// checks are reported in the result; an error is only returned if the checks
+// cannot be performed, e.g. on network errors.
+func (act *verifyAction) verifyChart(
+	ctx context.Context,
+	storage oss.Backend,
+	repo helmutil.Repository,
+	entry helmutil.IndexEntry,
+	dir string,
+) (verifyResult, error) {
+	uri := helmutil.JoinURL(repo.URL(), chartFileName(repo, entry.URL))
+	filename := path.Base(uri)
+	result := verifyResult{Name: entry.Name, Version: entry.Version, File: filename, Status: verifyOK}
+
+	chartPath := filepath.Join(dir, filename)
+	err := download(ctx, storage, uri, chartPath)
+	if errors.Is(err, oss.ErrObjectNotFound) {
+		result.fail(verifyCorrupted, "the chart object does not exist")
+		return result, nil
+	}
+	if err != nil {
+		return result, errors.WithMessage(err, "download chart")
+	}
+
+	digest, err := helmutil.DigestFile(chartPath)
+	if err != nil {
+		return result, errors.Wrap(err, "compute chart digest")
+	}
+	result.Digest = digest
+
+	switch expected := strings.TrimPrefix(strings.ToLower(entry.Digest), "sha256:"); expected {
+	case "":
+		result.fail(verifyUnverifiable, "the index entry has no digest")
+	case digest:
+	default:
+		result.fail(verifyCorrupted, fmt.Sprintf("the index digest %s differs from the chart digest %s", expected, digest))
+	}
+
+	// Only the object metadata is requested, LoadChart would download the
+	// chart again if the metadata is missing.
+	info, err := storage.StatObject(ctx, uri)
+	if err != nil {
+		return result, errors.WithMessage(err, "stat chart object")
+	}
+	switch metaDigest := info.ChartDigest(); metaDigest {
+	case "":
+		// The file backend does not store the metadata, and it is dropped
+		// if too large, so its absence does not mean the chart is changed.
+		result.Notes = append(result.Notes, "the chart object has no chart-digest metadata, it is not checked")
+	case digest:
+	default:
+		result.fail(verifyCorrupted, fmt.Sprintf("the chart-digest object metadata %s differs from the chart digest %s", metaDigest, digest))
+	}
+
+	provPath := chartPath + ".prov"
+	err = download(ctx, storage, uri+".prov", provPath)
+	if errors.Is(err, oss.ErrObjectNotFound) {
+		result.fail(verifyUnsigned, "the chart has no provenance file")
+		return result, nil
+	}
+	if err != nil {
+		return result, errors.WithMessage(err, "download provenance file")
+	}
+
+	v, err := helmutil.VerifyChart(chartPath, provPath, act.keyring)
+	if errors.Is(err, helmutil.ErrProvenanceMismatch) {
+		result.fail(verifyCorrupted, fmt.Sprintf("the chart differs from the signed one: %s", err))
+		return result, nil
+	}
+	if err != nil {
+		result.fail(verifyUnverifiable, fmt.Sprintf("the provenance file cannot be verified: %s", err))
+		return result, nil
+	}
+	result.SignedBy = v.SignedBy
+	return result, nil
+}
+
+func (act *verifyAction) printReport(report *verifyReport) error {
+	if act.output == "json" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal report")
+		}
+		act.printer.Printf("%s\n", b)
+		return nil
+	}
+
+	act.printer.Printf(
+		"Verified %d of %d chart versions in %s.\n",
+		len(report.Results), report.Entries, report.Repository,
+	)
+	for _, result := range report.Results {
+		if result.Status == verifyOK {
+			continue
+		}
+		act.printer.Printf("  %-13s %s: %s\n", result.Status, result.File, strings.Join(result.Problems, "; "))
+	}
+	for _, result := range report.Results {
+		if len(result.Notes) > 0 {
+			act.printer.Printf("  %-13s %s: %s\n", "note", result.File, strings.Join(result.Notes, "; "))
+		}
+	}
+
+	counts := make([]string, len(verifyStatuses))
+	for i, status := range verifyStatuses {
+		counts[i] = fmt.Sprintf("%d %s", report.Summary[status], status)
+	}
+	act.printer.Printf("%s.\n", strings.Join(counts, ", "))
+	return nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/oss"
+)
+
+func TestVerifyAction(t *testing.T) {
+	ctx := context.Background()
+	b := setupRepo(t)
+
+	pushTestCharts(t)
+
+	verify := func(t *testing.T, act verifyAction) (*verifyReport, error) {
+		t.Helper()
+
+		p := &testPrinter{}
+		act.printer = p
+		act.repoOrURI = testRepoURI
+		act.concurrency = 2
+		act.output = "json"
+		if act.keyring == "" {
+			act.keyring = testKeyring
+		}
+		err := act.run(ctx)
+
+		var report verifyReport
+		require.NoError(t, json.Unmarshal(p.out.Bytes(), &report))
+		return &report, err
+	}
+
+	t.Run("should verify signed and report unsigned charts", func(t *testing.T) {
+		report, err := verify(t, verifyAction{})
+		assert.True(t, errorTypeSilent.Is(err))
+		assert.Equal(t, 2, report.Entries)
+		assert.Equal(t, map[string]int{verifyOK: 1, verifyUnsigned: 1}, report.Summary)
+
+		require.Len(t, report.Results, 2)
+		assert.Equal(t, "1.3.1", report.Results[0].Version)
+		assert.Equal(t, verifyOK, report.Results[0].Status)
+		assert.Equal(t, []string{"Test Key (helm-s3) <test@example.org>"}, report.Results[0].SignedBy)
+		assert.Equal(t, "1.2.3", report.Results[1].Version)
+		assert.Equal(t, verifyUnsigned, report.Results[1].Status)
+
+		_, err = verify(t, verifyAction{allowUnsigned: true})
+		assert.NoError(t, err)
+	})
+
+	t.Run("should report unverifiable signature", func(t *testing.T) {
+		report, err := verify(t, verifyAction{keyring: "missing.gpg", allowUnsigned: true})
+		assert.True(t, errorTypeSilent.Is(err))
+		assert.Equal(t, verifyUnverifiable, report.Results[0].Status)
+		assert.Contains(t, report.Results[0].Problems[0], "load keyring")
+	})
+
+	t.Run("should sample charts", func(t *testing.T) {
+		report, err := verify(t, verifyAction{sample: 1, allowUnsigned: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Entries)
+		assert.Len(t, report.Results, 1)
+	})
+
+	t.Run("should note missing chart-digest metadata", func(t *testing.T) {
+		// The chart is replaced with itself, dropping the object metadata.
+		data, err := os.ReadFile(testChartPath)
+		require.NoError(t, err)
+		require.NoError(t, b.PutObject(ctx, testRepoURI+"/foo-1.2.3.tgz", bytes.NewReader(data)))
+
+		report, err := verify(t, verifyAction{allowUnsigned: true})
+		assert.NoError(t, err)
+		result := report.Results[1]
+		assert.Equal(t, verifyUnsigned, result.Status)
+		assert.Equal(t, []string{"the chart has no provenance file"}, result.Problems)
+		assert.Equal(t, []string{"the chart object has no chart-digest metadata, it is not checked"}, result.Notes)
+	})
+
+	t.Run("should report chart differing from provenance file", func(t *testing.T) {
+		// The provenance file is validly signed, but for another chart.
+		other := filepath.Join(t.TempDir(), "foo-1.3.1.tgz")
+		data, err := os.ReadFile(testChartPath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(other, data, 0o600))
+		prov, err := helmutil.SignChart(other, "../../testdata/pgp/test-private.gpg", "Test Key", nil)
+		require.NoError(t, err)
+		uri := testRepoURI + "/foo-1.3.1.tgz.prov"
+		original, _, err := b.FetchRaw(ctx, uri)
+		require.NoError(t, err)
+		require.NoError(t, b.PutObject(ctx, uri, strings.NewReader(prov)))
+		defer func() {
+			require.NoError(t, b.PutObject(ctx, uri, bytes.NewReader(original)))
+		}()
+
+		report, err := verify(t, verifyAction{allowUnsigned: true})
+		assert.True(t, errorTypeSilent.Is(err))
+		result := report.Results[0]
+		assert.Equal(t, verifyCorrupted, result.Status)
+		require.Len(t, result.Problems, 1)
+		assert.Contains(t, result.Problems[0], "the chart differs from the signed one: chart does not match its provenance file: sha256 sum does not match")
+	})
+
+	t.Run("should report corrupted chart", func(t *testing.T) {
+		// The chart is replaced, keeping the object metadata.
+		uri := testRepoURI + "/foo-1.3.1.tgz"
+		info, err := b.LoadChart(ctx, uri)
+		require.NoError(t, err)
+		meta, err := info.Meta.MarshalJSON()
+		require.NoError(t, err)
+		tampered, err := os.Open(testChartPath)
+		require.NoError(t, err)
+		defer tampered.Close()
+		_, err = b.PutChart(ctx, uri, tampered, string(meta), info.Hash, "application/gzip", false, nil)
+		require.NoError(t, err)
+
+		report, err := verify(t, verifyAction{allowUnsigned: true})
+		assert.True(t, errorTypeSilent.Is(err))
+		result := report.Results[0]
+		assert.Equal(t, verifyCorrupted, result.Status)
+		require.Len(t, result.Problems, 3)
+		assert.Contains(t, result.Problems[0], "the index digest")
+		assert.Contains(t, result.Problems[1], "the chart-digest object metadata")
+		assert.Contains(t, result.Problems[2], "the chart differs from the signed one")
+	})
+
+	t.Run("should report missing chart", func(t *testing.T) {
+		require.NoError(t, b.DeleteChart(ctx, testRepoURI+"/foo-1.2.3.tgz"))
+
+		report, err := verify(t, verifyAction{allowUnsigned: true})
+		assert.True(t, errorTypeSilent.Is(err))
+		assert.Equal(t, verifyCorrupted, report.Results[1].Status)
+		assert.Equal(t, []string{"the chart object does not exist"}, report.Results[1].Problems)
+	})
+
+	t.Run("should print text report", func(t *testing.T) {
+		p := &testPrinter{}
+		act := &verifyAction{printer: p, repoOrURI: testRepoURI, keyring: testKeyring, concurrency: 1, output: "text"}
+		assert.True(t, errorTypeSilent.Is(act.run(ctx)))
+		assert.Contains(t, p.out.String(), "Verified 2 of 2 chart versions in oss://test-bucket/charts.")
+		assert.Contains(t, p.out.String(), "  corrupted     foo-1.2.3.tgz: the chart object does not exist\n")
+		assert.Contains(t, p.out.String(), "0 ok, 0 unsigned, 0 unverifiable, 2 corrupted.")
+	})
+}
+
+func TestVerifyAction_FileBackend(t *testing.T) {
+	ctx := context.Background()
+	b := oss.NewFileBackend()
+	mockBackend(t, b)
+
+	// The file backend does not store the object metadata.
+	repoURI := "file://" + filepath.ToSlash(t.TempDir())
+	r, err := helmutil.NewIndex().Reader()
+	require.NoError(t, err)
+	require.NoError(t, b.PutIndex(ctx, repoURI, "", r))
+	push := &pushAction{printer: &testPrinter{}, chartPath: testSignedChartPath, repoOrURI: repoURI}
+	require.NoError(t, push.run(ctx))
+
+	p := &testPrinter{}
+	act := &verifyAction{printer: p, repoOrURI: repoURI, keyring: testKeyring, concurrency: 1, output: "text"}
+	require.NoError(t, act.run(ctx))
+	assert.Contains(t, p.out.String(), "  note          foo-1.3.1.tgz: the chart object has no chart-digest metadata, it is not checked\n")
+	assert.Contains(t, p.out.String(), "1 ok, 0 unsigned, 0 unverifiable, 0 corrupted.")
+}
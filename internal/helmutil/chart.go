@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"slices"
+	"strings"
 
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
 )
 
 // Chart describes a helm chart.
@@ -22,8 +25,31 @@ type Chart interface {
 
 	// Metadata returns chart metadata.
 	Metadata() ChartMetadata
+
+	// Values returns the content of values.yaml, or nil if there is none.
+	Values() []byte
+
+	// Readme returns the content of the README file, or nil if there is none.
+	Readme() []byte
+
+	// Files returns all files in the chart archive, ordered by name.
+	Files() []ChartFile
+}
+
+// ChartFile is a file in the chart archive.
+type ChartFile struct {
+	// Name is the path relative to the chart directory.
+	// Example: "templates/deployment.yaml".
+	Name string `json:"name"`
+
+	// Size is the file size in bytes.
+	Size int `json:"size"`
 }
 
+// readmeFileNames are the names of the chart README file, as Helm looks them
+// up case-insensitively.
+var readmeFileNames = []string{"readme.md", "readme.txt", "readme"}
+
 // ChartV3 implements Chart in Helm v3.
 type ChartV3 struct {
 	chart *chart.Chart
@@ -47,6 +73,38 @@ func (c ChartV3) Metadata() ChartMetadata {
 	return &chartMetadataV3{meta: c.chart.Metadata}
 }
 
+// Values returns the content of values.yaml.
+func (c ChartV3) Values() []byte {
+	for _, f := range c.chart.Raw {
+		if f.Name == "values.yaml" {
+			return f.Data
+		}
+	}
+	return nil
+}
+
+// Readme returns the content of the README file.
+func (c ChartV3) Readme() []byte {
+	for _, f := range c.chart.Files {
+		if slices.Contains(readmeFileNames, strings.ToLower(f.Name)) {
+			return f.Data
+		}
+	}
+	return nil
+}
+
+// Files returns all files in the chart archive.
+func (c ChartV3) Files() []ChartFile {
+	files := make([]ChartFile, 0, len(c.chart.Raw))
+	for _, f := range c.chart.Raw {
+		files = append(files, ChartFile{Name: f.Name, Size: len(f.Data)})
+	}
+	slices.SortFunc(files, func(a, b ChartFile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return files
+}
+
 // LoadChart returns chart loaded from the file system by path.
 func LoadChart(fpath string) (Chart, error) {
 	ch, err := loader.LoadFile(fpath)
@@ -65,6 +123,46 @@ func LoadArchive(r io.Reader) (Chart, error) {
 	return ChartV3{chart: ch}, nil
 }
 
+// PackageChart packages the chart directory into an archive in the dest
+// directory, like 'helm package', and returns the path to the archive. The
+// dependencies of the chart must be present in its charts/ directory.
+func PackageChart(dir, dest string) (string, error) {
+	ch, err := loader.LoadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to load chart directory: %s", err.Error())
+	}
+
+	var missing []string
+	for _, dep := range ch.Metadata.Dependencies {
+		if !slices.ContainsFunc(ch.Dependencies(), func(c *chart.Chart) bool {
+			return c.Name() == dep.Name
+		}) {
+			missing = append(missing, dep.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf(
+			"chart %s is missing dependencies %s, run 'helm dependency build' first",
+			ch.Name(), strings.Join(missing, ", "),
+		)
+	}
+
+	fpath, err := chartutil.Save(ch, dest)
+	if err != nil {
+		return "", fmt.Errorf("failed to save chart archive: %s", err.Error())
+	}
+	return fpath, nil
+}
+
+// ExpandChart extracts the chart archive into the directory. The chart is
+// extracted into the subdirectory named after the chart.
+func ExpandChart(dir, chartPath string) error {
+	if err := chartutil.ExpandFile(dir, chartPath); err != nil {
+		return fmt.Errorf("failed to expand chart archive: %s", err.Error())
+	}
+	return nil
+}
+
 // ChartMetadata describes helm chart metadata.
 type ChartMetadata interface {
 	// MarshalJSON marshals chart metadata to JSON.
@@ -75,6 +173,12 @@ type ChartMetadata interface {
 
 	// Value returns underlying chart metadata value.
 	Value() interface{}
+
+	// Name returns chart name.
+	Name() string
+
+	// Version returns chart version.
+	Version() string
 }
 
 type chartMetadataV3 struct {
@@ -99,6 +203,20 @@ func (c *chartMetadataV3) Value() interface{} {
 	return c.meta
 }
 
+func (c *chartMetadataV3) Name() string {
+	if c.meta == nil {
+		return ""
+	}
+	return c.meta.Name
+}
+
+func (c *chartMetadataV3) Version() string {
+	if c.meta == nil {
+		return ""
+	}
+	return c.meta.Version
+}
+
 // NewChartMetadata creates a new ChartMetadata instance.
 func NewChartMetadata() ChartMetadata {
 	return &chartMetadataV3{meta: &chart.Metadata{}}
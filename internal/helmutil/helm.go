@@ -34,7 +34,31 @@ func SetupHelm() {
 
 // IndexFileURL returns index file URL for the provided repository URL.
 func IndexFileURL(repoURL string) string {
-	return strings.TrimSuffix(repoURL, "/") + "/index.yaml"
+	return JoinURL(repoURL, "index.yaml")
+}
+
+// JoinURL appends the path elements to the URL. The query of the URL, if
+// any, is kept at the end, as Helm does for the chart and index URLs.
+// Example: JoinURL("oss://bucket/charts?region=cn-beijing", "index.yaml")
+// returns "oss://bucket/charts/index.yaml?region=cn-beijing".
+func JoinURL(base string, elem ...string) string {
+	base, query, hasQuery := strings.Cut(base, "?")
+
+	u := strings.TrimSuffix(base, "/")
+	for _, e := range elem {
+		u += "/" + e
+	}
+
+	if hasQuery {
+		u += "?" + query
+	}
+	return u
+}
+
+// TrimURLQuery returns the URL without the query.
+func TrimURLQuery(u string) string {
+	u, _, _ = strings.Cut(u, "?")
+	return u
 }
 
 func repoCacheFileName(name string) string {
@@ -0,0 +1,33 @@
+package helmutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		base string
+		elem []string
+		want string
+	}{
+		{base: "oss://bucket/charts", elem: []string{"index.yaml"}, want: "oss://bucket/charts/index.yaml"},
+		{base: "oss://bucket/charts/", elem: []string{"index.yaml"}, want: "oss://bucket/charts/index.yaml"},
+		{
+			base: "oss://bucket/charts?region=cn-beijing",
+			elem: []string{".helm-oss.journal", "1.json"},
+			want: "oss://bucket/charts/.helm-oss.journal/1.json?region=cn-beijing",
+		},
+		{
+			base: "oss://bucket/charts/?region=cn-beijing&endpoint=oss-cn-beijing.aliyuncs.com",
+			elem: []string{"foo-1.2.3.tgz"},
+			want: "oss://bucket/charts/foo-1.2.3.tgz?region=cn-beijing&endpoint=oss-cn-beijing.aliyuncs.com",
+		},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, JoinURL(tc.base, tc.elem...))
+	}
+
+	assert.Equal(t, "oss://bucket/charts", TrimURLQuery("oss://bucket/charts?region=cn-beijing"))
+}
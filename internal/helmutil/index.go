@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
@@ -48,6 +50,19 @@ func (idx *Index) Add(metadata interface{}, filename, baseURL, digest string) er
 	return nil
 }
 
+// AddWithCreated adds a chart to the index, same as Add, and sets its
+// creation time to created.
+func (idx *Index) AddWithCreated(metadata interface{}, filename, baseURL, digest string, created time.Time) error {
+	if err := idx.Add(metadata, filename, baseURL, digest); err != nil {
+		return err
+	}
+
+	md := metadata.(*chart.Metadata)
+	versions := idx.index.Entries[md.Name]
+	versions[len(versions)-1].Created = created
+	return nil
+}
+
 // AddOrReplace adds a chart to the index or replaces it if it already exists.
 func (idx *Index) AddOrReplace(metadata interface{}, filename, baseURL, digest string) error {
 	// TODO: this looks like a workaround.
@@ -138,6 +153,108 @@ func (idx *Index) Has(name, version string) bool {
 	return idx.index.Has(name, version)
 }
 
+// IndexEntry is a brief description of a chart version in the index.
+type IndexEntry struct {
+	Name       string
+	Version    string
+	AppVersion string
+	URL        string
+	Digest     string
+	Created    time.Time
+}
+
+// Entries returns all chart versions in the index, ordered by name.
+// Versions of one chart keep their order in the index.
+func (idx *Index) Entries() []IndexEntry {
+	names := make([]string, 0, len(idx.index.Entries))
+	for name := range idx.index.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entries []IndexEntry
+	for _, name := range names {
+		for _, cv := range idx.index.Entries[name] {
+			entry := IndexEntry{
+				Name:       name,
+				Version:    cv.Version,
+				AppVersion: cv.AppVersion,
+				Digest:     cv.Digest,
+				Created:    cv.Created,
+			}
+			if len(cv.URLs) > 0 {
+				entry.URL = cv.URLs[0]
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// CopyFrom adds the chart version from the src index as is, keeping all its
+// fields. Returns false if there is no such chart version in src.
+func (idx *Index) CopyFrom(src *Index, name, version string) bool {
+	cv, err := src.index.Get(name, version)
+	if err != nil {
+		return false
+	}
+
+	cp := *cv
+	idx.index.Entries[name] = append(idx.index.Entries[name], &cp)
+	return true
+}
+
+// MergeFrom keeps the fields of the chart version that are not taken from
+// the chart itself, as they are in the src index: creation time, removed
+// flag, additional URLs and annotations added by hand. Annotations of the
+// chart take precedence. Returns false if either index has no such chart
+// version.
+func (idx *Index) MergeFrom(src *Index, name, version string) bool {
+	prev, err := src.index.Get(name, version)
+	if err != nil {
+		return false
+	}
+	cv, err := idx.index.Get(name, version)
+	if err != nil {
+		return false
+	}
+
+	cv.Created = prev.Created
+	cv.Removed = prev.Removed
+
+	if len(prev.URLs) > 1 {
+		for _, u := range prev.URLs[1:] {
+			if !slices.Contains(cv.URLs, u) {
+				cv.URLs = append(cv.URLs, u)
+			}
+		}
+	}
+
+	for k, v := range prev.Annotations {
+		if _, ok := cv.Annotations[k]; ok {
+			continue
+		}
+		if cv.Annotations == nil {
+			cv.Annotations = make(map[string]string)
+		}
+		cv.Annotations[k] = v
+	}
+
+	return true
+}
+
+// SetDigest sets the digest of the chart version, keeping the rest of the
+// entry intact. Returns false if there is no such chart version.
+func (idx *Index) SetDigest(name, version, digest string) bool {
+	cv, err := idx.index.Get(name, version)
+	if err != nil {
+		return false
+	}
+	cv.Digest = digest
+	return true
+}
+
 // SortEntries sorts the chart entries in the index.
 func (idx *Index) SortEntries() {
 	idx.index.SortEntries()
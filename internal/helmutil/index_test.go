@@ -135,3 +135,63 @@ func TestIndex_UpdateGeneratedTime(t *testing.T) {
 	generatedNew := idx.index.Generated
 	assert.True(t, generatedNew.After(generatedOld), "Expected %s greater than %s", generatedNew.String(), generatedOld.String())
 }
+
+func TestIndex_Entries(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	i := NewIndex()
+	require.NoError(t, i.AddWithCreated(
+		&chart.Metadata{Name: "foo", Version: "0.1.0", AppVersion: "1.0"}, "foo-0.1.0.tgz", "", "sha256:foo", created,
+	))
+	require.NoError(t, i.AddWithCreated(
+		&chart.Metadata{Name: "bar", Version: "1.0.0"}, "bar-1.0.0.tgz", "", "sha256:bar", created,
+	))
+
+	assert.Equal(t, []IndexEntry{
+		{Name: "bar", Version: "1.0.0", URL: "bar-1.0.0.tgz", Digest: "sha256:bar", Created: created},
+		{Name: "foo", Version: "0.1.0", AppVersion: "1.0", URL: "foo-0.1.0.tgz", Digest: "sha256:foo", Created: created},
+	}, i.Entries())
+
+	t.Run("should set digest", func(t *testing.T) {
+		assert.True(t, i.SetDigest("foo", "0.1.0", "sha256:new"))
+		assert.Equal(t, "sha256:new", i.index.Entries["foo"][0].Digest)
+		assert.False(t, i.SetDigest("foo", "9.9.9", "sha256:new"))
+	})
+}
+
+func TestIndex_CopyFrom(t *testing.T) {
+	src := NewIndex()
+	require.NoError(t, src.Add(&chart.Metadata{Name: "foo", Version: "0.1.0"}, "foo-0.1.0.tgz", "", "sha256:foo"))
+	src.index.Entries["foo"][0].Created = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	dst := NewIndex()
+	assert.True(t, dst.CopyFrom(src, "foo", "0.1.0"))
+	assert.Equal(t, src.index.Entries["foo"][0], dst.index.Entries["foo"][0])
+	assert.False(t, dst.CopyFrom(src, "foo", "9.9.9"))
+}
+
+func TestIndex_MergeFrom(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	prev := NewIndex()
+	require.NoError(t, prev.AddWithCreated(&chart.Metadata{Name: "foo", Version: "0.1.0"}, "foo-0.1.0.tgz", "", "sha256:old", created))
+	cv := prev.index.Entries["foo"][0]
+	cv.Removed = true
+	cv.URLs = append(cv.URLs, "https://mirror.example.com/foo-0.1.0.tgz")
+	cv.Annotations = map[string]string{"owner": "team-a", "category": "old"}
+
+	idx := NewIndex()
+	require.NoError(t, idx.AddWithCreated(
+		&chart.Metadata{Name: "foo", Version: "0.1.0", Annotations: map[string]string{"category": "new"}},
+		"foo-0.1.0.tgz", "", "sha256:new", time.Now(),
+	))
+	assert.True(t, idx.MergeFrom(prev, "foo", "0.1.0"))
+
+	merged := idx.index.Entries["foo"][0]
+	assert.Equal(t, created, merged.Created)
+	assert.True(t, merged.Removed)
+	assert.Equal(t, []string{"foo-0.1.0.tgz", "https://mirror.example.com/foo-0.1.0.tgz"}, merged.URLs)
+	assert.Equal(t, map[string]string{"owner": "team-a", "category": "new"}, merged.Annotations)
+	assert.Equal(t, "sha256:new", merged.Digest)
+
+	assert.False(t, idx.MergeFrom(prev, "foo", "9.9.9"))
+}
@@ -1,9 +1,18 @@
 package helmutil
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
 
+	"golang.org/x/crypto/openpgp/clearsign" //nolint:staticcheck // The format of the Helm provenance files.
 	"helm.sh/helm/v3/pkg/provenance"
+	"sigs.k8s.io/yaml"
 )
 
 // Digest hashes a reader and returns a SHA256 digest.
@@ -15,3 +24,122 @@ func Digest(in io.Reader) (string, error) {
 func DigestFile(filename string) (string, error) {
 	return provenance.DigestFile(filename)
 }
+
+// DefaultKeyring returns the path to the public keyring used by Helm by
+// default: pubring.gpg in $GNUPGHOME or in ~/.gnupg.
+func DefaultKeyring() string {
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		return filepath.Join(home, "pubring.gpg")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".gnupg", "pubring.gpg")
+	}
+	return filepath.Join(home, ".gnupg", "pubring.gpg")
+}
+
+// DefaultSecretKeyring returns the path to the secret keyring used by Helm
+// to sign charts by default: secring.gpg in $GNUPGHOME or in ~/.gnupg.
+func DefaultSecretKeyring() string {
+	return filepath.Join(filepath.Dir(DefaultKeyring()), "secring.gpg")
+}
+
+// PassphraseFetcher returns the passphrase of the named signing key.
+type PassphraseFetcher func(name string) ([]byte, error)
+
+// SignChart signs the chart file with the key from the secret keyring and
+// returns the content of the provenance file. The key is the name, or a part
+// of it, of the key identity. The passphrase is only fetched if the key is
+// encrypted.
+func SignChart(chartPath, keyring, key string, passphrase PassphraseFetcher) (string, error) {
+	signer, err := provenance.NewFromKeyring(keyring, key)
+	if err != nil {
+		return "", fmt.Errorf("load keyring %s: %w", keyring, err)
+	}
+	if signer.Entity == nil {
+		return "", fmt.Errorf("key %q not found in keyring %s", key, keyring)
+	}
+
+	if err := signer.DecryptKey(provenance.PassphraseFetcher(passphrase)); err != nil {
+		return "", fmt.Errorf("decrypt key: %w", err)
+	}
+
+	sig, err := signer.ClearSign(chartPath)
+	if err != nil {
+		return "", fmt.Errorf("sign chart: %w", err)
+	}
+	return sig, nil
+}
+
+// Verification is the result of the chart provenance verification.
+type Verification struct {
+	// SignedBy are the identities of the signer key.
+	// Example: "Jane Doe <jane@example.com>".
+	SignedBy []string
+
+	// Fingerprint is the fingerprint of the signer key.
+	Fingerprint string
+
+	// FileHash is the verified chart hash.
+	// Example: "sha256:be99ea...".
+	FileHash string
+}
+
+// ProvenanceFileHash returns the hash of the chart file with the name signed
+// in the provenance file, without verifying the signature.
+// Example: "sha256:be99ea...".
+func ProvenanceFileHash(prov []byte, filename string) (string, error) {
+	block, _ := clearsign.Decode(prov)
+	if block == nil {
+		return "", errors.New("signature block not found")
+	}
+
+	// The signed message is the chart metadata followed by the hashes,
+	// see provenance.Signatory.ClearSign.
+	parts := bytes.Split(block.Plaintext, []byte("\n...\n"))
+	if len(parts) < 2 {
+		return "", errors.New("message block must have at least two parts")
+	}
+	var sums provenance.SumCollection
+	if err := yaml.Unmarshal(parts[1], &sums); err != nil {
+		return "", fmt.Errorf("unmarshal hashes: %w", err)
+	}
+
+	hash, ok := sums.Files[filename]
+	if !ok {
+		return "", fmt.Errorf("provenance file has no hash of %q", filename)
+	}
+	return hash, nil
+}
+
+// ErrProvenanceMismatch is returned by VerifyChart when the provenance file
+// is validly signed, but the chart differs from the signed one.
+var ErrProvenanceMismatch = errors.New("chart does not match its provenance file")
+
+// VerifyChart verifies the chart file against its provenance file with the
+// public keys from the keyring. It fails if the signature is not valid or
+// the chart hash differs from the signed one, in which case the error
+// matches ErrProvenanceMismatch.
+func VerifyChart(chartPath, provPath, keyring string) (*Verification, error) {
+	sig, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return nil, fmt.Errorf("load keyring %s: %w", keyring, err)
+	}
+
+	v, err := sig.Verify(chartPath, provPath)
+	if err != nil {
+		// The signer is only set once the signature has been verified, so
+		// the error is about the signed chart hash.
+		if v != nil && v.SignedBy != nil {
+			return nil, fmt.Errorf("%w: %w", ErrProvenanceMismatch, err)
+		}
+		return nil, err
+	}
+
+	result := &Verification{FileHash: v.FileHash}
+	if v.SignedBy != nil {
+		result.SignedBy = slices.Sorted(maps.Keys(v.SignedBy.Identities))
+		result.Fingerprint = fmt.Sprintf("%X", v.SignedBy.PrimaryKey.Fingerprint)
+	}
+	return result, nil
+}
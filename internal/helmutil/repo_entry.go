@@ -32,6 +32,21 @@ type RepoEntry interface {
 	// - /Users/foo/Library/Caches/helm/repository/my-charts-index.yaml (on macOS)
 	// - /home/foo/.cache/helm/repository/my-charts-index.yaml (on Linux)
 	CacheFile() string
+
+	// Credentials returns the credentials and TLS files of the repository,
+	// as set with helm repo add.
+	Credentials() RepoCredentials
+}
+
+// RepoCredentials are the credentials and TLS files of a repository.
+type RepoCredentials struct {
+	Username string
+	Password string
+
+	CertFile              string
+	KeyFile               string
+	CAFile                string
+	InsecureSkipTLSVerify bool
 }
 
 // RepoEntryV3 implements RepoEntry in Helm v3.
@@ -59,6 +74,18 @@ func (r RepoEntryV3) CacheFile() string {
 	return filepath.Join(cacheDirPathV3(), repoCacheFileName(r.entry.Name))
 }
 
+// Credentials returns the credentials and TLS files of the repository.
+func (r RepoEntryV3) Credentials() RepoCredentials {
+	return RepoCredentials{
+		Username:              r.entry.Username,
+		Password:              r.entry.Password,
+		CertFile:              r.entry.CertFile,
+		KeyFile:               r.entry.KeyFile,
+		CAFile:                r.entry.CAFile,
+		InsecureSkipTLSVerify: r.entry.InsecureSkipTLSverify,
+	}
+}
+
 // LookupRepoEntry returns an entry from helm's repositories.yaml file by name.
 // If repositories.yaml file is not found, errors.Is(err, fs.ErrNotExist) will
 // return true.
@@ -77,7 +104,10 @@ func LookupRepoEntry(name string) (RepoEntry, error) {
 }
 
 // LookupRepoEntryByURL returns an entry from helm's repositories.yaml file by
-// repo URL. If not found, returns false and <nil> error.
+// repo URL. The URL may also point inside the repository, e.g. to a chart or
+// to the index file. If several repositories contain it, the most specific
+// one is returned. URL queries are ignored. If not found, returns false and
+// <nil> error.
 // If repositories.yaml file is not found, errors.Is(err, fs.ErrNotExist) will
 // return true.
 func LookupRepoEntryByURL(url string) (RepoEntry, bool, error) {
@@ -86,13 +116,24 @@ func LookupRepoEntryByURL(url string) (RepoEntry, bool, error) {
 		return RepoEntryV3{}, false, fmt.Errorf("load repo file: %w", err)
 	}
 
-	url = strings.TrimSuffix(url, "/")
+	url = strings.TrimSuffix(TrimURLQuery(url), "/")
+
+	var (
+		found    *repo.Entry
+		foundURL string
+	)
 	for _, entry := range repoFile.Repositories {
-		entryURL := strings.TrimSuffix(entry.URL, "/")
-		if url == entryURL {
-			return RepoEntryV3{entry: entry}, true, nil
+		entryURL := strings.TrimSuffix(TrimURLQuery(entry.URL), "/")
+		if url != entryURL && !strings.HasPrefix(url, entryURL+"/") {
+			continue
 		}
+		if found == nil || len(entryURL) > len(foundURL) {
+			found, foundURL = entry, entryURL
+		}
+	}
+	if found == nil {
+		return RepoEntryV3{}, false, nil
 	}
 
-	return RepoEntryV3{}, false, nil
+	return RepoEntryV3{entry: found}, true, nil
 }
@@ -214,3 +214,48 @@ func TestLookup(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupByURL(t *testing.T) {
+	oldLoadRepoFile := helm3LoadRepoFile
+	helm3LoadRepoFile = func(path string) (*repo.File, error) {
+		return &repo.File{
+			Repositories: []*repo.Entry{
+				{Name: "stable", URL: "https://kubernetes-charts.storage.googleapis.com"},
+				{Name: "my-charts", URL: "oss://my-charts/charts/", Username: "ak", Password: "sk"},
+				{Name: "archive", URL: "oss://my-charts/charts/archive?region=cn-beijing", CAFile: "ca.pem"},
+			},
+		}, nil
+	}
+	helm3Env = cli.New()
+	t.Cleanup(func() {
+		helm3LoadRepoFile = oldLoadRepoFile
+	})
+
+	testCases := map[string]struct {
+		url      string
+		wantName string
+	}{
+		"repo URL":                {url: "oss://my-charts/charts", wantName: "my-charts"},
+		"index URL":               {url: "oss://my-charts/charts/index.yaml", wantName: "my-charts"},
+		"chart URL":               {url: "oss://my-charts/charts/foo-1.2.3.tgz", wantName: "my-charts"},
+		"most specific repo":      {url: "oss://my-charts/charts/archive/foo-1.2.3.tgz", wantName: "archive"},
+		"query is ignored":        {url: "oss://my-charts/charts/archive/index.yaml?region=cn-beijing", wantName: "archive"},
+		"prefix of the repo name": {url: "oss://my-charts/charts-old/index.yaml", wantName: ""},
+		"unknown repo":            {url: "oss://other/charts", wantName: ""},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			entry, ok, err := LookupRepoEntryByURL(tc.url)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantName != "", ok)
+			if ok {
+				assert.Equal(t, tc.wantName, entry.Name())
+			}
+		})
+	}
+
+	entry, _, err := LookupRepoEntryByURL("oss://my-charts/charts")
+	assert.NoError(t, err)
+	assert.Equal(t, RepoCredentials{Username: "ak", Password: "sk"}, entry.Credentials())
+}
@@ -7,7 +7,7 @@ import (
 // Repository represents a Helm chart repository.
 // It supports two modes:
 // 1. Local repository mode: repositories added via helm repo add
-// 2. Remote repository mode: direct oss:// URI access.
+// 2. Remote repository mode: direct oss:// (or file://) URI access.
 type Repository interface {
 	// URL returns the repository URL.
 	URL() string
@@ -46,7 +46,7 @@ func (r *LocalRepository) ShouldUpdateCache() bool {
 }
 
 // RemoteRepository implements Repository for remote repositories.
-// Uses oss:// URI directly without relying on local configuration.
+// Uses oss:// or file:// URI directly without relying on local configuration.
 type RemoteRepository struct {
 	uri string
 }
@@ -68,10 +68,10 @@ func (r *RemoteRepository) ShouldUpdateCache() bool {
 }
 
 // NewRepository creates an appropriate Repository implementation based on the input.
-// If repoOrURI starts with "oss://", returns RemoteRepository.
+// If repoOrURI starts with "oss://" or "file://", returns RemoteRepository.
 // Otherwise, looks up local repository configuration and returns LocalRepository.
 func NewRepository(repoOrURI string) (Repository, error) {
-	if strings.HasPrefix(repoOrURI, "oss://") {
+	if strings.HasPrefix(repoOrURI, "oss://") || strings.HasPrefix(repoOrURI, "file://") {
 		return &RemoteRepository{uri: repoOrURI}, nil
 	}
 
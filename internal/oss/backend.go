@@ -0,0 +1,279 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" //nolint:gosec // MD5 is only used to compute ETags, as OSS does.
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"helm-oss/internal/helmutil"
+)
+
+// Backend is a chart repository storage.
+//
+// All URIs passed to the Backend methods include the scheme, e.g.
+// oss://bucket-name/key[...] for Storage or file:///path/to/dir[...] for
+// FileBackend.
+type Backend interface {
+	// Traverse traverses all charts in the repository, loading up to
+	// concurrency charts in parallel. Charts are reported in the order of
+	// their URIs.
+	Traverse(ctx context.Context, repoURI string, concurrency int) (<-chan ChartInfo, <-chan error)
+
+	// FetchRaw downloads the object by uri and returns its content along with
+	// the object ETag. Returns ErrObjectNotFound if the object does not exist.
+	FetchRaw(ctx context.Context, uri string) ([]byte, string, error)
+
+	// Open returns the reader streaming the object by uri, which must be
+	// closed by the caller. Returns ErrObjectNotFound if the object does not
+	// exist.
+	Open(ctx context.Context, uri string) (io.ReadCloser, error)
+
+	// ListObjects returns all objects located directly in the directory by
+	// dirURI, e.g. the repository, ordered by URI. A missing directory is not
+	// an error.
+	ListObjects(ctx context.Context, dirURI string) ([]ObjectInfo, error)
+
+	// LoadChart returns the information about the chart object by uri. It is
+	// taken from the object metadata if present, otherwise the chart archive
+	// is downloaded and loaded.
+	LoadChart(ctx context.Context, uri string) (ChartInfo, error)
+
+	// Exists returns true if an object exists by uri.
+	Exists(ctx context.Context, uri string) (bool, error)
+
+	// StatObject returns the information about the object by uri, including
+	// its metadata, without downloading it. Returns ErrObjectNotFound if the
+	// object does not exist.
+	StatObject(ctx context.Context, uri string) (ObjectInfo, error)
+
+	// PutIndex conditionally puts the index file to the repository by
+	// repository uri. If etag is not empty, the index is only replaced if its
+	// current ETag matches; if etag is empty, the index is only created if it
+	// does not exist yet. ErrIndexConflict is returned when the condition fails.
+	//
+	// Only the creation is atomic on every backend: a replacement may still
+	// overwrite a concurrent one, so the index must be replaced under the
+	// repository lock.
+	PutIndex(ctx context.Context, uri string, etag string, r io.Reader) error
+
+	// PutObject unconditionally puts the object by uri.
+	PutObject(ctx context.Context, uri string, r io.Reader) error
+
+	// PutChart puts the chart file by uri, and the provenance file next to
+	// it if prov is true.
+	PutChart(
+		ctx context.Context,
+		uri string,
+		r io.Reader,
+		chartMeta string,
+		chartDigest string,
+		contentType string,
+		prov bool,
+		provReader io.Reader,
+	) (string, error)
+
+	// DeleteChart deletes the chart object by uri. Also deletes .prov file if exists.
+	DeleteChart(ctx context.Context, uri string) error
+
+	Locker
+	Journaler
+}
+
+var (
+	_ Backend = (*Storage)(nil)
+	_ Backend = (*FileBackend)(nil)
+	_ Backend = (*MemoryBackend)(nil)
+)
+
+// DefaultConcurrency is the default number of chart objects loaded
+// concurrently.
+const DefaultConcurrency = 8
+
+// ObjectInfo describes an object in the storage.
+type ObjectInfo struct {
+	URI  string
+	Size int64
+
+	// ETag is the object ETag. It is empty if the backend cannot provide it
+	// without reading the object.
+	ETag string
+
+	LastModified time.Time
+
+	// Metadata is the user metadata of the object. It is only set by
+	// StatObject, and is nil if the backend does not store metadata.
+	Metadata map[string]string
+}
+
+// ChartMetadata returns the chart metadata JSON recorded in the object
+// metadata at push, or an empty string if there is none.
+func (o ObjectInfo) ChartMetadata() string {
+	return getMetadataValue(o.Metadata, metaChartMetadata)
+}
+
+// ChartDigest returns the chart digest recorded in the object metadata at
+// push, or an empty string if there is none.
+func (o ObjectInfo) ChartDigest() string {
+	return getMetadataValue(o.Metadata, metaChartDigest)
+}
+
+// Locker manages the repository lock.
+type Locker interface {
+	// AcquireLock creates the repository lock. If the lock is held and has
+	// expired, it is broken and acquired again. If the lock is held by
+	// someone else, *LockedError is returned.
+	AcquireLock(ctx context.Context, repoURI string, lock Lock) error
+
+	// ReadLock returns the current holder of the repository lock.
+	// If the repository is not locked, returns false and <nil> error.
+	ReadLock(ctx context.Context, repoURI string) (Lock, bool, error)
+
+	// RenewLock extends the repository lock held with the lock id for ttl
+	// from now. If the lock is not held with the id anymore, ErrLockLost is
+	// returned.
+	RenewLock(ctx context.Context, repoURI string, id string, ttl time.Duration) error
+
+	// ReleaseLock removes the repository lock if it is still held with the
+	// lock id. It does nothing if the lock has been taken over by someone else.
+	ReleaseLock(ctx context.Context, repoURI string, id string) error
+
+	// BreakLock removes the repository lock held with the lock id, even if
+	// it has not expired, along with its lease. It does nothing if the
+	// repository is not locked. If the lock is held with another id,
+	// *LockedError is returned.
+	BreakLock(ctx context.Context, repoURI string, id string) error
+}
+
+// Journaler manages the repository operation journal.
+type Journaler interface {
+	// WriteJournal records the operation before it modifies the repository.
+	WriteJournal(ctx context.Context, repoURI string, journal Journal) error
+
+	// ListJournals returns the journals of all operations that have not been
+	// completed, oldest first.
+	ListJournals(ctx context.Context, repoURI string) ([]Journal, error)
+
+	// CompleteJournal marks the operation with the id as completed by removing
+	// its journal.
+	CompleteJournal(ctx context.Context, repoURI string, id string) error
+}
+
+// NewBackend returns the Backend that serves the repository uri, based on
+// the uri scheme:
+// - oss:// is served by Storage configured with LoadConfig, see New,
+// - file:// is served by FileBackend.
+func NewBackend(uri string, opts ConfigOptions) (Backend, error) {
+	switch {
+	case strings.HasPrefix(uri, "oss://"):
+		conf, err := LoadConfig(uri, opts)
+		if err != nil {
+			return nil, err
+		}
+		s, err := New(conf)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case strings.HasPrefix(uri, "file://"):
+		return NewFileBackend(), nil
+	default:
+		return nil, fmt.Errorf("uri %s: unsupported protocol, must be one of oss://, file://", uri)
+	}
+}
+
+// IndexExists returns true if index file exists in the backend for
+// repository with the provided uri.
+func IndexExists(ctx context.Context, b Backend, uri string) (bool, error) {
+	if strings.HasPrefix(uri, "index.yaml") {
+		return false, errors.New("uri must not contain \"index.yaml\" suffix, it appends automatically")
+	}
+
+	return b.Exists(ctx, helmutil.IndexFileURL(uri))
+}
+
+// LoadCharts loads the information about the chart objects by uris with up
+// to concurrency parallel requests, see Backend.LoadChart. The result is in
+// the order of uris. Loading is stopped on the first error.
+func LoadCharts(ctx context.Context, b Backend, uris []string, concurrency int) ([]ChartInfo, error) {
+	items := make([]ChartInfo, len(uris))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(concurrency, 1))
+	for i, uri := range uris {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			item, err := b.LoadChart(ctx, uri)
+			if err != nil {
+				return err
+			}
+			items[i] = item
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// chartInfoFromMetadata builds ChartInfo from the chart object metadata.
+// If the metadata does not contain chart information, returns false.
+func chartInfoFromMetadata(filename string, meta map[string]string) (ChartInfo, bool, error) {
+	// Try to get metadata with case-insensitivity handling
+	// OSS might capitalize keys.
+	serializedChartMeta := getMetadataValue(meta, metaChartMetadata)
+	chartDigest := getMetadataValue(meta, metaChartDigest)
+
+	if serializedChartMeta == "" || chartDigest == "" {
+		return ChartInfo{}, false, nil
+	}
+
+	chartMeta := helmutil.NewChartMetadata()
+	if err := chartMeta.UnmarshalJSON([]byte(serializedChartMeta)); err != nil {
+		return ChartInfo{}, false, fmt.Errorf("unserialize chart meta for %q: %w", filename, err)
+	}
+
+	return ChartInfo{Meta: chartMeta, Filename: filename, Hash: chartDigest}, true, nil
+}
+
+// chartInfoFromArchive builds ChartInfo by loading the chart archive.
+func chartInfoFromArchive(filename string, r io.Reader) (ChartInfo, error) {
+	buf := &bytes.Buffer{}
+	tr := io.TeeReader(r, buf)
+
+	ch, err := helmutil.LoadArchive(tr)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("load archive from object %q: %w", filename, err)
+	}
+
+	digest, err := helmutil.Digest(buf)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("get chart hash for %q: %w", filename, err)
+	}
+
+	return ChartInfo{Meta: ch.Metadata(), Filename: filename, Hash: digest}, nil
+}
+
+// contentETag returns the ETag for the object content, computed the same
+// way as OSS does for simple uploads.
+func contentETag(data []byte) string {
+	sum := md5.Sum(data) //nolint:gosec // See import comment.
+	return `"` + strings.ToUpper(hex.EncodeToString(sum[:])) + `"`
+}
+
+// isChartKey reports whether the repository-relative object key is a chart
+// file located directly in the repository root.
+func isChartKey(key string) bool {
+	return !strings.Contains(key, "/") && strings.HasSuffix(key, ".tgz")
+}
@@ -0,0 +1,427 @@
+package oss
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"helm-oss/internal/helmutil"
+	"sigs.k8s.io/yaml"
+)
+
+// ProfileEnv is the environment variable selecting the configuration profile.
+const ProfileEnv = "HELM_OSS_PROFILE"
+
+// ConfigOptions are the options of LoadConfig given by the caller, e.g. with
+// the command line flags. They take precedence over the environment
+// variables.
+type ConfigOptions struct {
+	// Profile is the name of the profile to use, if not empty.
+	Profile string
+
+	// Overrides are the values which take precedence over all other
+	// sources, applied in order.
+	Overrides []ConfigOverride
+}
+
+// ConfigOverride is the value of a configuration key, see ConfigKeys, given
+// in ConfigOptions.
+type ConfigOverride struct {
+	Key   string
+	Value string
+
+	// Source describes where the value comes from.
+	// Example: "flag --proxy".
+	Source string
+}
+
+// Environment variables overriding the TLS files.
+const (
+	TLSCertFileEnv = "HELM_OSS_TLS_CERT_FILE"
+	TLSKeyFileEnv  = "HELM_OSS_TLS_KEY_FILE"
+	TLSCAFileEnv   = "HELM_OSS_TLS_CA_FILE"
+)
+
+// Environment variables overriding the connection settings.
+const (
+	EndpointModeEnv     = "HELM_OSS_ENDPOINT_MODE"
+	ConnectTimeoutEnv   = "HELM_OSS_CONNECT_TIMEOUT"
+	ReadWriteTimeoutEnv = "HELM_OSS_READ_WRITE_TIMEOUT"
+	ProxyEnv            = "HELM_OSS_PROXY"
+	NoProxyEnv          = "HELM_OSS_NO_PROXY"
+	RetryMaxAttemptsEnv = "HELM_OSS_RETRY_MAX_ATTEMPTS"
+)
+
+// Config is the configuration of the OSS client.
+type Config struct {
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	AccessKeySecret string `json:"accessKeySecret,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+
+	// Credentials configures the credential providers. The static provider
+	// uses the access key above.
+	Credentials CredentialsConfig `json:"credentials,omitzero"`
+
+	// EndpointMode selects the endpoint derived from the region: public,
+	// internal (VPC), accelerate (transfer acceleration) or dual-stack. A
+	// standard OSS endpoint set in Endpoint is switched to the mode as well,
+	// while a custom one is used as is. With cname, Endpoint is the custom
+	// domain bound to the bucket.
+	EndpointMode string `json:"endpointMode,omitempty"`
+
+	// DisableRegionDiscovery disables GetBucketLocation requests used to
+	// find the region of the buckets outside of the configured region.
+	DisableRegionDiscovery bool `json:"disableRegionDiscovery,omitempty"`
+
+	// TLS configures TLS of the connections to the endpoint.
+	TLS TLSConfig `json:"tls,omitzero"`
+
+	// ConnectTimeout is the timeout of establishing the connections.
+	// Defaults to 10s.
+	ConnectTimeout Duration `json:"connectTimeout,omitempty"`
+
+	// ReadWriteTimeout is the timeout of reading or writing the data of the
+	// connections. Defaults to 20s.
+	ReadWriteTimeout Duration `json:"readWriteTimeout,omitempty"`
+
+	// Proxy is the URL of the proxy used for all requests, instead of
+	// HTTP_PROXY and HTTPS_PROXY environment variables.
+	Proxy string `json:"proxy,omitempty"`
+
+	// NoProxy is the comma-separated list of the hosts which are accessed
+	// directly, instead of NO_PROXY environment variable.
+	NoProxy string `json:"noProxy,omitempty"`
+
+	// Retry configures the retries of the failed requests.
+	Retry RetryConfig `json:"retry,omitzero"`
+
+	// UseRepoCredentials enables the credentials of the repositories added
+	// with helm repo add --username ACCESS_KEY_ID --password ACCESS_KEY_SECRET,
+	// along with their TLS files. They take precedence over the configuration
+	// and the environment variables for the URIs in the repository.
+	UseRepoCredentials bool `json:"useRepoCredentials,omitempty"`
+}
+
+// Duration is the duration written in the configuration file as a string,
+// e.g. "30s".
+type Duration time.Duration
+
+// String returns the duration formatted as time.Duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ConfigFile is the contents of the configuration file.
+//
+// The top-level Config is used when no profile is selected. The profile is
+// selected, in order of precedence, by ConfigOptions.Profile, by
+// HELM_OSS_PROFILE, by the binding of the repository and by DefaultProfile.
+type ConfigFile struct {
+	Config
+
+	// DefaultProfile is the profile used when no other profile is selected.
+	DefaultProfile string `json:"defaultProfile,omitempty"`
+
+	// Profiles are the named configurations.
+	Profiles map[string]Config `json:"profiles,omitempty"`
+
+	// Repositories binds the repositories to the profiles. The key is either
+	// the name of the repository added via helm repo add, or OSS URI.
+	// Example: {"prod-charts": "prod", "oss://dev-bucket": "dev"}.
+	Repositories map[string]string `json:"repositories,omitempty"`
+}
+
+// ConfigFilePath returns the path to the configuration file.
+func ConfigFilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "helm_plugin_oss.yaml"), nil
+}
+
+// LoadConfigFile loads the configuration file. If the file does not exist,
+// the empty configuration is returned.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	f := &ConfigFile{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// SaveConfigFile writes the configuration file. The file may contain
+// secrets, so it is only readable by the owner.
+func SaveConfigFile(path string, f *ConfigFile) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("marshal config file: %w", err)
+	}
+
+	if err := writeFileAtomic(path, bytes.NewReader(data), 0o600); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
+}
+
+// ProfileFor returns the name of the profile selected for the repository
+// URI, or an empty string if the top-level configuration is used.
+func (f *ConfigFile) ProfileFor(uri string, opts ConfigOptions) string {
+	profile, _ := f.SelectProfile(uri, opts)
+	return profile
+}
+
+// SelectProfile returns the name of the profile selected for the repository
+// URI along with the reason it was selected. The name is empty if the
+// top-level configuration is used.
+func (f *ConfigFile) SelectProfile(uri string, opts ConfigOptions) (profile, reason string) {
+	if opts.Profile != "" {
+		return opts.Profile, "selected with --profile"
+	}
+	if p := os.Getenv(ProfileEnv); p != "" {
+		return p, "selected with " + ProfileEnv
+	}
+	if p, repo := f.boundProfile(uri); p != "" {
+		return p, "bound to repository " + repo
+	}
+	if f.DefaultProfile != "" {
+		return f.DefaultProfile, "default profile"
+	}
+	return "", "no profile selected"
+}
+
+// boundProfile returns the profile bound to the repository containing uri,
+// and the repository key. If several repositories contain it, the most
+// specific one wins.
+func (f *ConfigFile) boundProfile(uri string) (profile, repo string) {
+	uri = helmutil.TrimURLQuery(uri)
+
+	var matched string
+	for key, p := range f.Repositories {
+		repoURL := key
+		if !strings.Contains(key, "://") {
+			entry, err := helmutil.LookupRepoEntry(key)
+			if err != nil {
+				// The repository is not added, so it cannot match.
+				continue
+			}
+			repoURL = entry.URL()
+		}
+
+		repoURL = strings.TrimSuffix(helmutil.TrimURLQuery(repoURL), "/")
+		if uri != repoURL && !strings.HasPrefix(uri, repoURL+"/") {
+			continue
+		}
+		if len(repoURL) > len(matched) {
+			profile, repo, matched = p, key, repoURL
+		}
+	}
+	return profile, repo
+}
+
+// Profile returns the configuration of the named profile, or the top-level
+// configuration if name is empty.
+func (f *ConfigFile) Profile(name string) (Config, error) {
+	if name == "" {
+		return f.Config, nil
+	}
+	conf, ok := f.Profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("profile %q not found in the config file", name)
+	}
+	return conf, nil
+}
+
+// LoadConfig returns the configuration for the repository URI. It is loaded
+// from the profile selected in ~/.config/helm_plugin_oss.yaml, if the file
+// exists, and overridden with environment variables, if enabled, with the
+// credentials of the repository in Helm repositories.yaml, and with the
+// overrides from opts.
+func LoadConfig(uri string, opts ConfigOptions) (Config, error) {
+	lc, err := loadConfig(uri, opts)
+	if err != nil {
+		return Config{}, err
+	}
+	return lc.Config, nil
+}
+
+// loadedConfig is the configuration loaded by loadConfig, along with where
+// it comes from.
+type loadedConfig struct {
+	Config
+
+	// path is the path to the configuration file.
+	path string
+
+	// profile is the selected profile, empty for the top-level
+	// configuration, and profileReason describes why it is selected.
+	profile       string
+	profileReason string
+
+	// sources are the sources of the values by the config key name.
+	// Example: {"region": "env HELM_OSS_REGION"}.
+	sources map[string]string
+}
+
+// loadConfig loads the configuration as described in LoadConfig, and records
+// the source of each value.
+func loadConfig(uri string, opts ConfigOptions) (*loadedConfig, error) {
+	path, err := ConfigFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lc := &loadedConfig{path: path, sources: make(map[string]string)}
+	lc.profile, lc.profileReason = f.SelectProfile(uri, opts)
+	lc.Config, err = f.Profile(lc.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSource := "config file"
+	if lc.profile != "" {
+		fileSource = "profile " + lc.profile
+	}
+	for _, k := range configKeys {
+		if k.Get(&lc.Config) != "" {
+			lc.sources[k.Name] = fileSource
+		}
+	}
+
+	if err := lc.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := lc.applyRepoCredentials(uri); err != nil {
+		return nil, err
+	}
+
+	for _, o := range opts.Overrides {
+		k, ok := LookupConfigKey(o.Key)
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown config key %q", o.Source, o.Key)
+		}
+		if err := lc.set(k, o.Value, o.Source); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.Source, err)
+		}
+	}
+	return lc, nil
+}
+
+// set sets the value of the key and records its source.
+func (lc *loadedConfig) set(k ConfigKey, value, source string) error {
+	if err := k.Set(&lc.Config, value); err != nil {
+		return err
+	}
+	lc.sources[k.Name] = source
+	return nil
+}
+
+// applyEnv overrides the configuration with HELM_OSS_* environment variables,
+// and fills the unset values from the standard Alibaba Cloud environment
+// variables, e.g. the ones injected by RRSA.
+func (lc *loadedConfig) applyEnv() error {
+	for _, k := range configKeys {
+		for _, env := range k.Env {
+			value := os.Getenv(env)
+			if value == "" || !strings.HasPrefix(env, "HELM_OSS_") && k.Get(&lc.Config) != "" {
+				continue
+			}
+			if err := lc.set(k, value, "env "+env); err != nil {
+				return fmt.Errorf("environment variable %s: %w", env, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyRepoCredentials overrides the configuration with the credentials and
+// TLS files of the Helm repository containing uri, if UseRepoCredentials is
+// enabled.
+func (lc *loadedConfig) applyRepoCredentials(uri string) error {
+	if !lc.UseRepoCredentials {
+		return nil
+	}
+
+	entry, ok, err := helmutil.LookupRepoEntryByURL(uri)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	source := "repository " + entry.Name()
+	creds := entry.Credentials()
+	values := map[string]string{
+		"tls.certFile": creds.CertFile,
+		"tls.keyFile":  creds.KeyFile,
+		"tls.caFile":   creds.CAFile,
+	}
+	if creds.Username != "" {
+		values["accessKeyID"] = creds.Username
+		values["accessKeySecret"] = creds.Password
+	}
+	if creds.InsecureSkipTLSVerify {
+		values["tls.insecureSkipVerify"] = "true"
+	}
+
+	for name, value := range values {
+		k, _ := LookupConfigKey(name)
+		if value == "" {
+			continue
+		}
+		if err := lc.set(k, value, source); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	if creds.Username != "" {
+		// The session token belongs to the replaced access key.
+		lc.SessionToken = ""
+		delete(lc.sources, "sessionToken")
+	}
+	return nil
+}
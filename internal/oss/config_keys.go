@@ -0,0 +1,287 @@
+package oss
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigKey is a configuration key, which can be viewed and changed with
+// `helm oss config`.
+type ConfigKey struct {
+	// Name is the path of the key in the configuration file.
+	// Example: "credentials.roleArn".
+	Name string
+
+	// Secret is true if the value must not be displayed.
+	Secret bool
+
+	// Env are the environment variables overriding the value.
+	Env []string
+
+	// field returns the pointer to the value in the configuration. It is one
+	// of *string, *bool, *int, *Duration, *[]string and *[]int.
+	field func(c *Config) any
+}
+
+var configKeys = []ConfigKey{
+	{Name: "endpoint", Env: []string{"HELM_OSS_ENDPOINT"}, field: func(c *Config) any { return &c.Endpoint }},
+	{Name: "region", Env: []string{"HELM_OSS_REGION"}, field: func(c *Config) any { return &c.Region }},
+	{Name: "endpointMode", Env: []string{EndpointModeEnv}, field: func(c *Config) any { return &c.EndpointMode }},
+	{Name: "accessKeyID", Env: []string{"HELM_OSS_ACCESS_KEY_ID"}, field: func(c *Config) any { return &c.AccessKeyID }},
+	{
+		Name: "accessKeySecret", Secret: true, Env: []string{"HELM_OSS_ACCESS_KEY_SECRET"},
+		field: func(c *Config) any { return &c.AccessKeySecret },
+	},
+	{
+		Name: "sessionToken", Secret: true, Env: []string{"HELM_OSS_SESSION_TOKEN"},
+		field: func(c *Config) any { return &c.SessionToken },
+	},
+	{Name: "disableRegionDiscovery", field: func(c *Config) any { return &c.DisableRegionDiscovery }},
+	{
+		Name: "connectTimeout", Env: []string{ConnectTimeoutEnv},
+		field: func(c *Config) any { return &c.ConnectTimeout },
+	},
+	{
+		Name: "readWriteTimeout", Env: []string{ReadWriteTimeoutEnv},
+		field: func(c *Config) any { return &c.ReadWriteTimeout },
+	},
+	{Name: "proxy", Env: []string{ProxyEnv}, field: func(c *Config) any { return &c.Proxy }},
+	{Name: "noProxy", Env: []string{NoProxyEnv}, field: func(c *Config) any { return &c.NoProxy }},
+	{
+		Name: "retry.maxAttempts", Env: []string{RetryMaxAttemptsEnv},
+		field: func(c *Config) any { return &c.Retry.MaxAttempts },
+	},
+	{Name: "retry.baseDelay", field: func(c *Config) any { return &c.Retry.BaseDelay }},
+	{Name: "retry.maxBackoff", field: func(c *Config) any { return &c.Retry.MaxBackoff }},
+	{Name: "retry.statusCodes", field: func(c *Config) any { return &c.Retry.StatusCodes }},
+	{Name: "useRepoCredentials", field: func(c *Config) any { return &c.UseRepoCredentials }},
+	{
+		Name: "tls.certFile", Env: []string{TLSCertFileEnv},
+		field: func(c *Config) any { return &c.TLS.CertFile },
+	},
+	{
+		Name: "tls.keyFile", Env: []string{TLSKeyFileEnv},
+		field: func(c *Config) any { return &c.TLS.KeyFile },
+	},
+	{
+		Name: "tls.caFile", Env: []string{TLSCAFileEnv},
+		field: func(c *Config) any { return &c.TLS.CAFile },
+	},
+	{Name: "tls.insecureSkipVerify", field: func(c *Config) any { return &c.TLS.InsecureSkipVerify }},
+	{Name: "credentials.providers", field: func(c *Config) any { return &c.Credentials.Providers }},
+	{
+		Name: "credentials.ramRole", Env: []string{"ALIBABA_CLOUD_ECS_METADATA"},
+		field: func(c *Config) any { return &c.Credentials.RAMRole },
+	},
+	{
+		Name: "credentials.roleArn", Env: []string{"ALIBABA_CLOUD_ROLE_ARN"},
+		field: func(c *Config) any { return &c.Credentials.RoleARN },
+	},
+	{
+		Name: "credentials.roleSessionName", Env: []string{"ALIBABA_CLOUD_ROLE_SESSION_NAME"},
+		field: func(c *Config) any { return &c.Credentials.RoleSessionName },
+	},
+	{Name: "credentials.externalId", field: func(c *Config) any { return &c.Credentials.ExternalID }},
+	{Name: "credentials.policy", field: func(c *Config) any { return &c.Credentials.Policy }},
+	{Name: "credentials.durationSeconds", field: func(c *Config) any { return &c.Credentials.DurationSeconds }},
+	{
+		Name: "credentials.oidcProviderArn", Env: []string{"ALIBABA_CLOUD_OIDC_PROVIDER_ARN"},
+		field: func(c *Config) any { return &c.Credentials.OIDCProviderARN },
+	},
+	{
+		Name: "credentials.oidcTokenFile", Env: []string{"ALIBABA_CLOUD_OIDC_TOKEN_FILE"},
+		field: func(c *Config) any { return &c.Credentials.OIDCTokenFile },
+	},
+	{
+		Name: "credentials.stsEndpoint", Env: []string{"ALIBABA_CLOUD_STS_ENDPOINT"},
+		field: func(c *Config) any { return &c.Credentials.STSEndpoint },
+	},
+	{
+		Name: "credentials.metadataEndpoint", Env: []string{MetadataEndpointEnv},
+		field: func(c *Config) any { return &c.Credentials.MetadataEndpoint },
+	},
+}
+
+// ConfigKeys returns all configuration keys.
+func ConfigKeys() []ConfigKey {
+	return configKeys
+}
+
+// LookupConfigKey returns the configuration key by name.
+func LookupConfigKey(name string) (ConfigKey, bool) {
+	for _, k := range configKeys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return ConfigKey{}, false
+}
+
+// Get returns the value of the key in the configuration, formatted as it is
+// accepted by Set. It returns an empty string if the value is not set.
+func (k ConfigKey) Get(c *Config) string {
+	switch v := k.field(c).(type) {
+	case *string:
+		return *v
+	case *bool:
+		if !*v {
+			return ""
+		}
+		return strconv.FormatBool(*v)
+	case *int:
+		if *v == 0 {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	case *Duration:
+		if *v == 0 {
+			return ""
+		}
+		return v.String()
+	case *[]string:
+		return strings.Join(*v, ",")
+	case *[]int:
+		items := make([]string, len(*v))
+		for i, item := range *v {
+			items[i] = strconv.Itoa(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		panic(fmt.Sprintf("unexpected type %T of config key %s", v, k.Name))
+	}
+}
+
+// Set parses and sets the value of the key in the configuration. Lists are
+// comma-separated.
+func (k ConfigKey) Set(c *Config, value string) error {
+	switch v := k.field(c).(type) {
+	case *string:
+		*v = value
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", k.Name)
+		}
+		*v = b
+	case *int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", k.Name)
+		}
+		*v = i
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration, e.g. 30s", k.Name)
+		}
+		*v = Duration(d)
+	case *[]string:
+		*v = nil
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *[]int:
+		*v = nil
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			i, err := strconv.Atoi(item)
+			if err != nil {
+				return fmt.Errorf("%s must be a list of integers", k.Name)
+			}
+			*v = append(*v, i)
+		}
+	default:
+		panic(fmt.Sprintf("unexpected type %T of config key %s", v, k.Name))
+	}
+	return nil
+}
+
+// Unset removes the value of the key from the configuration.
+func (k ConfigKey) Unset(c *Config) {
+	switch v := k.field(c).(type) {
+	case *string:
+		*v = ""
+	case *bool:
+		*v = false
+	case *int:
+		*v = 0
+	case *Duration:
+		*v = 0
+	case *[]string:
+		*v = nil
+	case *[]int:
+		*v = nil
+	default:
+		panic(fmt.Sprintf("unexpected type %T of config key %s", v, k.Name))
+	}
+}
+
+// ConfigValue is the effective value of a configuration key.
+type ConfigValue struct {
+	Key string `json:"key"`
+
+	// Value is the value, masked if the key is secret.
+	Value string `json:"value"`
+
+	// Source describes where the value comes from.
+	// Example: "env HELM_OSS_REGION".
+	Source string `json:"source"`
+}
+
+// ConfigExplanation is the effective configuration for a repository.
+type ConfigExplanation struct {
+	// Path is the path to the configuration file.
+	Path string `json:"path"`
+
+	// Profile is the selected profile, empty for the top-level configuration.
+	Profile string `json:"profile,omitempty"`
+
+	// ProfileReason describes why the profile is selected.
+	ProfileReason string `json:"profileReason"`
+
+	// Values are the values which are set.
+	Values []ConfigValue `json:"values"`
+}
+
+// ExplainConfig returns the effective configuration for the repository URI,
+// as loaded by LoadConfig, with the source of each value.
+func ExplainConfig(uri string, opts ConfigOptions) (*ConfigExplanation, error) {
+	lc, err := loadConfig(uri, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	exp := &ConfigExplanation{
+		Path:          lc.path,
+		Profile:       lc.profile,
+		ProfileReason: lc.profileReason,
+		Values:        []ConfigValue{},
+	}
+	for _, k := range configKeys {
+		value := k.Get(&lc.Config)
+		if value == "" {
+			continue
+		}
+		if k.Secret {
+			value = MaskSecret(value)
+		}
+		exp.Values = append(exp.Values, ConfigValue{Key: k.Name, Value: value, Source: lc.sources[k.Name]})
+	}
+
+	return exp, nil
+}
+
+// MaskSecret masks the secret value, keeping the last characters only for
+// long values, so that the value can be recognized.
+func MaskSecret(value string) string {
+	if len(value) <= 8 {
+		return "****"
+	}
+	return "****" + value[len(value)-4:]
+}
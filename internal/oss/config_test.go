@@ -0,0 +1,226 @@
+package oss
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+)
+
+const testConfigFile = `
+region: "oss-cn-hangzhou"
+accessKeyID: "top-ak"
+defaultProfile: dev
+profiles:
+  dev:
+    region: "oss-cn-beijing"
+    accessKeyID: "dev-ak"
+  prod:
+    region: "oss-cn-shanghai"
+    accessKeyID: "prod-ak"
+  archive:
+    region: "oss-cn-shenzhen"
+    accessKeyID: "archive-ak"
+repositories:
+  prod-charts: prod
+  oss://prod-bucket/charts/archive: archive
+`
+
+const testRepositoriesFile = `
+apiVersion: ""
+generated: "0001-01-01T00:00:00Z"
+repositories:
+- name: prod-charts
+  url: oss://prod-bucket/charts
+`
+
+func TestLoadConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(ProfileEnv, "")
+	for _, env := range []string{
+		"HELM_OSS_ENDPOINT", "HELM_OSS_REGION", "HELM_OSS_ACCESS_KEY_ID", "HELM_OSS_ACCESS_KEY_SECRET", "HELM_OSS_SESSION_TOKEN",
+	} {
+		t.Setenv(env, "")
+	}
+
+	t.Run("no config file", func(t *testing.T) {
+		conf, err := LoadConfig("oss://bucket/charts", ConfigOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, Config{}, conf)
+	})
+
+	path, err := ConfigFilePath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(testConfigFile), 0o600))
+
+	repoFile := filepath.Join(home, "repositories.yaml")
+	require.NoError(t, os.WriteFile(repoFile, []byte(testRepositoriesFile), 0o600))
+	t.Setenv("HELM_REPOSITORY_CONFIG", repoFile)
+	helmutil.SetupHelm()
+
+	tests := []struct {
+		name    string
+		uri     string
+		profile string
+		env     string
+		wantAK  string
+	}{
+		{name: "default profile", uri: "oss://dev-bucket/charts", wantAK: "dev-ak"},
+		{name: "bound by repo name", uri: "oss://prod-bucket/charts", wantAK: "prod-ak"},
+		{name: "bound by repo name, chart URL", uri: "oss://prod-bucket/charts/foo-1.2.3.tgz", wantAK: "prod-ak"},
+		{name: "bound by URI", uri: "oss://prod-bucket/charts/archive", wantAK: "archive-ak"},
+		{name: "selected by env", uri: "oss://prod-bucket/charts", profile: "archive", wantAK: "archive-ak"},
+		{name: "env overrides profile", uri: "oss://prod-bucket/charts", env: "env-ak", wantAK: "env-ak"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(ProfileEnv, tc.profile)
+			t.Setenv("HELM_OSS_ACCESS_KEY_ID", tc.env)
+
+			conf, err := LoadConfig(tc.uri, ConfigOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantAK, conf.AccessKeyID)
+		})
+	}
+
+	t.Run("selected by option", func(t *testing.T) {
+		t.Setenv(ProfileEnv, "archive")
+
+		conf, err := LoadConfig("oss://dev-bucket/charts", ConfigOptions{Profile: "prod"})
+		require.NoError(t, err)
+		assert.Equal(t, "prod-ak", conf.AccessKeyID)
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		t.Setenv(ProfileEnv, "foo")
+
+		_, err := LoadConfig("oss://bucket/charts", ConfigOptions{})
+		assert.ErrorContains(t, err, `profile "foo" not found`)
+	})
+
+	t.Run("top-level config", func(t *testing.T) {
+		f, err := LoadConfigFile(path)
+		require.NoError(t, err)
+
+		conf, err := f.Profile("")
+		require.NoError(t, err)
+		assert.Equal(t, "top-ak", conf.AccessKeyID)
+		assert.Equal(t, "oss-cn-hangzhou", conf.Region)
+	})
+}
+
+func TestLoadConfig_RepoCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(ProfileEnv, "")
+	t.Setenv("HELM_OSS_ACCESS_KEY_ID", "env-ak")
+	t.Setenv("HELM_OSS_ACCESS_KEY_SECRET", "")
+	t.Setenv("HELM_OSS_SESSION_TOKEN", "env-token")
+
+	path, err := ConfigFilePath()
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+
+	repoFile := filepath.Join(home, "repositories.yaml")
+	require.NoError(t, os.WriteFile(repoFile, []byte(`
+repositories:
+- name: team-a
+  url: oss://team-a-bucket/charts
+  username: team-a-ak
+  password: team-a-sk
+  caFile: /etc/pki/ca.pem
+`), 0o600))
+	t.Setenv("HELM_REPOSITORY_CONFIG", repoFile)
+	helmutil.SetupHelm()
+
+	t.Run("disabled", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("region: cn-hangzhou\n"), 0o600))
+
+		conf, err := LoadConfig("oss://team-a-bucket/charts/foo-1.2.3.tgz", ConfigOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "env-ak", conf.AccessKeyID)
+		assert.Empty(t, conf.TLS.CAFile)
+	})
+
+	require.NoError(t, os.WriteFile(path, []byte("region: cn-hangzhou\nuseRepoCredentials: true\n"), 0o600))
+
+	t.Run("enabled", func(t *testing.T) {
+		conf, err := LoadConfig("oss://team-a-bucket/charts/foo-1.2.3.tgz", ConfigOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "team-a-ak", conf.AccessKeyID)
+		assert.Equal(t, "team-a-sk", conf.AccessKeySecret)
+		assert.Empty(t, conf.SessionToken)
+		assert.Equal(t, "/etc/pki/ca.pem", conf.TLS.CAFile)
+
+		exp, err := ExplainConfig("oss://team-a-bucket/charts", ConfigOptions{})
+		require.NoError(t, err)
+		assert.Contains(t, exp.Values, ConfigValue{Key: "accessKeyID", Value: "team-a-ak", Source: "repository team-a"})
+		assert.Contains(t, exp.Values, ConfigValue{Key: "region", Value: "cn-hangzhou", Source: "config file"})
+		assert.Contains(t, exp.Values, ConfigValue{Key: "tls.caFile", Value: "/etc/pki/ca.pem", Source: "repository team-a"})
+		assert.False(t, slices.ContainsFunc(exp.Values, func(v ConfigValue) bool { return v.Key == "sessionToken" }))
+	})
+
+	t.Run("env same as file", func(t *testing.T) {
+		// The environment variable is the source, even if the value is
+		// the same.
+		t.Setenv("HELM_OSS_REGION", "cn-hangzhou")
+
+		exp, err := ExplainConfig("oss://team-b-bucket/charts", ConfigOptions{})
+		require.NoError(t, err)
+		assert.Contains(t, exp.Values, ConfigValue{Key: "region", Value: "cn-hangzhou", Source: "env HELM_OSS_REGION"})
+		assert.Contains(t, exp.Values, ConfigValue{Key: "accessKeyID", Value: "env-ak", Source: "env HELM_OSS_ACCESS_KEY_ID"})
+	})
+
+	t.Run("other repository", func(t *testing.T) {
+		conf, err := LoadConfig("oss://team-b-bucket/charts", ConfigOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "env-ak", conf.AccessKeyID)
+		assert.Equal(t, "env-token", conf.SessionToken)
+	})
+}
+
+func TestLoadConfig_ConnectionEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ProfileEnv, "")
+	t.Setenv(ConnectTimeoutEnv, "5s")
+	t.Setenv(RetryMaxAttemptsEnv, "10")
+	t.Setenv(ProxyEnv, "http://proxy:8080")
+
+	conf, err := LoadConfig("oss://bucket/charts", ConfigOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, Duration(5*time.Second), conf.ConnectTimeout)
+	assert.Equal(t, 10, conf.Retry.MaxAttempts)
+	assert.Equal(t, "http://proxy:8080", conf.Proxy)
+
+	t.Setenv(ReadWriteTimeoutEnv, "forever")
+	_, err = LoadConfig("oss://bucket/charts", ConfigOptions{})
+	assert.ErrorContains(t, err, "environment variable HELM_OSS_READ_WRITE_TIMEOUT: readWriteTimeout must be a duration")
+}
+
+func TestLoadConfig_Overrides(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ProfileEnv, "")
+	t.Setenv(TLSCAFileEnv, "/etc/pki/env-ca.pem")
+
+	opts := ConfigOptions{Overrides: []ConfigOverride{
+		{Key: "tls.caFile", Value: "/tmp/ca.pem", Source: "helm downloader"},
+	}}
+	exp, err := ExplainConfig("oss://bucket/charts", opts)
+	require.NoError(t, err)
+	assert.Contains(t, exp.Values, ConfigValue{Key: "tls.caFile", Value: "/tmp/ca.pem", Source: "helm downloader"})
+
+	opts.Overrides = append(opts.Overrides, ConfigOverride{Key: "connectTimeout", Value: "soon", Source: "flag --connect-timeout"})
+	_, err = LoadConfig("oss://bucket/charts", opts)
+	assert.ErrorContains(t, err, "flag --connect-timeout: connectTimeout must be a duration")
+
+	opts.Overrides = []ConfigOverride{{Key: "foo", Value: "bar", Source: "test"}}
+	_, err = LoadConfig("oss://bucket/charts", opts)
+	assert.ErrorContains(t, err, `test: unknown config key "foo"`)
+}
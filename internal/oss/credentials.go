@@ -0,0 +1,447 @@
+package oss
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/credentials"
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/signer"
+	"github.com/aliyun/credentials-go/credentials/providers"
+)
+
+// Credential provider names accepted in CredentialsConfig.Providers.
+const (
+	// ProviderStatic uses the access key from the configuration file or
+	// HELM_OSS_* environment variables.
+	ProviderStatic = "static"
+
+	// ProviderEnv uses the access key from the OSS_* or ALIBABA_CLOUD_*
+	// environment variables.
+	ProviderEnv = "env"
+
+	// ProviderAssumeRole assumes the RAM role via STS, using the static or
+	// environment access key as the source credentials.
+	ProviderAssumeRole = "assume-role"
+
+	// ProviderOIDC assumes the RAM role via STS with the OIDC token, as used
+	// by RRSA on ACK clusters.
+	ProviderOIDC = "oidc"
+
+	// ProviderECSRAMRole uses the RAM role attached to the ECS instance via
+	// the instance metadata service.
+	ProviderECSRAMRole = "ecs-ram-role"
+
+	// ProviderAnonymous sends unsigned requests, which is enough for public
+	// buckets.
+	ProviderAnonymous = "anonymous"
+)
+
+const (
+	// defaultRoleSessionName is the session name of the assumed roles.
+	defaultRoleSessionName = "helm-oss"
+
+	// credentialsRequestTimeout limits requests to the metadata service and
+	// STS, unless the timeouts are configured.
+	credentialsRequestTimeout = 10 * time.Second
+
+	// defaultSTSHost is the STS endpoint used by credentials-go by default.
+	defaultSTSHost = "sts.aliyuncs.com"
+
+	// metadataURL is the ECS instance metadata service, which credentials-go
+	// always requests.
+	metadataURL = "http://100.100.100.200"
+)
+
+// MetadataEndpointEnv overrides the endpoint of the ECS instance metadata
+// service.
+const MetadataEndpointEnv = "HELM_OSS_METADATA_ENDPOINT"
+
+// CredentialsConfig configures how the credentials are obtained.
+type CredentialsConfig struct {
+	// Providers is the ordered list of credential providers. The providers
+	// which are not configured are skipped, and the credentials of the first
+	// remaining provider which succeeds are used. Requests are not signed if
+	// no provider is configured.
+	//
+	// By default, the providers are assume-role, oidc, static and env, and
+	// also ecs-ram-role if RAMRole is set.
+	Providers []string `json:"providers,omitempty"`
+
+	// RAMRole is the name of the RAM role attached to the ECS instance.
+	// It is discovered via the metadata service if empty.
+	RAMRole string `json:"ramRole,omitempty"`
+
+	// RoleARN is the ARN of the RAM role to assume.
+	// Example: "acs:ram::123456789012****:role/helm-publisher".
+	RoleARN string `json:"roleArn,omitempty"`
+
+	// RoleSessionName is the session name of the assumed role.
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+
+	// ExternalID is passed to AssumeRole, if the role requires it.
+	ExternalID string `json:"externalId,omitempty"`
+
+	// Policy further restricts the permissions of the assumed role.
+	Policy string `json:"policy,omitempty"`
+
+	// DurationSeconds is the lifetime of the assumed role credentials.
+	DurationSeconds int `json:"durationSeconds,omitempty"`
+
+	// OIDCProviderARN is the ARN of the OIDC provider used by RRSA.
+	OIDCProviderARN string `json:"oidcProviderArn,omitempty"`
+
+	// OIDCTokenFile is the path to the OIDC token mounted into the pod.
+	OIDCTokenFile string `json:"oidcTokenFile,omitempty"`
+
+	// STSEndpoint is the STS endpoint, either https URL or host name.
+	STSEndpoint string `json:"stsEndpoint,omitempty"`
+
+	// MetadataEndpoint is the http URL of the ECS instance metadata service,
+	// e.g. a local stand-in. Defaults to http://100.100.100.200.
+	MetadataEndpoint string `json:"metadataEndpoint,omitempty"`
+}
+
+// NewCredentialsProvider returns the credentials provider chain for the
+// configuration. It returns the anonymous provider if no provider is
+// configured.
+func NewCredentialsProvider(conf Config) (credentials.CredentialsProvider, error) {
+	c := conf.Credentials
+
+	names := c.Providers
+	if len(names) == 0 {
+		names = []string{ProviderAssumeRole, ProviderOIDC, ProviderStatic, ProviderEnv}
+		if c.RAMRole != "" {
+			names = append(names, ProviderECSRAMRole)
+		}
+	}
+
+	sts, _ := stsHost(c.STSEndpoint)
+	stsOptions, err := credentialsHTTPOptions(conf, "https://"+cmp.Or(sts, defaultSTSHost))
+	if err != nil {
+		return nil, err
+	}
+	metadataOptions, err := credentialsHTTPOptions(conf, metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	static := staticKeys(conf)
+
+	chain := &chainProvider{chosen: -1}
+	for _, name := range names {
+		// p is nil if the provider is not configured.
+		var p credentials.CredentialsProvider
+		var err error
+		switch name {
+		case ProviderStatic:
+			p = keysProvider(static)
+		case ProviderEnv:
+			p = keysProvider(envKeys())
+		case ProviderAssumeRole:
+			p, err = newAssumeRoleProvider(stsOptions, c, static)
+		case ProviderOIDC:
+			p, err = newOIDCProvider(stsOptions, c)
+		case ProviderECSRAMRole:
+			p, err = newECSRAMRoleProvider(metadataOptions, c)
+		case ProviderAnonymous:
+			p = anonymousProvider{}
+		default:
+			return nil, fmt.Errorf("unknown credentials provider %q, must be one of: %s", name, strings.Join([]string{
+				ProviderStatic, ProviderEnv, ProviderAssumeRole, ProviderOIDC, ProviderECSRAMRole, ProviderAnonymous,
+			}, ", "))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("credentials provider %s: %w", name, err)
+		}
+		if p != nil {
+			chain.names = append(chain.names, name)
+			chain.providers = append(chain.providers, p)
+		}
+	}
+
+	if len(chain.providers) == 0 || chain.names[0] == ProviderAnonymous {
+		return credentials.NewAnonymousCredentialsProvider(), nil
+	}
+	return chain, nil
+}
+
+// chainProvider returns the credentials of the first provider in the chain
+// which succeeds. The provider which succeeded last is tried first, and the
+// others are tried in order if it fails.
+type chainProvider struct {
+	names     []string
+	providers []credentials.CredentialsProvider
+
+	mu sync.Mutex
+	// chosen is the index of the provider which succeeded last, -1 until a
+	// provider has returned the credentials.
+	chosen int
+}
+
+func (c *chainProvider) GetCredentials(ctx context.Context) (credentials.Credentials, error) {
+	c.mu.Lock()
+	chosen := c.chosen
+	c.mu.Unlock()
+
+	errs := make([]string, 0, len(c.providers))
+	try := func(i int) (credentials.Credentials, bool) {
+		creds, err := c.providers[i].GetCredentials(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.names[i], err))
+			return credentials.Credentials{}, false
+		}
+		c.mu.Lock()
+		c.chosen = i
+		c.mu.Unlock()
+		return creds, true
+	}
+
+	if chosen >= 0 {
+		if creds, ok := try(chosen); ok {
+			return creds, nil
+		}
+	}
+	for i := range c.providers {
+		if i == chosen {
+			continue
+		}
+		if creds, ok := try(i); ok {
+			return creds, nil
+		}
+	}
+
+	return credentials.Credentials{}, fmt.Errorf("no credentials found: %s", strings.Join(errs, "; "))
+}
+
+// chosenName returns the name of the provider which succeeded last, or an
+// empty string if no provider has returned the credentials yet.
+func (c *chainProvider) chosenName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.chosen < 0 {
+		return ""
+	}
+	return c.names[c.chosen]
+}
+
+// anonymousProvider returns the credentials without keys, so that the
+// requests are sent unsigned by optionalSigner. Unlike
+// credentials.AnonymousCredentialsProvider, it also works as a fallback in
+// the chain.
+type anonymousProvider struct{}
+
+func (anonymousProvider) GetCredentials(context.Context) (credentials.Credentials, error) {
+	return credentials.Credentials{}, nil
+}
+
+// optionalSigner signs the requests only if the credentials have the keys.
+type optionalSigner struct {
+	signer.Signer
+}
+
+func (s optionalSigner) Sign(ctx context.Context, signingCtx *signer.SigningContext) error {
+	if signingCtx.Credentials == nil || !signingCtx.Credentials.HasKeys() {
+		return nil
+	}
+	return s.Signer.Sign(ctx, signingCtx)
+}
+
+func staticKeys(conf Config) credentials.Credentials {
+	return credentials.Credentials{
+		AccessKeyID:     conf.AccessKeyID,
+		AccessKeySecret: conf.AccessKeySecret,
+		SecurityToken:   conf.SessionToken,
+	}
+}
+
+func envKeys() credentials.Credentials {
+	lookup := func(keys ...string) string {
+		for _, key := range keys {
+			if v := os.Getenv(key); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	return credentials.Credentials{
+		AccessKeyID:     lookup("OSS_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_ID"),
+		AccessKeySecret: lookup("OSS_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_ACCESS_KEY_SECRET"),
+		SecurityToken:   lookup("OSS_SESSION_TOKEN", "ALIBABA_CLOUD_SECURITY_TOKEN"),
+	}
+}
+
+// keysProvider returns the provider of the fixed access key, or nil if the
+// key is not set.
+func keysProvider(creds credentials.Credentials) credentials.CredentialsProvider {
+	if !creds.HasKeys() {
+		return nil
+	}
+	return credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.AccessKeySecret, creds.SecurityToken)
+}
+
+// newAssumeRoleProvider returns the provider which assumes the role with the
+// static or environment access key. It returns nil if the role or the access
+// key is not set.
+func newAssumeRoleProvider(
+	httpOptions *providers.HttpOptions,
+	c CredentialsConfig,
+	static credentials.Credentials,
+) (credentials.CredentialsProvider, error) {
+	if c.RoleARN == "" || c.OIDCProviderARN != "" {
+		return nil, nil
+	}
+
+	source := static
+	if !source.HasKeys() {
+		source = envKeys()
+	}
+	if !source.HasKeys() {
+		return nil, nil
+	}
+
+	endpoint, err := stsHost(c.STSEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := providers.NewRAMRoleARNCredentialsProviderBuilder().
+		WithAccessKeyId(source.AccessKeyID).
+		WithAccessKeySecret(source.AccessKeySecret).
+		WithSecurityToken(source.SecurityToken).
+		WithRoleArn(c.RoleARN).
+		WithRoleSessionName(cmp.Or(c.RoleSessionName, defaultRoleSessionName)).
+		WithExternalId(c.ExternalID).
+		WithPolicy(c.Policy).
+		WithDurationSeconds(c.DurationSeconds).
+		WithStsEndpoint(endpoint).
+		WithHttpOptions(httpOptions).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return &refreshingProvider{provider: p}, nil
+}
+
+// newOIDCProvider returns the provider which assumes the role with the OIDC
+// token, as used by RRSA. The token file is read on every refresh, as it is
+// rotated. It returns nil if RRSA is not configured.
+func newOIDCProvider(httpOptions *providers.HttpOptions, c CredentialsConfig) (credentials.CredentialsProvider, error) {
+	if c.RoleARN == "" || c.OIDCProviderARN == "" || c.OIDCTokenFile == "" {
+		return nil, nil
+	}
+
+	endpoint, err := stsHost(c.STSEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := providers.NewOIDCCredentialsProviderBuilder().
+		WithRoleArn(c.RoleARN).
+		WithOIDCProviderARN(c.OIDCProviderARN).
+		WithOIDCTokenFilePath(c.OIDCTokenFile).
+		WithRoleSessionName(cmp.Or(c.RoleSessionName, defaultRoleSessionName)).
+		WithPolicy(c.Policy).
+		WithDurationSeconds(c.DurationSeconds).
+		WithSTSEndpoint(endpoint).
+		WithHttpOptions(httpOptions).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return &refreshingProvider{provider: p}, nil
+}
+
+// newECSRAMRoleProvider returns the provider which gets the credentials of
+// the RAM role attached to the ECS instance. The role is discovered via the
+// metadata service if RAMRole is empty.
+func newECSRAMRoleProvider(httpOptions *providers.HttpOptions, c CredentialsConfig) (credentials.CredentialsProvider, error) {
+	if c.MetadataEndpoint != "" {
+		u, err := url.Parse(c.MetadataEndpoint)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("metadata endpoint %q must be an http URL", c.MetadataEndpoint)
+		}
+		// credentials-go always requests metadataURL, so the requests are
+		// sent to the endpoint as to a proxy, which receives the metadata
+		// paths unchanged.
+		opts := *httpOptions
+		opts.Proxy = u.String()
+		httpOptions = &opts
+	}
+
+	p, err := providers.NewECSRAMRoleCredentialsProviderBuilder().
+		WithRoleName(c.RAMRole).
+		WithHttpOptions(httpOptions).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return &refreshingProvider{provider: p}, nil
+}
+
+// credentialsHTTPOptions returns the options of the credentials-go requests
+// to the URL, with the proxy selected as for the OSS requests and the
+// configured timeouts. credentials-go does not accept an HTTP client, so the
+// TLS configuration, which is meant for the OSS endpoint, the retries and the
+// request logging do not apply.
+func credentialsHTTPOptions(conf Config, rawURL string) (*providers.HttpOptions, error) {
+	proxy, err := proxyFunc(conf)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	proxyURL, err := proxy(req)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &providers.HttpOptions{
+		ConnectTimeout: int(cmp.Or(time.Duration(conf.ConnectTimeout), credentialsRequestTimeout).Milliseconds()),
+		ReadTimeout:    int(cmp.Or(time.Duration(conf.ReadWriteTimeout), credentialsRequestTimeout).Milliseconds()),
+	}
+	if proxyURL != nil {
+		opts.Proxy = proxyURL.String()
+	}
+	return opts, nil
+}
+
+// stsHost returns the host name of the STS endpoint, or an empty string for
+// the default endpoint.
+func stsHost(endpoint string) (string, error) {
+	host, ok := strings.CutPrefix(endpoint, "https://")
+	if !ok && strings.Contains(endpoint, "://") {
+		return "", fmt.Errorf("sts endpoint %q must use https", endpoint)
+	}
+	return strings.TrimSuffix(host, "/"), nil
+}
+
+// refreshingProvider adapts the credentials-go provider, which caches the
+// credentials and refreshes them before they expire, but is not safe for
+// concurrent use.
+type refreshingProvider struct {
+	mu       sync.Mutex
+	provider providers.CredentialsProvider
+}
+
+func (p *refreshingProvider) GetCredentials(context.Context) (credentials.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cc, err := p.provider.GetCredentials()
+	if err != nil {
+		return credentials.Credentials{}, err
+	}
+	return credentials.Credentials{
+		AccessKeyID:     cc.AccessKeyId,
+		AccessKeySecret: cc.AccessKeySecret,
+		SecurityToken:   cc.SecurityToken,
+	}, nil
+}
@@ -0,0 +1,396 @@
+package oss
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss"
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/credentials"
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/signer"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stsCert is the certificate of the STS stand-ins. credentials-go sends the
+// STS requests over https, trusting only the system roots, and takes neither
+// an HTTP client nor a CA, so TestMain adds the certificate to the roots with
+// SSL_CERT_FILE before any certificate is verified. It differs from the
+// httptest certificate, which the other tests expect to be untrusted.
+var stsCert tls.Certificate
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "helm-oss-test")
+	if err != nil {
+		panic(err)
+	}
+	certFile := filepath.Join(dir, "sts.pem")
+	stsCert, err = newServerCert(certFile)
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Setenv("SSL_CERT_FILE", certFile); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newServerCert returns the self-signed server certificate for the loopback
+// addresses, and writes it to certFile.
+func newServerCert(certFile string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "helm-oss sts"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+func TestNewCredentialsProvider(t *testing.T) {
+	t.Setenv("OSS_ACCESS_KEY_ID", "")
+	t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "")
+	ctx := context.Background()
+
+	t.Run("static", func(t *testing.T) {
+		p, err := NewCredentialsProvider(Config{AccessKeyID: "ak", AccessKeySecret: "sk", SessionToken: "token"})
+		require.NoError(t, err)
+
+		creds, err := p.GetCredentials(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "ak", creds.AccessKeyID)
+		assert.Equal(t, "sk", creds.AccessKeySecret)
+		assert.Equal(t, "token", creds.SecurityToken)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "env-ak")
+		t.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "env-sk")
+
+		p, err := NewCredentialsProvider(Config{})
+		require.NoError(t, err)
+
+		creds, err := p.GetCredentials(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "env-ak", creds.AccessKeyID)
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		p, err := NewCredentialsProvider(Config{})
+		require.NoError(t, err)
+		assert.IsType(t, &credentials.AnonymousCredentialsProvider{}, p)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := NewCredentialsProvider(Config{Credentials: CredentialsConfig{Providers: []string{"foo"}}})
+		assert.ErrorContains(t, err, `unknown credentials provider "foo"`)
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		p, err := NewCredentialsProvider(Config{
+			Credentials: CredentialsConfig{Providers: []string{ProviderStatic, ProviderAnonymous}},
+		})
+		require.NoError(t, err)
+		assert.IsType(t, &credentials.AnonymousCredentialsProvider{}, p)
+	})
+
+	t.Run("anonymous fallback", func(t *testing.T) {
+		p, err := NewCredentialsProvider(Config{
+			AccessKeyID:     "ak",
+			AccessKeySecret: "sk",
+			Credentials:     CredentialsConfig{Providers: []string{ProviderStatic, ProviderAnonymous}},
+		})
+		require.NoError(t, err)
+		require.IsType(t, &chainProvider{}, p)
+		assert.Equal(t, []string{ProviderStatic, ProviderAnonymous}, p.(*chainProvider).names)
+	})
+
+	t.Run("http sts endpoint", func(t *testing.T) {
+		_, err := NewCredentialsProvider(Config{
+			AccessKeyID:     "ak",
+			AccessKeySecret: "sk",
+			Credentials: CredentialsConfig{
+				RoleARN:     "acs:ram::1:role/helm",
+				STSEndpoint: "http://sts.aliyuncs.com",
+			},
+		})
+		assert.ErrorContains(t, err, "must use https")
+	})
+
+	t.Run("ecs ram role", func(t *testing.T) {
+		srv := newMetadataServer(t, "helm-role")
+
+		p, err := NewCredentialsProvider(Config{
+			Credentials: CredentialsConfig{
+				Providers:        []string{ProviderECSRAMRole},
+				MetadataEndpoint: srv.URL,
+			},
+		})
+		require.NoError(t, err)
+
+		creds, err := p.GetCredentials(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "ecs-ak", creds.AccessKeyID)
+		assert.Equal(t, "ecs-token", creds.SecurityToken)
+	})
+
+	t.Run("ecs ram role fallback", func(t *testing.T) {
+		srv := newMetadataServer(t, "")
+		t.Setenv(MetadataEndpointEnv, srv.URL)
+
+		lc := &loadedConfig{
+			Config: Config{
+				AccessKeyID:     "ak",
+				AccessKeySecret: "sk",
+				Credentials: CredentialsConfig{
+					Providers: []string{ProviderECSRAMRole, ProviderStatic},
+					RAMRole:   "missing-role",
+				},
+			},
+			sources: map[string]string{},
+		}
+		require.NoError(t, lc.applyEnv())
+		assert.Equal(t, srv.URL, lc.Credentials.MetadataEndpoint)
+		p, err := NewCredentialsProvider(lc.Config)
+		require.NoError(t, err)
+
+		creds, err := p.GetCredentials(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "ak", creds.AccessKeyID)
+	})
+
+	t.Run("invalid metadata endpoint", func(t *testing.T) {
+		_, err := NewCredentialsProvider(Config{
+			Credentials: CredentialsConfig{
+				Providers:        []string{ProviderECSRAMRole},
+				MetadataEndpoint: "100.100.100.200",
+			},
+		})
+		assert.ErrorContains(t, err, "must be an http URL")
+	})
+
+	t.Run("assume role", func(t *testing.T) {
+		srv := newSTSServer(t, func(t *testing.T, r *http.Request) {
+			assert.Equal(t, "AssumeRole", r.Form.Get("Action"))
+			assert.Equal(t, "acs:ram::1:role/helm", r.Form.Get("RoleArn"))
+			assert.Equal(t, "ext", r.Form.Get("ExternalId"))
+			assert.Equal(t, "ak", r.Form.Get("AccessKeyId"))
+			assert.NotEmpty(t, r.Form.Get("Signature"))
+		})
+
+		p, err := NewCredentialsProvider(Config{
+			AccessKeyID:     "ak",
+			AccessKeySecret: "sk",
+			Credentials: CredentialsConfig{
+				RoleARN:     "acs:ram::1:role/helm",
+				ExternalID:  "ext",
+				STSEndpoint: srv.URL,
+			},
+		})
+		require.NoError(t, err)
+
+		creds, err := p.GetCredentials(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "sts-ak", creds.AccessKeyID)
+		assert.Equal(t, "sts-token", creds.SecurityToken)
+	})
+
+	t.Run("oidc", func(t *testing.T) {
+		tokenFile := filepath.Join(t.TempDir(), "token")
+		require.NoError(t, os.WriteFile(tokenFile, []byte("oidc-token"), 0o600))
+
+		srv := newSTSServer(t, func(t *testing.T, r *http.Request) {
+			assert.Equal(t, "AssumeRoleWithOIDC", r.Form.Get("Action"))
+			assert.Equal(t, "acs:ram::1:oidc-provider/ack", r.Form.Get("OIDCProviderArn"))
+			assert.Equal(t, "oidc-token", r.Form.Get("OIDCToken"))
+			assert.Empty(t, r.Form.Get("Signature"))
+		})
+		t.Setenv("ALIBABA_CLOUD_ROLE_ARN", "acs:ram::1:role/helm")
+		t.Setenv("ALIBABA_CLOUD_OIDC_PROVIDER_ARN", "acs:ram::1:oidc-provider/ack")
+		t.Setenv("ALIBABA_CLOUD_OIDC_TOKEN_FILE", tokenFile)
+
+		lc := &loadedConfig{Config: Config{Credentials: CredentialsConfig{STSEndpoint: srv.URL}}, sources: map[string]string{}}
+		require.NoError(t, lc.applyEnv())
+		p, err := NewCredentialsProvider(lc.Config)
+		require.NoError(t, err)
+
+		creds, err := p.GetCredentials(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "sts-ak", creds.AccessKeyID)
+	})
+}
+
+// newMetadataServer starts the ECS metadata service stand-in with the RAM
+// role attached, or with no role if role is empty. The requests are sent to
+// it as to a proxy, so the mux matches the paths of the metadata service.
+func newMetadataServer(t *testing.T, role string) *httptest.Server {
+	const token = "metadata-token"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(token))
+	})
+	mux.HandleFunc("GET /latest/meta-data/ram/security-credentials/{role...}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, token, r.Header.Get("X-aliyun-ecs-metadata-token"))
+		switch r.PathValue("role") {
+		case "":
+			if role == "" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(role))
+		case role:
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"Code":            "Success",
+				"AccessKeyId":     "ecs-ak",
+				"AccessKeySecret": "ecs-sk",
+				"SecurityToken":   "ecs-token",
+				"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newSTSServer starts the STS stand-in with stsCert, which checks the
+// request with check and returns the assumed role credentials.
+func newSTSServer(t *testing.T, check func(t *testing.T, r *http.Request)) *httptest.Server {
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		t.Skip("SSL_CERT_FILE is not used to verify the certificates on " + runtime.GOOS)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		check(t, r)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"RequestId": "1",
+			"Credentials": map[string]any{
+				"AccessKeyId":     "sts-ak",
+				"AccessKeySecret": "sts-sk",
+				"SecurityToken":   "sts-token",
+				"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			},
+		})
+	}))
+	srv.TLS = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{stsCert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestChainProvider(t *testing.T) {
+	ctx := context.Background()
+
+	var failing bool
+	first := credentials.CredentialsProviderFunc(func(context.Context) (credentials.Credentials, error) {
+		if failing {
+			return credentials.Credentials{}, errors.New("refresh failed")
+		}
+		return credentials.Credentials{AccessKeyID: "first"}, nil
+	})
+	second := credentials.NewStaticCredentialsProvider("second", "sk")
+	chain := &chainProvider{
+		names:     []string{"first", "second"},
+		providers: []credentials.CredentialsProvider{first, second},
+		chosen:    -1,
+	}
+
+	creds, err := chain.GetCredentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "first", creds.AccessKeyID)
+	assert.Equal(t, "first", chain.chosenName())
+
+	failing = true
+	creds, err = chain.GetCredentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "second", creds.AccessKeyID)
+	assert.Equal(t, "second", chain.chosenName())
+
+	chain.providers[1] = credentials.CredentialsProviderFunc(func(context.Context) (credentials.Credentials, error) {
+		return credentials.Credentials{}, errors.New("expired")
+	})
+	_, err = chain.GetCredentials(ctx)
+	assert.EqualError(t, err, "no credentials found: second: expired; first: refresh failed")
+}
+
+func TestOptionalSigner(t *testing.T) {
+	ctx := context.Background()
+	s := optionalSigner{&signer.SignerV4{}}
+
+	req, err := http.NewRequest(http.MethodGet, "https://bucket.oss-cn-hangzhou.aliyuncs.com/key", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.Sign(ctx, &signer.SigningContext{Request: req, Credentials: &credentials.Credentials{}}))
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	require.NoError(t, s.Sign(ctx, &signer.SigningContext{
+		Product:     oss.Ptr("oss"),
+		Region:      oss.Ptr("cn-hangzhou"),
+		Bucket:      oss.Ptr("bucket"),
+		Key:         oss.Ptr("key"),
+		Request:     req,
+		Credentials: &credentials.Credentials{AccessKeyID: "ak", AccessKeySecret: "sk"},
+	}))
+	assert.NotEmpty(t, req.Header.Get("Authorization"))
+}
+
+func TestCredentialsHTTPOptions(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+
+	conf := Config{
+		Proxy:          "http://proxy:8080",
+		NoProxy:        "100.100.100.200",
+		ConnectTimeout: Duration(3 * time.Second),
+	}
+
+	opts, err := credentialsHTTPOptions(conf, "https://sts.aliyuncs.com")
+	require.NoError(t, err)
+	assert.Equal(t, &providers.HttpOptions{Proxy: "http://proxy:8080", ConnectTimeout: 3000, ReadTimeout: 10000}, opts)
+
+	opts, err = credentialsHTTPOptions(conf, metadataURL)
+	require.NoError(t, err)
+	assert.Empty(t, opts.Proxy)
+}
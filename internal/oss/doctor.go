@@ -0,0 +1,209 @@
+package oss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss"
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/credentials"
+	"helm-oss/internal/helmutil"
+)
+
+// Statuses of the doctor checks.
+const (
+	CheckOK      = "ok"
+	CheckWarning = "warning"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// Check is the result of a doctor check.
+type Check struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+
+	// Hint is the remediation of the problem found by the check.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Doctor checks that the repository is accessible with the configuration:
+// the credentials, the endpoint reachability, the bucket existence and the
+// read and write permissions. The write check puts and deletes a temporary
+// object in the repository.
+func (s *Storage) Doctor(ctx context.Context, repoURI string) []Check {
+	var checks []Check
+	add := func(c Check) bool {
+		checks = append(checks, c)
+		return c.Status != CheckFailed
+	}
+	skip := func(names ...string) []Check {
+		for _, name := range names {
+			checks = append(checks, Check{Name: name, Status: CheckSkipped, Message: "skipped after the failed check"})
+		}
+		return checks
+	}
+
+	if !add(s.checkCredentials(ctx)) {
+		return skip("endpoint", "bucket", "read", "write")
+	}
+	if !add(s.checkEndpoint(ctx, repoURI)) {
+		return skip("bucket", "read", "write")
+	}
+	if !add(s.checkBucket(ctx, repoURI)) {
+		return skip("read", "write")
+	}
+	add(s.checkRead(ctx, repoURI))
+	add(s.checkWrite(ctx, repoURI))
+	return checks
+}
+
+func (s *Storage) checkCredentials(ctx context.Context) Check {
+	c := Check{Name: "credentials"}
+
+	if _, ok := s.provider.(*credentials.AnonymousCredentialsProvider); ok {
+		c.Status, c.Message = CheckOK, "anonymous access, requests are not signed"
+		return c
+	}
+
+	if _, err := s.provider.GetCredentials(ctx); err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		c.Hint = "Set HELM_OSS_ACCESS_KEY_ID and HELM_OSS_ACCESS_KEY_SECRET, or configure the credential providers " +
+			"in the config file, e.g. `helm oss config set credentials.roleArn ARN`."
+		return c
+	}
+
+	c.Status = CheckOK
+	c.Message = "credentials are available"
+	if chain, ok := s.provider.(*chainProvider); ok {
+		if name := chain.chosenName(); name == ProviderAnonymous {
+			c.Message = "no credentials are available, requests are not signed"
+		} else {
+			c.Message += " from provider " + name
+		}
+	}
+	return c
+}
+
+func (s *Storage) checkEndpoint(ctx context.Context, repoURI string) Check {
+	c := Check{Name: "endpoint"}
+
+	endpoint, err := s.Endpoint(ctx, repoURI)
+	if err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		return c
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		return c
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		c.Status = CheckFailed
+		c.Message = fmt.Sprintf("%s is not reachable: %v", endpoint, err)
+		c.Hint = "Check the endpoint and region in the config and the network connectivity. " +
+			"Internal endpoints (-internal) are only reachable from Alibaba Cloud VPC in the same region."
+		return c
+	}
+	resp.Body.Close()
+
+	c.Status, c.Message = CheckOK, endpoint+" is reachable"
+	return c
+}
+
+func (s *Storage) checkBucket(ctx context.Context, repoURI string) Check {
+	c := Check{Name: "bucket"}
+
+	client, bucket, key, err := s.resolve(ctx, repoURI)
+	if err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		return c
+	}
+
+	_, err = client.ListObjectsV2(ctx, &oss.ListObjectsV2Request{
+		Bucket:  oss.Ptr(bucket),
+		Prefix:  oss.Ptr(key),
+		MaxKeys: 1,
+	})
+	if err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		c.Hint = errorHint(err, "oss:ListObjects")
+		return c
+	}
+
+	c.Status, c.Message = CheckOK, fmt.Sprintf("bucket %s exists and can be listed", bucket)
+	return c
+}
+
+func (s *Storage) checkRead(ctx context.Context, repoURI string) Check {
+	c := Check{Name: "read"}
+
+	_, _, err := s.FetchRaw(ctx, helmutil.IndexFileURL(repoURI))
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		c.Status, c.Message = CheckWarning, "index.yaml not found"
+		c.Hint = "Initialize the repository with `helm oss init " + repoURI + "`."
+	case err != nil:
+		c.Status, c.Message = CheckFailed, err.Error()
+		c.Hint = errorHint(err, "oss:GetObject")
+	default:
+		c.Status, c.Message = CheckOK, "index.yaml is readable"
+	}
+	return c
+}
+
+func (s *Storage) checkWrite(ctx context.Context, repoURI string) Check {
+	c := Check{Name: "write"}
+
+	uri := helmutil.JoinURL(repoURI, ".helm-oss.doctor-"+newID())
+	if err := s.createObject(ctx, uri, []byte("helm-oss doctor")); err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		c.Hint = errorHint(err, "oss:PutObject")
+		return c
+	}
+	if err := s.deleteObject(ctx, uri); err != nil {
+		c.Status, c.Message = CheckFailed, err.Error()
+		c.Hint = errorHint(err, "oss:DeleteObject")
+		return c
+	}
+
+	c.Status, c.Message = CheckOK, "objects can be created and deleted"
+	return c
+}
+
+// errorHint returns the remediation of the OSS request error. permission is
+// the RAM action required by the request.
+func errorHint(err error, permission string) string {
+	var serviceErr *oss.ServiceError
+	if !errors.As(err, &serviceErr) {
+		return "Check the endpoint and the network connectivity."
+	}
+
+	switch serviceErr.Code {
+	case "NoSuchBucket":
+		return "Check the bucket name in the repository URI, or create the bucket."
+	case "InvalidAccessKeyId":
+		return "The access key ID does not exist. Check accessKeyID or HELM_OSS_ACCESS_KEY_ID."
+	case "SignatureDoesNotMatch":
+		return "The access key secret does not match. Check accessKeySecret or HELM_OSS_ACCESS_KEY_SECRET."
+	case "SecurityTokenExpired", "InvalidSecurityToken":
+		return "The session token is expired or invalid. Refresh HELM_OSS_SESSION_TOKEN, " +
+			"or use a credential provider which refreshes it."
+	case "AccessDenied":
+		if strings.Contains(serviceErr.Message, "endpoint") {
+			return "The bucket is in another region. Add ?region=REGION to the repository URI, or set the region in the config."
+		}
+		return fmt.Sprintf("Grant %s on the repository to the RAM user or role in use.", permission)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,451 @@
+package oss
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"helm-oss/internal/helmutil"
+)
+
+// FileBackend is a Backend that keeps the repository in a local directory,
+// for example on a shared NFS mount.
+// URIs must be in the form of file protocol: file:///path/to/dir[...].
+//
+// Chart metadata is not stored separately, so Traverse loads every chart
+// archive. The index is created exclusively, but its conditional replacement
+// is best-effort: concurrent writers are expected to be serialised with the
+// repository lock.
+type FileBackend struct{}
+
+// NewFileBackend returns a new FileBackend.
+func NewFileBackend() *FileBackend {
+	return &FileBackend{}
+}
+
+// Traverse traverses all charts in the repository. The charts are read from
+// the local disk one by one, so concurrency is ignored.
+func (b *FileBackend) Traverse(ctx context.Context, repoURI string, _ int) (<-chan ChartInfo, <-chan error) {
+	charts := make(chan ChartInfo, 1)
+	errs := make(chan error, 1)
+	go b.traverse(ctx, repoURI, charts, errs)
+	return charts, errs
+}
+
+// LoadChart returns the information about the chart file by uri.
+func (b *FileBackend) LoadChart(ctx context.Context, uri string) (ChartInfo, error) {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return ChartInfo{}, err
+	}
+
+	return chartInfoFromFile(fpath)
+}
+
+// FetchRaw reads the file by uri and returns its content along with the ETag.
+func (b *FileBackend) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, "", ErrObjectNotFound
+		}
+		return nil, "", fmt.Errorf("read file: %w", err)
+	}
+
+	return data, contentETag(data), nil
+}
+
+// Open opens the file by uri for reading.
+func (b *FileBackend) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(fpath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	return f, nil
+}
+
+// Exists returns true if a file exists by uri.
+func (b *FileBackend) Exists(ctx context.Context, uri string) (bool, error) {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(fpath)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, fmt.Errorf("stat file: %w", err)
+	}
+}
+
+// StatObject returns the information about the file by uri. Files have no
+// metadata, and their ETag is not computed.
+func (b *FileBackend) StatObject(ctx context.Context, uri string) (ObjectInfo, error) {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(fpath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("stat file: %w", err)
+	}
+
+	return ObjectInfo{
+		URI:          uri,
+		Size:         info.Size(),
+		LastModified: info.ModTime().UTC(),
+	}, nil
+}
+
+// PutIndex conditionally writes the index file to the repository directory.
+func (b *FileBackend) PutIndex(ctx context.Context, uri string, etag string, r io.Reader) error {
+	uri = helmutil.IndexFileURL(uri)
+
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return err
+	}
+
+	if etag == "" {
+		err := createFileAtomic(fpath, r, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			return ErrIndexConflict
+		}
+		return err
+	}
+
+	_, currentETag, err := b.FetchRaw(ctx, uri)
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+		return ErrIndexConflict
+	case err != nil:
+		return err
+	case etag != currentETag:
+		return ErrIndexConflict
+	}
+
+	return writeFileAtomic(fpath, r, 0o644)
+}
+
+// PutObject writes the file by uri.
+func (b *FileBackend) PutObject(ctx context.Context, uri string, r io.Reader) error {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(fpath, r, 0o644)
+}
+
+// PutChart writes the chart file by uri, and the provenance file next to it
+// if prov is true.
+func (b *FileBackend) PutChart(
+	ctx context.Context,
+	uri string,
+	r io.Reader,
+	chartMeta string,
+	chartDigest string,
+	contentType string,
+	prov bool,
+	provReader io.Reader,
+) (string, error) {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFileAtomic(fpath, r, 0o644); err != nil {
+		return "", fmt.Errorf("write chart file: %w", err)
+	}
+
+	if prov {
+		if err := writeFileAtomic(fpath+".prov", provReader, 0o644); err != nil {
+			return "", fmt.Errorf("write prov file: %w", err)
+		}
+	}
+
+	return "", nil
+}
+
+// DeleteChart deletes the chart file by uri. Also deletes .prov file if exists.
+func (b *FileBackend) DeleteChart(ctx context.Context, uri string) error {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{fpath, fpath + ".prov"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("delete chart file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AcquireLock creates the repository lock file. The file is created
+// exclusively, so only one process can hold the lock at a time.
+func (b *FileBackend) AcquireLock(ctx context.Context, repoURI string, lock Lock) error {
+	return acquireLock(ctx, b, repoURI, lock)
+}
+
+// ReadLock returns the current holder of the repository lock.
+func (b *FileBackend) ReadLock(ctx context.Context, repoURI string) (Lock, bool, error) {
+	return readLock(ctx, b, repoURI)
+}
+
+// RenewLock extends the repository lock held with the lock id.
+func (b *FileBackend) RenewLock(ctx context.Context, repoURI string, id string, ttl time.Duration) error {
+	return renewLock(ctx, b, repoURI, id, ttl)
+}
+
+// ReleaseLock removes the repository lock if it is still held with the lock id.
+func (b *FileBackend) ReleaseLock(ctx context.Context, repoURI string, id string) error {
+	return releaseLock(ctx, b, repoURI, id)
+}
+
+// BreakLock removes the repository lock held with the lock id, even if it
+// has not expired, along with its lease.
+func (b *FileBackend) BreakLock(ctx context.Context, repoURI string, id string) error {
+	return breakLock(ctx, b, repoURI, id)
+}
+
+// WriteJournal records the operation before it modifies the repository.
+func (b *FileBackend) WriteJournal(ctx context.Context, repoURI string, journal Journal) error {
+	return writeJournal(ctx, b, repoURI, journal)
+}
+
+// ListJournals returns the journals of all operations that have not been completed.
+func (b *FileBackend) ListJournals(ctx context.Context, repoURI string) ([]Journal, error) {
+	return listJournals(ctx, b, repoURI)
+}
+
+// CompleteJournal marks the operation with the id as completed.
+func (b *FileBackend) CompleteJournal(ctx context.Context, repoURI string, id string) error {
+	return b.deleteObject(ctx, JournalURL(repoURI, id))
+}
+
+// traverse traverses all charts in the repository directory.
+// It writes an info item about every chart to items, and errors to errs.
+// It always closes both channels when returns.
+func (b *FileBackend) traverse(ctx context.Context, repoURI string, items chan<- ChartInfo, errs chan<- error) {
+	defer close(items)
+	defer close(errs)
+
+	dir, err := parseFileURI(repoURI)
+	if err != nil {
+		errs <- err
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		errs <- fmt.Errorf("read repository directory: %w", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !isChartKey(entry.Name()) {
+			continue
+		}
+
+		item, err := chartInfoFromFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		select {
+		case items <- item:
+		case <-ctx.Done():
+			errs <- ctx.Err()
+			return
+		}
+	}
+}
+
+// createObject creates the file by uri only if it does not exist yet.
+func (b *FileBackend) createObject(ctx context.Context, uri string, data []byte) error {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fpath), 0o755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	f, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return errObjectExists
+		}
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write file: %w", err)
+	}
+
+	return f.Close()
+}
+
+// deleteObject deletes the file by uri.
+func (b *FileBackend) deleteObject(ctx context.Context, uri string) error {
+	fpath, err := parseFileURI(uri)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fpath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete file: %w", err)
+	}
+
+	return nil
+}
+
+// ListObjects returns all files in the directory by dirURI.
+// The ETag of the files is not computed.
+func (b *FileBackend) ListObjects(ctx context.Context, dirURI string) ([]ObjectInfo, error) {
+	dir, err := parseFileURI(dirURI)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	var objects []ObjectInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("stat file: %w", err)
+		}
+
+		objects = append(objects, ObjectInfo{
+			URI:          strings.TrimSuffix(dirURI, "/") + "/" + entry.Name(),
+			Size:         info.Size(),
+			LastModified: info.ModTime().UTC(),
+		})
+	}
+
+	return objects, nil
+}
+
+func chartInfoFromFile(fpath string) (ChartInfo, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("open chart file: %w", err)
+	}
+	defer f.Close()
+
+	return chartInfoFromArchive(filepath.Base(fpath), f)
+}
+
+// writeFileAtomic writes the file by writing to a temporary file first and
+// renaming it, so that readers never observe a partially written file.
+func writeFileAtomic(fpath string, r io.Reader, perm os.FileMode) error {
+	tmp, err := writeTempFile(fpath, r, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Rename(tmp, fpath)
+}
+
+// createFileAtomic creates the file as writeFileAtomic does, but only if it
+// does not exist yet, otherwise the error matches fs.ErrExist. The temporary
+// file is hard linked to the path, which fails if the path exists, so only
+// one of the concurrent creations succeeds.
+func createFileAtomic(fpath string, r io.Reader, perm os.FileMode) error {
+	tmp, err := writeTempFile(fpath, r, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Link(tmp, fpath)
+}
+
+// writeTempFile writes the temporary file next to the file path and returns
+// its path.
+func writeTempFile(fpath string, r io.Reader, perm os.FileMode) (string, error) {
+	dir := filepath.Dir(fpath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fpath)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("create temporary file: %w", err)
+	}
+
+	err = func() error {
+		if _, err := io.Copy(tmp, r); err != nil {
+			tmp.Close()
+			return fmt.Errorf("write temporary file: %w", err)
+		}
+		if err := tmp.Close(); err != nil {
+			return fmt.Errorf("close temporary file: %w", err)
+		}
+		if err := os.Chmod(tmp.Name(), perm); err != nil {
+			return fmt.Errorf("chmod temporary file: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func parseFileURI(uri string) (string, error) {
+	fpath, ok := strings.CutPrefix(uri, "file://")
+	if !ok {
+		return "", fmt.Errorf("uri %s protocol is not file", uri)
+	}
+	if fpath == "" {
+		return "", fmt.Errorf("uri %s has empty path", uri)
+	}
+
+	return filepath.FromSlash(fpath), nil
+}
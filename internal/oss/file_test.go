@@ -0,0 +1,102 @@
+package oss
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileBackend(t *testing.T) {
+	ctx := context.Background()
+	b := NewFileBackend()
+	dir := t.TempDir()
+	repo := "file://" + filepath.ToSlash(dir) + "/charts"
+
+	exists, err := IndexExists(ctx, b, repo)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, b.PutIndex(ctx, repo, "", strings.NewReader("v1")))
+	assert.ErrorIs(t, b.PutIndex(ctx, repo, "", strings.NewReader("v1")), ErrIndexConflict)
+
+	_, etag, err := b.FetchRaw(ctx, repo+"/index.yaml")
+	require.NoError(t, err)
+
+	r, err := b.Open(ctx, repo+"/index.yaml")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "v1", string(data))
+
+	_, err = b.Open(ctx, repo+"/missing.yaml")
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+
+	require.NoError(t, b.PutIndex(ctx, repo, etag, strings.NewReader("v2")))
+	assert.ErrorIs(t, b.PutIndex(ctx, repo, etag, strings.NewReader("v3")), ErrIndexConflict)
+
+	chart, err := os.Open("../../testdata/foo-1.2.3.tgz")
+	require.NoError(t, err)
+	defer chart.Close()
+
+	_, err = b.PutChart(ctx, repo+"/foo-1.2.3.tgz", chart, "", "", "application/gzip", true, strings.NewReader("prov"))
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "charts", "foo-1.2.3.tgz.prov"))
+
+	items, errs := b.Traverse(ctx, repo, DefaultConcurrency)
+	var found []string
+	for item := range items {
+		found = append(found, item.Filename)
+	}
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"foo-1.2.3.tgz"}, found)
+
+	require.NoError(t, b.DeleteChart(ctx, repo+"/foo-1.2.3.tgz"))
+	assert.NoFileExists(t, filepath.Join(dir, "charts", "foo-1.2.3.tgz"))
+	assert.NoFileExists(t, filepath.Join(dir, "charts", "foo-1.2.3.tgz.prov"))
+}
+
+func TestFileBackend_CreateIndexConcurrently(t *testing.T) {
+	ctx := context.Background()
+	b := NewFileBackend()
+	repo := "file://" + filepath.ToSlash(t.TempDir()) + "/charts"
+
+	const n = 10
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Go(func() {
+			errs[i] = b.PutIndex(ctx, repo, "", strings.NewReader(fmt.Sprintf("v%d", i)))
+		})
+	}
+	wg.Wait()
+
+	created := -1
+	for i, err := range errs {
+		if err == nil {
+			assert.Equal(t, -1, created, "only one creation must succeed")
+			created = i
+			continue
+		}
+		assert.ErrorIs(t, err, ErrIndexConflict)
+	}
+	require.NotEqual(t, -1, created, "one creation must succeed")
+
+	data, _, err := b.FetchRaw(ctx, repo+"/index.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("v%d", created), string(data))
+
+	entries, err := os.ReadDir(strings.TrimPrefix(repo, "file://"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "temporary files must be removed")
+}
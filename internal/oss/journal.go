@@ -0,0 +1,160 @@
+package oss
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"helm-oss/internal/helmutil"
+)
+
+// journalDirName is the name of the directory holding the operation
+// journal. It is stored next to index.yaml.
+const journalDirName = ".helm-oss.journal"
+
+// Journal records a mutating repository operation. It is written before the
+// operation touches any chart object and removed once the index is updated,
+// so a journal that is still present belongs to an operation that did not
+// finish.
+type Journal struct {
+	// ID uniquely identifies the operation.
+	ID string `json:"id"`
+
+	// Operation is the name of the recorded operation.
+	// Example: "push".
+	Operation string `json:"operation"`
+
+	// Owner is the name of the user who performs the operation.
+	Owner string `json:"owner"`
+
+	// Host is the name of the host where the operation runs.
+	Host string `json:"host"`
+
+	// PID is the process ID of the operation.
+	PID int `json:"pid"`
+
+	// Started is the time when the operation started.
+	Started time.Time `json:"started"`
+
+	// LockID is the ID of the repository lock held by the operation.
+	// The operation is in progress as long as the lock is held.
+	LockID string `json:"lockId,omitempty"`
+
+	// Chart is the chart affected by the operation.
+	// It is nil for operations on the whole repository, e.g. reindex.
+	Chart *JournalChart `json:"chart,omitempty"`
+}
+
+// JournalChart describes the chart affected by the journaled operation.
+type JournalChart struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+
+	// URL is the chart URL, either absolute or relative to the repository.
+	URL string `json:"url"`
+
+	// Digest is the digest of the uploaded chart archive.
+	// It is empty for operations that do not upload the chart.
+	Digest string `json:"digest,omitempty"`
+
+	// Prov is true if the chart is uploaded with its provenance file.
+	Prov bool `json:"prov,omitempty"`
+}
+
+// NewJournal returns a journal of the operation for the current process.
+func NewJournal(operation string, chart *JournalChart) Journal {
+	return Journal{
+		ID:        newID(),
+		Owner:     currentOwner(),
+		Host:      currentHost(),
+		PID:       os.Getpid(),
+		Operation: operation,
+		Started:   time.Now().UTC(),
+		Chart:     chart,
+	}
+}
+
+// String returns the short description of the journaled operation.
+func (j Journal) String() string {
+	desc := j.Operation
+	if j.Chart != nil {
+		desc += fmt.Sprintf(" %s %s", j.Chart.Name, j.Chart.Version)
+	}
+	return fmt.Sprintf(
+		"%s (id %s, started by %s@%s pid %d at %s)",
+		desc, j.ID, j.Owner, j.Host, j.PID, j.Started.Format(time.RFC3339),
+	)
+}
+
+// JournalURL returns the URL of the journal object with the id for the
+// provided repository URL.
+func JournalURL(repoURI string, id string) string {
+	return helmutil.JoinURL(repoURI, journalDirName, id+".json")
+}
+
+func journalDirURL(repoURI string) string {
+	return helmutil.JoinURL(repoURI, journalDirName)
+}
+
+// writeJournal stores the journal object.
+func writeJournal(ctx context.Context, store objectStore, repoURI string, j Journal) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("marshal journal: %w", err)
+	}
+
+	uri := JournalURL(repoURI, j.ID)
+	err = store.createObject(ctx, uri, b)
+	if errors.Is(err, errObjectExists) {
+		// The journal has been created by the request retried after its
+		// response was lost, the ID being unique.
+		existing, _, fetchErr := store.FetchRaw(ctx, uri)
+		if fetchErr == nil && bytes.Equal(existing, b) {
+			return nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("create journal object: %w", err)
+	}
+
+	return nil
+}
+
+// listJournals returns all journals of the repository, oldest first.
+func listJournals(ctx context.Context, store objectStore, repoURI string) ([]Journal, error) {
+	objects, err := store.ListObjects(ctx, journalDirURL(repoURI))
+	if err != nil {
+		return nil, fmt.Errorf("list journal objects: %w", err)
+	}
+
+	journals := make([]Journal, 0, len(objects))
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.URI, ".json") {
+			continue
+		}
+
+		b, _, err := store.FetchRaw(ctx, obj.URI)
+		if err != nil {
+			return nil, fmt.Errorf("fetch journal object: %w", err)
+		}
+
+		var j Journal
+		if err := json.Unmarshal(b, &j); err != nil {
+			return nil, fmt.Errorf("unmarshal journal object %s: %w", obj.URI, err)
+		}
+		journals = append(journals, j)
+	}
+
+	slices.SortFunc(journals, func(a, b Journal) int {
+		return cmp.Or(a.Started.Compare(b.Started), strings.Compare(a.ID, b.ID))
+	})
+
+	return journals, nil
+}
@@ -0,0 +1,395 @@
+package oss
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss"
+)
+
+// Endpoint modes, see Config.EndpointMode.
+const (
+	EndpointPublic     = "public"
+	EndpointInternal   = "internal"
+	EndpointAccelerate = "accelerate"
+	EndpointDualStack  = "dual-stack"
+	EndpointCNAME      = "cname"
+)
+
+// bucketLocation is the region and endpoint of a bucket.
+type bucketLocation struct {
+	Region   string
+	Endpoint string
+
+	// Mode is the endpoint mode. If it is empty, the endpoint is used as is.
+	Mode string
+}
+
+// Patterns of the standard OSS endpoints, which can be switched to another
+// region or endpoint mode.
+var (
+	// accelerateEndpointPattern matches the transfer acceleration endpoints,
+	// which serve the buckets of all regions.
+	accelerateEndpointPattern = regexp.MustCompile(`^(https?://)?oss-accelerate(-overseas)?\.aliyuncs\.com/?$`)
+
+	// dualStackEndpointPattern matches the dual-stack endpoints, e.g.
+	// cn-hangzhou.oss.aliyuncs.com.
+	dualStackEndpointPattern = regexp.MustCompile(`^(https?://)?([a-z0-9-]+)\.oss\.aliyuncs\.com/?$`)
+
+	// regionEndpointPattern matches the public and internal endpoints, e.g.
+	// oss-cn-hangzhou.aliyuncs.com and oss-cn-hangzhou-internal.aliyuncs.com.
+	regionEndpointPattern = regexp.MustCompile(`^(https?://)?oss-([a-z0-9-]+?)(-internal)?\.aliyuncs\.com/?$`)
+)
+
+// parseEndpoint returns the scheme, which may be empty, the region and the
+// mode of the standard OSS endpoint. The region is empty for the transfer
+// acceleration endpoints. It returns false if the endpoint is custom.
+func parseEndpoint(endpoint string) (scheme, region, mode string, ok bool) {
+	if m := accelerateEndpointPattern.FindStringSubmatch(endpoint); m != nil {
+		return m[1], "", EndpointAccelerate, true
+	}
+	if m := dualStackEndpointPattern.FindStringSubmatch(endpoint); m != nil {
+		return m[1], m[2], EndpointDualStack, true
+	}
+	if m := regionEndpointPattern.FindStringSubmatch(endpoint); m != nil {
+		if m[3] != "" {
+			return m[1], m[2], EndpointInternal, true
+		}
+		return m[1], m[2], EndpointPublic, true
+	}
+	return "", "", "", false
+}
+
+// resolve parses the URI and returns the client for its bucket.
+//
+// The bucket location is taken, in order of precedence, from the region,
+// endpoint and endpointMode URI query parameters, e.g.
+// oss://bucket/charts?region=cn-beijing&endpointMode=internal, from the
+// previously resolved URIs of the same bucket, from the local bucket region
+// cache and from GetBucketLocation. If none of these applies, the configured
+// region and endpoint are used. The location is dropped if OSS rejects a
+// request as sent to another region, see regionCheckingTransport.
+func (s *Storage) resolve(ctx context.Context, uri string) (client *oss.Client, bucket, key string, err error) {
+	bucket, key, err = parseURI(uri)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("parse uri %s: %w", uri, err)
+	}
+	query := u.Query()
+
+	region, endpoint, mode := normalizeRegion(query.Get("region")), query.Get("endpoint"), query.Get("endpointMode")
+	if region != "" || endpoint != "" || mode != "" {
+		loc := bucketLocation{Region: region, Endpoint: endpoint, Mode: cmp.Or(mode, s.conf.EndpointMode)}
+		if loc.Endpoint == "" {
+			loc.Endpoint = s.conf.Endpoint
+			if region != "" {
+				loc.Endpoint, _ = regionEndpoint(s.conf.Endpoint, region)
+			}
+		}
+		if loc.Region == "" {
+			loc.Region = normalizeRegion(s.conf.Region)
+		}
+		if err := loc.validate(); err != nil {
+			return nil, "", "", fmt.Errorf("uri %s: %w", uri, err)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.bucketClient(bucket, loc), bucket, key, nil
+	}
+
+	if client, ok := s.resolvedClient(bucket); ok {
+		return client, bucket, key, nil
+	}
+
+	// The region is discovered without holding s.mu, and only once for the
+	// concurrent requests to the same bucket.
+	v, _, _ := s.discovery.Do(bucket, func() (any, error) {
+		return s.discoverRegion(ctx, bucket), nil
+	})
+
+	loc := defaultLocation(s.conf)
+	if region := v.(string); region != "" && region != normalizeRegion(s.conf.Region) {
+		endpoint, _ = regionEndpoint(s.conf.Endpoint, region)
+		loc = bucketLocation{Region: region, Endpoint: endpoint, Mode: s.conf.EndpointMode}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resolved, ok := s.buckets[bucket]; ok {
+		// The bucket has been resolved meanwhile, e.g. with the URI query.
+		loc = resolved
+	}
+	return s.bucketClient(bucket, loc), bucket, key, nil
+}
+
+// resolvedClient returns the client for the bucket, if its location is
+// already resolved.
+func (s *Storage) resolvedClient(bucket string) (*oss.Client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loc, ok := s.buckets[bucket]
+	if !ok {
+		return nil, false
+	}
+	return s.locations[loc], true
+}
+
+// forgetLocation drops the resolved location of the bucket, if it is still
+// loc, along with its cached region, so that the region is discovered again
+// by the next request.
+func (s *Storage) forgetLocation(bucket string, loc bucketLocation) {
+	s.mu.Lock()
+	if s.buckets[bucket] == loc {
+		delete(s.buckets, bucket)
+	}
+	s.mu.Unlock()
+
+	cache := loadRegionCache()
+	if _, ok := cache[bucket]; ok {
+		delete(cache, bucket)
+		saveRegionCache(cache)
+	}
+	s.logger.Debug("bucket is in another region, its location is dropped", "bucket", bucket, "region", loc.Region)
+}
+
+// bucketClient returns the client for the bucket location, and uses it for
+// the bucket from now on. s.mu must be held.
+func (s *Storage) bucketClient(bucket string, loc bucketLocation) *oss.Client {
+	s.buckets[bucket] = loc
+	if c, ok := s.locations[loc]; ok {
+		return c
+	}
+
+	c := s.newClient(loc)
+	s.locations[loc] = c
+	return c
+}
+
+// defaultLocation returns the configured location.
+func defaultLocation(conf Config) bucketLocation {
+	return bucketLocation{Region: conf.Region, Endpoint: conf.Endpoint, Mode: conf.EndpointMode}
+}
+
+// validate checks the endpoint mode of the location.
+func (loc bucketLocation) validate() error {
+	switch loc.Mode {
+	case "", EndpointPublic, EndpointInternal, EndpointAccelerate, EndpointDualStack:
+		return nil
+	case EndpointCNAME:
+		if loc.Endpoint == "" {
+			return errors.New("endpoint mode cname requires the endpoint, the domain bound to the bucket")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown endpoint mode %q, must be one of %s, %s, %s, %s, %s",
+			loc.Mode, EndpointPublic, EndpointInternal, EndpointAccelerate, EndpointDualStack, EndpointCNAME)
+	}
+}
+
+// endpoint returns the endpoint of the location. The standard OSS endpoints,
+// and the empty one, are switched to the endpoint mode of the location. It
+// returns an empty string if the endpoint is to be derived from the region by
+// the client.
+func (loc bucketLocation) endpoint() string {
+	if loc.Mode == "" || loc.Mode == EndpointCNAME {
+		return loc.Endpoint
+	}
+
+	scheme := "https://"
+	if loc.Endpoint != "" {
+		endpointScheme, _, mode, ok := parseEndpoint(loc.Endpoint)
+		if !ok || mode == EndpointAccelerate && loc.Mode == EndpointAccelerate {
+			// The custom endpoint, or the acceleration endpoint of the
+			// location's mode, e.g. the overseas one, is used as is.
+			return loc.Endpoint
+		}
+		scheme = cmp.Or(endpointScheme, scheme)
+	}
+	return scheme + modeEndpoint(loc.Region, loc.Mode)
+}
+
+// modeEndpoint returns the endpoint host of the region for the mode.
+func modeEndpoint(region, mode string) string {
+	region = normalizeRegion(region)
+	switch mode {
+	case EndpointInternal:
+		return "oss-" + region + "-internal.aliyuncs.com"
+	case EndpointAccelerate:
+		return "oss-accelerate.aliyuncs.com"
+	case EndpointDualStack:
+		return region + ".oss.aliyuncs.com"
+	default:
+		return "oss-" + region + ".aliyuncs.com"
+	}
+}
+
+// Endpoint returns the URL of the endpoint serving the bucket of the URI.
+func (s *Storage) Endpoint(ctx context.Context, uri string) (string, error) {
+	_, bucket, _, err := s.resolve(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loc, ok := s.buckets[bucket]
+	if !ok {
+		// The location has been dropped meanwhile.
+		loc = defaultLocation(s.conf)
+	}
+	return endpointURL(loc), nil
+}
+
+// endpointURL returns the URL of the location endpoint, deriving it from the
+// region as the client does if the endpoint is not set.
+func endpointURL(loc bucketLocation) string {
+	endpoint := loc.endpoint()
+	if endpoint == "" {
+		endpoint = modeEndpoint(loc.Region, EndpointPublic)
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	return endpoint
+}
+
+// wrongRegionEC is the error code returned by OSS for the requests sent to
+// the endpoint of another region than the bucket's.
+const wrongRegionEC = "0003-00000001"
+
+// regionCheckingTransport calls forget with the bucket of the requests which
+// OSS rejects as sent to the endpoint of another region.
+type regionCheckingTransport struct {
+	base http.RoundTripper
+
+	// host is the endpoint host, used to tell the bucket from the request URL.
+	host   string
+	forget func(bucket string)
+}
+
+func (t *regionCheckingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusForbidden && resp.Header.Get("X-Oss-Ec") == wrongRegionEC {
+		if bucket, _ := requestObject(t.host, req.URL); bucket != "" {
+			t.forget(bucket)
+		}
+	}
+	return resp, nil
+}
+
+// discoverRegion returns the region of the bucket from the cache or
+// GetBucketLocation. It returns an empty string if the region cannot be
+// discovered, e.g. the endpoint is custom or the permission is missing.
+func (s *Storage) discoverRegion(ctx context.Context, bucket string) string {
+	if s.conf.DisableRegionDiscovery {
+		return ""
+	}
+	if _, ok := regionEndpoint(s.conf.Endpoint, ""); !ok {
+		return ""
+	}
+
+	cache := loadRegionCache()
+	if region, ok := cache[bucket]; ok {
+		return region
+	}
+
+	out, err := s.client.GetBucketLocation(ctx, &oss.GetBucketLocationRequest{Bucket: oss.Ptr(bucket)})
+	if err != nil {
+		return ""
+	}
+	region := normalizeRegion(oss.ToString(out.LocationConstraint))
+	if region == "" {
+		return ""
+	}
+
+	cache[bucket] = region
+	saveRegionCache(cache)
+	return region
+}
+
+// regionEndpoint returns the endpoint of the region, keeping the scheme and
+// the mode of the configured endpoint. The acceleration endpoints serve all
+// regions, so they are returned as is. If the configured endpoint is empty,
+// the endpoint is derived from the region by the client, so an empty string
+// is returned. It returns false if the configured endpoint is custom and
+// cannot be switched to another region.
+func regionEndpoint(endpoint string, region string) (string, bool) {
+	if endpoint == "" {
+		return "", true
+	}
+
+	scheme, _, mode, ok := parseEndpoint(endpoint)
+	if !ok {
+		return "", false
+	}
+	if mode == EndpointAccelerate {
+		return endpoint, true
+	}
+	return scheme + modeEndpoint(region, mode), true
+}
+
+// normalizeRegion returns the region ID without the "oss-" prefix, which is
+// used by GetBucketLocation.
+// Example: "oss-cn-hangzhou" -> "cn-hangzhou".
+func normalizeRegion(region string) string {
+	return strings.TrimPrefix(region, "oss-")
+}
+
+// regionCachePath returns the path to the bucket region cache. Bucket names
+// are globally unique, so the cache is keyed by the bucket name only.
+func regionCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "helm-oss", "bucket-regions.json"), nil
+}
+
+// loadRegionCache returns the bucket region cache. The cache is only an
+// optimization, so it is empty if it cannot be read.
+func loadRegionCache() map[string]string {
+	cache := make(map[string]string)
+
+	path, err := regionCachePath()
+	if err != nil {
+		return cache
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	_ = json.Unmarshal(b, &cache)
+	return cache
+}
+
+// saveRegionCache stores the bucket region cache, ignoring errors.
+func saveRegionCache(cache map[string]string) {
+	path, err := regionCachePath()
+	if err != nil {
+		return
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	_ = writeFileAtomic(path, bytes.NewReader(b), 0o644)
+}
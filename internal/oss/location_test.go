@@ -0,0 +1,257 @@
+package oss
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/osstest"
+)
+
+func TestRegionEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantOK   bool
+	}{
+		{endpoint: "", want: "", wantOK: true},
+		{endpoint: "https://oss-cn-hangzhou.aliyuncs.com", want: "https://oss-ap-southeast-1.aliyuncs.com", wantOK: true},
+		{endpoint: "oss-cn-hangzhou-internal.aliyuncs.com", want: "oss-ap-southeast-1-internal.aliyuncs.com", wantOK: true},
+		{endpoint: "https://oss-accelerate.aliyuncs.com", want: "https://oss-accelerate.aliyuncs.com", wantOK: true},
+		{endpoint: "oss-accelerate-overseas.aliyuncs.com", want: "oss-accelerate-overseas.aliyuncs.com", wantOK: true},
+		{endpoint: "http://cn-hangzhou.oss.aliyuncs.com", want: "http://ap-southeast-1.oss.aliyuncs.com", wantOK: true},
+		{endpoint: "https://charts.example.com", want: "", wantOK: false},
+	}
+	for _, tc := range tests {
+		got, ok := regionEndpoint(tc.endpoint, "ap-southeast-1")
+		assert.Equal(t, tc.wantOK, ok, tc.endpoint)
+		assert.Equal(t, tc.want, got, tc.endpoint)
+	}
+}
+
+func TestStorage_URIQuery(t *testing.T) {
+	// The other bucket is served by a separate server, which is only
+	// reachable with the endpoint from the URI.
+	other := osstest.NewServer()
+	t.Cleanup(other.Close)
+	other.CreateBucket("other-bucket")
+
+	osstest.NewTestServer(t, "test-bucket")
+	ctx := context.Background()
+
+	conf, err := LoadConfig("oss://test-bucket/charts", ConfigOptions{})
+	require.NoError(t, err)
+	s, err := New(conf)
+	require.NoError(t, err)
+
+	repo := "oss://other-bucket/charts?region=ap-southeast-1&endpoint=" + url.QueryEscape(other.URL)
+	require.NoError(t, s.PutIndex(ctx, repo, "", strings.NewReader("v1")))
+	assert.Equal(t, []string{"charts/index.yaml"}, other.Keys("other-bucket"))
+
+	data, _, err := s.FetchRaw(ctx, "oss://other-bucket/charts/index.yaml?endpoint="+url.QueryEscape(other.URL))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	// Once resolved, the bucket location is used for URIs without query,
+	// e.g. the ones returned by ListObjects.
+	objects, err := s.ListObjects(ctx, "oss://other-bucket/charts")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "oss://other-bucket/charts/index.yaml", objects[0].URI)
+
+	// Buckets without query use the configured endpoint.
+	require.NoError(t, s.PutIndex(ctx, "oss://test-bucket/charts", "", strings.NewReader("v2")))
+}
+
+func TestBucketLocation_endpoint(t *testing.T) {
+	tests := []struct {
+		loc  bucketLocation
+		want string
+	}{
+		{loc: bucketLocation{Region: "cn-hangzhou"}, want: ""},
+		{loc: bucketLocation{Region: "cn-hangzhou", Mode: EndpointPublic}, want: "https://oss-cn-hangzhou.aliyuncs.com"},
+		{loc: bucketLocation{Region: "oss-cn-hangzhou", Mode: EndpointInternal}, want: "https://oss-cn-hangzhou-internal.aliyuncs.com"},
+		{loc: bucketLocation{Region: "cn-hangzhou", Mode: EndpointAccelerate}, want: "https://oss-accelerate.aliyuncs.com"},
+		{loc: bucketLocation{Region: "cn-hangzhou", Mode: EndpointDualStack}, want: "https://cn-hangzhou.oss.aliyuncs.com"},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "http://oss-cn-hangzhou.aliyuncs.com", Mode: EndpointInternal},
+			want: "http://oss-cn-hangzhou-internal.aliyuncs.com",
+		},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "oss-cn-hangzhou-internal.aliyuncs.com", Mode: EndpointPublic},
+			want: "https://oss-cn-hangzhou.aliyuncs.com",
+		},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "oss-accelerate-overseas.aliyuncs.com", Mode: EndpointAccelerate},
+			want: "oss-accelerate-overseas.aliyuncs.com",
+		},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "http://oss-accelerate.aliyuncs.com", Mode: EndpointInternal},
+			want: "http://oss-cn-hangzhou-internal.aliyuncs.com",
+		},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "https://cn-hangzhou.oss.aliyuncs.com", Mode: EndpointPublic},
+			want: "https://oss-cn-hangzhou.aliyuncs.com",
+		},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "https://oss.example.com", Mode: EndpointInternal},
+			want: "https://oss.example.com",
+		},
+		{
+			loc:  bucketLocation{Region: "cn-hangzhou", Endpoint: "https://charts.example.com", Mode: EndpointCNAME},
+			want: "https://charts.example.com",
+		},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.want, tc.loc.endpoint(), "%+v", tc.loc)
+	}
+
+	assert.ErrorContains(t, bucketLocation{Mode: EndpointCNAME}.validate(), "requires the endpoint")
+	assert.ErrorContains(t, bucketLocation{Mode: "vpc"}.validate(), `unknown endpoint mode "vpc"`)
+}
+
+func TestStorage_EndpointMode(t *testing.T) {
+	osstest.NewTestServer(t, "test-bucket")
+	ctx := context.Background()
+
+	conf, err := LoadConfig("oss://test-bucket/charts", ConfigOptions{})
+	require.NoError(t, err)
+
+	t.Run("unknown mode", func(t *testing.T) {
+		conf := conf
+		conf.EndpointMode = "vpc"
+		_, err := New(conf)
+		assert.ErrorContains(t, err, "unknown endpoint mode")
+
+		s, err := New(Config{Region: osstest.DefaultRegion, AccessKeyID: "ak", AccessKeySecret: "sk"})
+		require.NoError(t, err)
+		_, _, err = s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml?endpointMode=vpc")
+		assert.ErrorContains(t, err, "unknown endpoint mode")
+	})
+
+	t.Run("cname", func(t *testing.T) {
+		// The domain bound to the bucket serves its objects at the root.
+		var paths []string
+		cname := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			_, _ = w.Write([]byte("v1"))
+		}))
+		t.Cleanup(cname.Close)
+
+		// The client uses path-style requests for IP endpoints.
+		conf := conf
+		conf.Endpoint = strings.Replace(cname.URL, "127.0.0.1", "localhost", 1)
+		conf.EndpointMode = EndpointCNAME
+		s, err := New(conf)
+		require.NoError(t, err)
+
+		data, _, err := s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(data))
+		assert.Equal(t, []string{"/charts/index.yaml"}, paths)
+	})
+}
+
+func TestStorage_DiscoverRegion(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	var (
+		mu sync.Mutex
+		// region is the region of the buckets.
+		region = "cn-beijing"
+		// locations counts GetBucketLocation requests by bucket.
+		locations = map[string]int{}
+	)
+	release := make(chan struct{})
+
+	// The proxy stands in for the endpoints of all regions.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket, host, _ := strings.Cut(r.Host, ".")
+		mu.Lock()
+		want := "oss-" + region + ".aliyuncs.com"
+		mu.Unlock()
+
+		switch {
+		case r.URL.Query().Has("location"):
+			mu.Lock()
+			locations[bucket]++
+			mu.Unlock()
+			if bucket == "slow-bucket" {
+				<-release
+			}
+			mu.Lock()
+			_, _ = fmt.Fprintf(w, "<LocationConstraint>oss-%s</LocationConstraint>", region)
+			mu.Unlock()
+		case host != want:
+			w.Header().Set("X-Oss-Ec", wrongRegionEC)
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+		default:
+			_, _ = w.Write([]byte("v1"))
+		}
+	}))
+	t.Cleanup(proxy.Close)
+
+	s, err := New(Config{
+		Region:          "cn-hangzhou",
+		Endpoint:        "http://oss-cn-hangzhou.aliyuncs.com",
+		AccessKeyID:     "ak",
+		AccessKeySecret: "sk",
+		Proxy:           proxy.URL,
+	})
+	require.NoError(t, err)
+
+	// The discovery of a bucket does not block the requests to the others.
+	slow := make(chan error)
+	go func() {
+		_, _, err := s.FetchRaw(ctx, "oss://slow-bucket/charts/index.yaml")
+		slow <- err
+	}()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return locations["slow-bucket"] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	data, _, err := s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	endpoint, err := s.Endpoint(ctx, "oss://test-bucket/charts")
+	require.NoError(t, err)
+	assert.Equal(t, "http://oss-cn-beijing.aliyuncs.com", endpoint)
+	assert.Equal(t, map[string]string{"test-bucket": "cn-beijing"}, loadRegionCache())
+
+	close(release)
+	require.NoError(t, <-slow)
+
+	// The resolved location is used for the following requests.
+	_, _, err = s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 1, locations["test-bucket"])
+
+	// The location is dropped when the bucket turns out to be in another
+	// region, and discovered again by the next request.
+	mu.Lock()
+	region = "cn-shanghai"
+	mu.Unlock()
+	_, _, err = s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml")
+	require.Error(t, err)
+	assert.NotContains(t, loadRegionCache(), "test-bucket")
+
+	data, _, err = s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+	assert.Equal(t, 2, locations["test-bucket"])
+	assert.Equal(t, "cn-shanghai", loadRegionCache()["test-bucket"])
+}
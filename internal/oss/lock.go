@@ -0,0 +1,419 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"helm-oss/internal/helmutil"
+)
+
+// lockFileName is the name of the repository lock object.
+// It is stored next to index.yaml.
+const lockFileName = ".helm-oss.lock"
+
+// leaseFileName is the name prefix of the objects with the renewed expiry of
+// the repository lock, suffixed with the lock ID. Each lease is only written
+// by the holder of its lock, so it is replaced unconditionally, unlike the
+// lock object itself, and a holder that has lost the lock cannot rewrite the
+// lease of the next one.
+const leaseFileName = ".helm-oss.lock.lease"
+
+var (
+	// ErrLocked is returned when the repository lock is held by someone else.
+	ErrLocked = errors.New("repository is locked")
+
+	// ErrLockLost is returned when the repository lock is renewed, but it is
+	// not held anymore, e.g. it has expired and been taken over.
+	ErrLockLost = errors.New("repository lock was lost")
+)
+
+// errObjectExists is returned by objectStore.createObject when the object
+// already exists.
+var errObjectExists = errors.New("object already exists")
+
+// errObjectChanged is returned by deleteIfMatch when the object has been
+// changed or deleted by someone else.
+var errObjectChanged = errors.New("object was changed")
+
+// Lock describes the holder of the repository lock.
+type Lock struct {
+	// ID uniquely identifies the lock acquisition.
+	ID string `json:"id"`
+
+	// Owner is the name of the user who holds the lock.
+	Owner string `json:"owner"`
+
+	// Host is the name of the host where the lock holder runs.
+	Host string `json:"host"`
+
+	// PID is the process ID of the lock holder.
+	PID int `json:"pid"`
+
+	// Operation is the name of the operation performed under the lock.
+	// Example: "push".
+	Operation string `json:"operation"`
+
+	// Acquired is the time when the lock was acquired.
+	Acquired time.Time `json:"acquired"`
+
+	// Expires is the time after which the lock is considered stale.
+	Expires time.Time `json:"expires"`
+}
+
+// NewLock returns a lock for the current process that expires after ttl.
+func NewLock(operation string, ttl time.Duration) Lock {
+	now := time.Now().UTC()
+	return Lock{
+		ID:        newID(),
+		Owner:     currentOwner(),
+		Host:      currentHost(),
+		PID:       os.Getpid(),
+		Operation: operation,
+		Acquired:  now,
+		Expires:   now.Add(ttl),
+	}
+}
+
+// Expired returns true if the lock lease has expired by the time now.
+func (l Lock) Expired(now time.Time) bool {
+	return now.After(l.Expires)
+}
+
+// LockedError is returned when the repository lock is held by someone else.
+// It matches ErrLocked with errors.Is.
+type LockedError struct {
+	Holder Lock
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf(
+		"%s by %s@%s (pid %d, operation %q) until %s",
+		ErrLocked, e.Holder.Owner, e.Holder.Host, e.Holder.PID, e.Holder.Operation,
+		e.Holder.Expires.Format(time.RFC3339),
+	)
+}
+
+func (e *LockedError) Is(target error) bool {
+	return target == ErrLocked
+}
+
+// LockURL returns the lock object URL for the provided repository URL.
+func LockURL(repoURI string) string {
+	return helmutil.JoinURL(repoURI, lockFileName)
+}
+
+// leaseURL returns the URL of the lease object of the lock with the id for
+// the provided repository URL.
+func leaseURL(repoURI string, id string) string {
+	return helmutil.JoinURL(repoURI, leaseFileName+"-"+id)
+}
+
+// lease is the renewed expiry of the repository lock.
+type lease struct {
+	// ID is the ID of the renewed lock.
+	ID string `json:"id"`
+
+	// Expires is the time after which the lock is considered stale.
+	Expires time.Time `json:"expires"`
+}
+
+// objectStore is the set of primitives the repository lock and journal are
+// built upon.
+type objectStore interface {
+	FetchRaw(ctx context.Context, uri string) ([]byte, string, error)
+
+	PutObject(ctx context.Context, uri string, r io.Reader) error
+
+	// createObject creates the object by uri only if it does not exist yet.
+	// Returns errObjectExists otherwise.
+	createObject(ctx context.Context, uri string, data []byte) error
+
+	// deleteObject deletes the object by uri.
+	deleteObject(ctx context.Context, uri string) error
+
+	// ListObjects returns all objects located directly in the directory by
+	// dirURI. A missing directory is not an error.
+	ListObjects(ctx context.Context, dirURI string) ([]ObjectInfo, error)
+}
+
+// acquireLock creates the repository lock object. If the lock is held and has
+// expired, it is broken and acquired again. If the lock is held by someone
+// else, *LockedError is returned.
+func acquireLock(ctx context.Context, store objectStore, repoURI string, lock Lock) error {
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("marshal lock: %w", err)
+	}
+
+	err = store.createObject(ctx, LockURL(repoURI), b)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errObjectExists) {
+		return fmt.Errorf("create lock object: %w", err)
+	}
+
+	holder, etag, found, err := readLockObject(ctx, store, repoURI)
+	if err != nil {
+		return err
+	}
+	if found && holder.ID == lock.ID {
+		// The lock has been created by the request retried after its
+		// response was lost.
+		return nil
+	}
+	if found && !holder.Expired(time.Now()) {
+		return &LockedError{Holder: holder}
+	}
+
+	// The lock is stale (or has just been released), take it over. Only the
+	// stale lock is deleted: if someone else has taken it over in the
+	// meantime, their lock is kept and creating the lock fails below.
+	if found {
+		err := deleteIfMatch(ctx, store, LockURL(repoURI), etag)
+		if err != nil && !errors.Is(err, errObjectChanged) {
+			return fmt.Errorf("delete stale lock object: %w", err)
+		}
+		if err == nil {
+			// A lease left behind is ignored anyway, as it belongs to
+			// another lock.
+			_ = store.deleteObject(ctx, leaseURL(repoURI, holder.ID))
+		}
+	}
+
+	err = store.createObject(ctx, LockURL(repoURI), b)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errObjectExists) {
+		return fmt.Errorf("create lock object: %w", err)
+	}
+
+	holder, found, err = readLock(ctx, store, repoURI)
+	if err != nil {
+		return err
+	}
+	if found && holder.ID == lock.ID {
+		return nil
+	}
+	return &LockedError{Holder: holder}
+}
+
+// readLock returns the current holder of the repository lock.
+// If the repository is not locked, returns false and <nil> error.
+func readLock(ctx context.Context, store objectStore, repoURI string) (Lock, bool, error) {
+	lock, _, found, err := readLockObject(ctx, store, repoURI)
+	return lock, found, err
+}
+
+// readLockObject returns the current holder of the repository lock along
+// with the ETag of the lock object. The expiry of the lock is taken from the
+// lease, if the lock has been renewed.
+func readLockObject(ctx context.Context, store objectStore, repoURI string) (Lock, string, bool, error) {
+	b, etag, err := store.FetchRaw(ctx, LockURL(repoURI))
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return Lock{}, "", false, nil
+		}
+		return Lock{}, "", false, fmt.Errorf("fetch lock object: %w", err)
+	}
+
+	var lock Lock
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return Lock{}, "", false, fmt.Errorf("unmarshal lock object: %w", err)
+	}
+
+	b, _, err = store.FetchRaw(ctx, leaseURL(repoURI, lock.ID))
+	switch {
+	case errors.Is(err, ErrObjectNotFound):
+	case err != nil:
+		return Lock{}, "", false, fmt.Errorf("fetch lease object: %w", err)
+	default:
+		// A corrupted lease is ignored: the lock then expires as it was
+		// acquired.
+		var l lease
+		if json.Unmarshal(b, &l) == nil && l.ID == lock.ID && l.Expires.After(lock.Expires) {
+			lock.Expires = l.Expires
+		}
+	}
+
+	return lock, etag, true, nil
+}
+
+// renewLock extends the repository lock held with the lock id for ttl from
+// now. If the lock is not held with the id anymore, ErrLockLost is returned.
+func renewLock(ctx context.Context, store objectStore, repoURI string, id string, ttl time.Duration) error {
+	holder, found, err := readLock(ctx, store, repoURI)
+	if err != nil {
+		return err
+	}
+	if !found || holder.ID != id {
+		return ErrLockLost
+	}
+
+	b, err := json.Marshal(lease{ID: id, Expires: time.Now().UTC().Add(ttl)})
+	if err != nil {
+		return fmt.Errorf("marshal lease: %w", err)
+	}
+	if err := store.PutObject(ctx, leaseURL(repoURI, id), bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("put lease object: %w", err)
+	}
+
+	// The lock may have been taken over after it was read. The lease then
+	// only extends the lost lock, which is reported, and is removed.
+	holder, found, err = readLock(ctx, store, repoURI)
+	if err != nil {
+		return err
+	}
+	if !found || holder.ID != id {
+		_ = store.deleteObject(ctx, leaseURL(repoURI, id))
+		return ErrLockLost
+	}
+
+	return nil
+}
+
+// releaseLock removes the repository lock if it is still held with the lock
+// id. It does nothing if the lock has been taken over by someone else.
+func releaseLock(ctx context.Context, store objectStore, repoURI string, id string) error {
+	holder, etag, found, err := readLockObject(ctx, store, repoURI)
+	if err != nil {
+		return err
+	}
+	if !found || holder.ID != id {
+		return nil
+	}
+
+	// The lease is only written by the holder, so it is safe to delete it
+	// before the lock.
+	if err := store.deleteObject(ctx, leaseURL(repoURI, id)); err != nil {
+		return fmt.Errorf("delete lease object: %w", err)
+	}
+
+	err = deleteIfMatch(ctx, store, LockURL(repoURI), etag)
+	if err != nil && !errors.Is(err, errObjectChanged) {
+		return fmt.Errorf("delete lock object: %w", err)
+	}
+	return nil
+}
+
+// breakLock removes the repository lock held with the lock id, even if it
+// has not expired, along with its lease and the delete guard left by a
+// process that crashed while deleting it. It does nothing if the repository
+// is not locked. If the lock is held with another id, e.g. it has been taken
+// over since the holder was read, *LockedError is returned.
+func breakLock(ctx context.Context, store objectStore, repoURI string, id string) error {
+	holder, etag, found, err := readLockObject(ctx, store, repoURI)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	if holder.ID != id {
+		return &LockedError{Holder: holder}
+	}
+
+	err = deleteIfMatch(ctx, store, LockURL(repoURI), etag)
+	if errors.Is(err, errObjectChanged) {
+		// Either the lock has changed, or its deletion is claimed by the
+		// delete guard of a crashed process, which is removed.
+		holder, current, found, readErr := readLockObject(ctx, store, repoURI)
+		switch {
+		case readErr != nil:
+			return readErr
+		case !found:
+			err = nil
+		case current != etag:
+			return &LockedError{Holder: holder}
+		default:
+			if err := store.deleteObject(ctx, deleteGuardURL(LockURL(repoURI), etag)); err != nil {
+				return fmt.Errorf("delete delete guard object: %w", err)
+			}
+			err = deleteIfMatch(ctx, store, LockURL(repoURI), etag)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("delete lock object: %w", err)
+	}
+
+	if err := store.deleteObject(ctx, leaseURL(repoURI, id)); err != nil {
+		return fmt.Errorf("delete lease object: %w", err)
+	}
+	return nil
+}
+
+// deleteIfMatch deletes the object by uri only if its ETag matches etag,
+// otherwise errObjectChanged is returned.
+//
+// OSS cannot delete objects conditionally, so the deletion is claimed first
+// by creating the guard object for the etag, with forbid-overwrite semantics.
+// Of the processes deleting the same version of the object, only the one
+// that has created the guard compares the ETag and deletes the object, so no
+// one else can replace the object in between. The object content must be
+// unique, so that its ETag never repeats.
+func deleteIfMatch(ctx context.Context, store objectStore, uri string, etag string) error {
+	guard := deleteGuardURL(uri, etag)
+	if err := store.createObject(ctx, guard, nil); err != nil {
+		if errors.Is(err, errObjectExists) {
+			// Someone else is deleting the object.
+			return errObjectChanged
+		}
+		return fmt.Errorf("create delete guard object: %w", err)
+	}
+
+	err := func() error {
+		_, current, err := store.FetchRaw(ctx, uri)
+		if errors.Is(err, ErrObjectNotFound) || (err == nil && current != etag) {
+			return errObjectChanged
+		}
+		if err != nil {
+			return err
+		}
+		return store.deleteObject(ctx, uri)
+	}()
+
+	if guardErr := store.deleteObject(ctx, guard); guardErr != nil && err == nil {
+		err = fmt.Errorf("delete delete guard object: %w", guardErr)
+	}
+	return err
+}
+
+// deleteGuardURL returns the URL of the guard object, which claims the
+// deletion of the object by uri with the etag.
+func deleteGuardURL(uri string, etag string) string {
+	return uri + ".delete-" + strings.ToLower(strings.Trim(etag, `"`))
+}
+
+// newID returns a random unique identifier.
+func newID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// currentOwner returns the name of the user running the process.
+func currentOwner() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return "unknown"
+}
+
+// currentHost returns the name of the host running the process.
+func currentHost() string {
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return "unknown"
+}
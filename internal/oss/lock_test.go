@@ -0,0 +1,202 @@
+package oss
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLock_TakeoverRace(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	stale := NewLock("push", -time.Minute)
+	require.NoError(t, b.AcquireLock(ctx, repo, stale))
+	_, staleETag, err := b.FetchRaw(ctx, LockURL(repo))
+	require.NoError(t, err)
+
+	// Both contenders have seen the stale lock, the first one takes it over.
+	first := NewLock("push", time.Minute)
+	require.NoError(t, b.AcquireLock(ctx, repo, first))
+
+	// The second one must not delete the lock of the first one.
+	assert.ErrorIs(t, deleteIfMatch(ctx, b, LockURL(repo), staleETag), errObjectChanged)
+	second := NewLock("push", time.Minute)
+	assert.ErrorIs(t, b.AcquireLock(ctx, repo, second), ErrLocked)
+
+	// Neither must the stale holder on release.
+	require.NoError(t, b.ReleaseLock(ctx, repo, stale.ID))
+	holder, found, err := b.ReadLock(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, first.ID, holder.ID)
+}
+
+func TestLock_RetriedCreate(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	// The first request has created the objects, but its response was lost,
+	// so the retried request finds them existing.
+	lock := NewLock("push", time.Minute)
+	require.NoError(t, b.AcquireLock(ctx, repo, lock))
+	require.NoError(t, b.AcquireLock(ctx, repo, lock), "lock must be acquired by its own retry")
+
+	journal := NewJournal("push", nil)
+	journal.LockID = lock.ID
+	require.NoError(t, b.WriteJournal(ctx, repo, journal))
+	require.NoError(t, b.WriteJournal(ctx, repo, journal), "journal must be written by its own retry")
+
+	other := journal
+	other.PID++
+	assert.ErrorIs(t, b.WriteJournal(ctx, repo, other), errObjectExists)
+}
+
+func TestLock_Renew(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	lock := NewLock("reindex", time.Second)
+	require.NoError(t, b.AcquireLock(ctx, repo, lock))
+	require.NoError(t, b.RenewLock(ctx, repo, lock.ID, time.Hour))
+
+	holder, _, err := b.ReadLock(ctx, repo)
+	require.NoError(t, err)
+	assert.True(t, holder.Expires.After(time.Now().Add(59*time.Minute)), "lease must extend the lock")
+	assert.False(t, holder.Expired(time.Now().Add(time.Minute)))
+
+	assert.ErrorIs(t, b.RenewLock(ctx, repo, "other", time.Hour), ErrLockLost)
+
+	t.Run("should report lost lock after takeover", func(t *testing.T) {
+		require.NoError(t, b.BreakLock(ctx, repo, lock.ID))
+		exists, err := b.Exists(ctx, leaseURL(repo, lock.ID))
+		require.NoError(t, err)
+		assert.False(t, exists, "lease must be removed with the lock")
+
+		other := NewLock("push", time.Second)
+		require.NoError(t, b.AcquireLock(ctx, repo, other))
+		require.NoError(t, b.RenewLock(ctx, repo, other.ID, time.Hour))
+		assert.ErrorIs(t, b.RenewLock(ctx, repo, lock.ID, time.Hour), ErrLockLost)
+
+		holder, _, err := b.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		assert.Equal(t, other.ID, holder.ID)
+		assert.True(t, holder.Expires.After(time.Now().Add(59*time.Minute)), "lease of the new holder must be kept")
+		exists, err = b.Exists(ctx, leaseURL(repo, lock.ID))
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("should ignore lease of previous holder", func(t *testing.T) {
+		holder, _, err := b.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		require.NoError(t, b.BreakLock(ctx, repo, holder.ID))
+		require.NoError(t, b.AcquireLock(ctx, repo, lock))
+		require.NoError(t, b.RenewLock(ctx, repo, lock.ID, time.Hour))
+
+		// The previous holder crashed, leaving the lease.
+		require.NoError(t, b.deleteObject(ctx, LockURL(repo)))
+		next := NewLock("push", -time.Minute)
+		require.NoError(t, b.AcquireLock(ctx, repo, next))
+
+		holder, _, err = b.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		assert.Equal(t, next.ID, holder.ID)
+		assert.True(t, holder.Expired(time.Now()))
+	})
+
+	t.Run("should release lock and lease", func(t *testing.T) {
+		holder, _, err := b.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		require.NoError(t, b.BreakLock(ctx, repo, holder.ID))
+		require.NoError(t, b.AcquireLock(ctx, repo, lock))
+		require.NoError(t, b.RenewLock(ctx, repo, lock.ID, time.Hour))
+		require.NoError(t, b.ReleaseLock(ctx, repo, lock.ID))
+
+		objects, err := b.ListObjects(ctx, repo)
+		require.NoError(t, err)
+		assert.Empty(t, objects)
+	})
+}
+
+func TestLock_Break(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	require.NoError(t, b.BreakLock(ctx, repo, "missing"), "missing lock must be ignored")
+
+	t.Run("should keep lock taken over", func(t *testing.T) {
+		broken := NewLock("push", -time.Minute)
+		require.NoError(t, b.AcquireLock(ctx, repo, broken))
+		next := NewLock("push", time.Minute)
+		require.NoError(t, b.AcquireLock(ctx, repo, next))
+
+		err := b.BreakLock(ctx, repo, broken.ID)
+		var lockedErr *LockedError
+		require.ErrorAs(t, err, &lockedErr)
+		assert.Equal(t, next.ID, lockedErr.Holder.ID)
+
+		holder, found, err := b.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, next.ID, holder.ID)
+		require.NoError(t, b.ReleaseLock(ctx, repo, next.ID))
+	})
+
+	t.Run("should remove delete guard of crashed process", func(t *testing.T) {
+		lock := NewLock("push", time.Minute)
+		require.NoError(t, b.AcquireLock(ctx, repo, lock))
+		require.NoError(t, b.RenewLock(ctx, repo, lock.ID, time.Hour))
+		_, etag, err := b.FetchRaw(ctx, LockURL(repo))
+		require.NoError(t, err)
+		require.NoError(t, b.createObject(ctx, deleteGuardURL(LockURL(repo), etag), nil))
+
+		require.NoError(t, b.BreakLock(ctx, repo, lock.ID))
+		objects, err := b.ListObjects(ctx, repo)
+		require.NoError(t, err)
+		assert.Empty(t, objects, "lock, lease and guard must be deleted")
+	})
+}
+
+func TestDeleteIfMatch(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	uri := "mem://bucket/object"
+
+	require.NoError(t, b.createObject(ctx, uri, []byte("v1")))
+	_, etag, err := b.FetchRaw(ctx, uri)
+	require.NoError(t, err)
+
+	t.Run("should not delete while claimed by someone else", func(t *testing.T) {
+		require.NoError(t, b.createObject(ctx, deleteGuardURL(uri, etag), nil))
+		defer b.deleteObject(ctx, deleteGuardURL(uri, etag))
+
+		assert.ErrorIs(t, deleteIfMatch(ctx, b, uri, etag), errObjectChanged)
+		exists, err := b.Exists(ctx, uri)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("should not delete changed object", func(t *testing.T) {
+		assert.ErrorIs(t, deleteIfMatch(ctx, b, uri, `"OTHER"`), errObjectChanged)
+		exists, err := b.Exists(ctx, uri)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("should delete object", func(t *testing.T) {
+		require.NoError(t, deleteIfMatch(ctx, b, uri, etag))
+		assert.ErrorIs(t, deleteIfMatch(ctx, b, uri, etag), errObjectChanged)
+
+		objects, err := b.ListObjects(ctx, "mem://bucket")
+		require.NoError(t, err)
+		assert.Empty(t, objects, "object and guards must be deleted")
+	})
+}
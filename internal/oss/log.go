@@ -0,0 +1,138 @@
+package oss
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// requestIDHeader is the response header with the ID of the OSS request,
+// which is asked by the OSS support.
+const requestIDHeader = "X-Oss-Request-Id"
+
+// secretQueryParams are the query parameters of the presigned URLs, which
+// must not be logged. They are compared case-insensitively.
+var secretQueryParams = []string{
+	"OSSAccessKeyId",
+	"Signature",
+	"security-token",
+	"x-oss-signature",
+	"x-oss-credential",
+	"x-oss-security-token",
+}
+
+// loggingTransport logs every HTTP request to OSS, including the retried
+// ones, at debug level. Headers are not logged, as they carry the signature
+// and the security token, and the secret query parameters are redacted.
+type loggingTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+
+	// host is the endpoint host, used to tell the bucket from the request URL.
+	host string
+}
+
+// newLoggingClient returns the copy of the client which logs the requests to
+// the endpoint of the location.
+func newLoggingClient(c *http.Client, logger *slog.Logger, loc bucketLocation) *http.Client {
+	var host string
+	if u, err := url.Parse(endpointURL(loc)); err == nil {
+		host = u.Host
+	}
+
+	base := c.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	lc := *c
+	lc.Transport = &loggingTransport{base: base, logger: logger, host: host}
+	return &lc
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !t.logger.Enabled(ctx, slog.LevelDebug) {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	latency := time.Since(start)
+
+	bucket, key := t.objectOf(req.URL)
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("bucket", bucket),
+		slog.String("key", key),
+	}
+	if req.URL.RawQuery != "" {
+		attrs = append(attrs, slog.String("query", redactQuery(req.URL.Query())))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.Duration("latency", latency), slog.String("error", redactError(err)))
+		t.logger.LogAttrs(ctx, slog.LevelDebug, "oss request failed", attrs...)
+		return nil, err
+	}
+	attrs = append(attrs,
+		slog.Int("status", resp.StatusCode),
+		slog.String("request_id", resp.Header.Get(requestIDHeader)),
+		slog.Duration("latency", latency),
+	)
+	t.logger.LogAttrs(ctx, slog.LevelDebug, "oss request", attrs...)
+
+	return resp, nil
+}
+
+// objectOf returns the bucket and the object key of the request URL, see
+// requestObject.
+func (t *loggingTransport) objectOf(u *url.URL) (bucket, key string) {
+	return requestObject(t.host, u)
+}
+
+// requestObject returns the bucket and the object key of the request URL to
+// the endpoint host, which is either virtual-hosted or path style. The bucket
+// is empty for CNAME endpoints.
+func requestObject(host string, u *url.URL) (bucket, key string) {
+	p := strings.TrimPrefix(u.Path, "/")
+	switch {
+	case u.Host == host:
+		bucket, key, _ = strings.Cut(p, "/")
+		return bucket, key
+	case strings.HasSuffix(u.Host, "."+host):
+		return strings.TrimSuffix(u.Host, "."+host), p
+	default:
+		return "", p
+	}
+}
+
+// redactQuery returns the encoded query with the values of the secret
+// parameters replaced.
+func redactQuery(query url.Values) string {
+	for name := range query {
+		for _, secret := range secretQueryParams {
+			if strings.EqualFold(name, secret) {
+				query[name] = []string{"REDACTED"}
+			}
+		}
+	}
+	return query.Encode()
+}
+
+// redactError returns the error message with the secret query parameters of
+// the request URL redacted.
+func redactError(err error) string {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err.Error()
+	}
+	u, parseErr := url.Parse(urlErr.URL)
+	if parseErr != nil {
+		return urlErr.Err.Error()
+	}
+	u.RawQuery = redactQuery(u.Query())
+	return (&url.Error{Op: urlErr.Op, URL: u.String(), Err: urlErr.Err}).Error()
+}
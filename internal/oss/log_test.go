@@ -0,0 +1,106 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/osstest"
+)
+
+func TestStorage_Logging(t *testing.T) {
+	srv := osstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.CreateBucket("test-bucket")
+
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	s, err := New(Config{
+		Endpoint:        srv.URL,
+		Region:          osstest.DefaultRegion,
+		AccessKeyID:     "test-access-key-id",
+		AccessKeySecret: "test-access-key-secret",
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, s.PutIndex(ctx, "oss://test-bucket/charts", "", strings.NewReader("v1")))
+	_, _, err = s.FetchRaw(ctx, "oss://test-bucket/charts/missing.yaml")
+	require.ErrorIs(t, err, ErrObjectNotFound)
+
+	var requests []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		if record["msg"] == "oss request" {
+			requests = append(requests, record)
+		}
+	}
+	require.Len(t, requests, 2)
+
+	assert.Equal(t, "PUT", requests[0]["method"])
+	assert.Equal(t, "test-bucket", requests[0]["bucket"])
+	assert.Equal(t, "charts/index.yaml", requests[0]["key"])
+	assert.EqualValues(t, 200, requests[0]["status"])
+	assert.NotEmpty(t, requests[0]["request_id"])
+	assert.Contains(t, requests[0], "latency")
+
+	assert.Equal(t, "GET", requests[1]["method"])
+	assert.Equal(t, "charts/missing.yaml", requests[1]["key"])
+	assert.EqualValues(t, 404, requests[1]["status"])
+
+	assert.NotContains(t, buf.String(), "test-access-key-secret")
+	assert.NotContains(t, buf.String(), "Authorization")
+}
+
+func TestLoggingTransport_objectOf(t *testing.T) {
+	tr := &loggingTransport{host: "oss-cn-hangzhou.aliyuncs.com"}
+
+	tests := []struct {
+		url        string
+		wantBucket string
+		wantKey    string
+	}{
+		{url: "https://bucket.oss-cn-hangzhou.aliyuncs.com/charts/index.yaml", wantBucket: "bucket", wantKey: "charts/index.yaml"},
+		{url: "https://oss-cn-hangzhou.aliyuncs.com/bucket/charts/index.yaml", wantBucket: "bucket", wantKey: "charts/index.yaml"},
+		{url: "https://oss-cn-hangzhou.aliyuncs.com/bucket?location", wantBucket: "bucket", wantKey: ""},
+		{url: "https://charts.example.com/charts/index.yaml", wantBucket: "", wantKey: "charts/index.yaml"},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.url)
+		require.NoError(t, err)
+		bucket, key := tr.objectOf(u)
+		assert.Equal(t, tc.wantBucket, bucket, tc.url)
+		assert.Equal(t, tc.wantKey, key, tc.url)
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	query := url.Values{
+		"x-oss-signature":      {"signature"},
+		"x-oss-credential":     {"key-id/20240101/cn-hangzhou/oss/aliyun_v4_request"},
+		"X-Oss-Security-Token": {"token"},
+		"OSSAccessKeyId":       {"key-id"},
+		"Signature":            {"signature"},
+		"x-oss-expires":        {"3600"},
+	}
+	got := redactQuery(query)
+	assert.NotContains(t, got, "signature=signature")
+	assert.NotContains(t, got, "key-id")
+	assert.NotContains(t, got, "=token")
+	assert.Contains(t, got, "x-oss-expires=3600")
+	assert.Contains(t, got, "x-oss-signature=REDACTED")
+
+	err := redactError(&url.Error{Op: "Get", URL: "https://bucket.example.com/key?Signature=secret", Err: context.DeadlineExceeded})
+	assert.NotContains(t, err, "secret")
+	assert.Contains(t, err, "context deadline exceeded")
+}
@@ -0,0 +1,325 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"helm-oss/internal/helmutil"
+)
+
+// MemoryBackend is a Backend that keeps all objects in memory.
+// It accepts URIs of any scheme and is intended for testing.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data     []byte
+	etag     string
+	meta     map[string]string
+	modified time.Time
+}
+
+// chartInfo returns the information about the chart stored in the object.
+func (o memoryObject) chartInfo(filename string) (ChartInfo, error) {
+	item, ok, err := chartInfoFromMetadata(filename, o.meta)
+	if err != nil || ok {
+		return item, err
+	}
+	return chartInfoFromArchive(filename, bytes.NewReader(o.data))
+}
+
+// NewMemoryBackend returns a new empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+// Traverse traverses all charts in the repository. The charts are in memory,
+// so concurrency is ignored.
+func (b *MemoryBackend) Traverse(ctx context.Context, repoURI string, _ int) (<-chan ChartInfo, <-chan error) {
+	charts := make(chan ChartInfo, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(charts)
+		defer close(errs)
+
+		prefix := strings.TrimSuffix(repoURI, "/") + "/"
+
+		b.mu.Lock()
+		objects := maps.Clone(b.objects)
+		b.mu.Unlock()
+
+		for _, uri := range slices.Sorted(maps.Keys(objects)) {
+			key, ok := strings.CutPrefix(uri, prefix)
+			if !ok || !isChartKey(key) {
+				continue
+			}
+
+			item, err := objects[uri].chartInfo(key)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			select {
+			case charts <- item:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return charts, errs
+}
+
+// LoadChart returns the information about the chart object by uri.
+func (b *MemoryBackend) LoadChart(ctx context.Context, uri string) (ChartInfo, error) {
+	b.mu.Lock()
+	obj, ok := b.objects[uri]
+	b.mu.Unlock()
+
+	if !ok {
+		return ChartInfo{}, ErrObjectNotFound
+	}
+	return obj.chartInfo(path.Base(uri))
+}
+
+// FetchRaw returns the object content by uri along with the object ETag.
+func (b *MemoryBackend) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	obj, ok := b.objects[uri]
+	if !ok {
+		return nil, "", ErrObjectNotFound
+	}
+
+	return bytes.Clone(obj.data), obj.etag, nil
+}
+
+// Open returns the reader of the object content by uri.
+func (b *MemoryBackend) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	data, _, err := b.FetchRaw(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Exists returns true if an object exists by uri.
+func (b *MemoryBackend) Exists(ctx context.Context, uri string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.objects[uri]
+	return ok, nil
+}
+
+// StatObject returns the information about the object by uri.
+func (b *MemoryBackend) StatObject(ctx context.Context, uri string) (ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	obj, ok := b.objects[uri]
+	if !ok {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+
+	return ObjectInfo{
+		URI:          uri,
+		Size:         int64(len(obj.data)),
+		ETag:         obj.etag,
+		LastModified: obj.modified,
+		Metadata:     maps.Clone(obj.meta),
+	}, nil
+}
+
+// PutIndex conditionally puts the index file to the repository.
+func (b *MemoryBackend) PutIndex(ctx context.Context, uri string, etag string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read index: %w", err)
+	}
+
+	uri = helmutil.IndexFileURL(uri)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, exists := b.objects[uri]
+	if (etag == "" && exists) || (etag != "" && (!exists || current.etag != etag)) {
+		return ErrIndexConflict
+	}
+
+	b.put(uri, data, nil)
+	return nil
+}
+
+// PutObject stores the object by uri.
+func (b *MemoryBackend) PutObject(ctx context.Context, uri string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read object: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.put(uri, data, nil)
+	return nil
+}
+
+// PutChart puts the chart file by uri, and the provenance file next to it if
+// prov is true.
+func (b *MemoryBackend) PutChart(
+	ctx context.Context,
+	uri string,
+	r io.Reader,
+	chartMeta string,
+	chartDigest string,
+	contentType string,
+	prov bool,
+	provReader io.Reader,
+) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("read chart: %w", err)
+	}
+
+	var provData []byte
+	if prov {
+		provData, err = io.ReadAll(provReader)
+		if err != nil {
+			return "", fmt.Errorf("read prov: %w", err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.put(uri, data, assembleObjectMetadata(chartMeta, chartDigest))
+	if prov {
+		b.put(uri+".prov", provData, nil)
+	}
+
+	return "", nil
+}
+
+// DeleteChart deletes the chart object by uri. Also deletes .prov file if exists.
+func (b *MemoryBackend) DeleteChart(ctx context.Context, uri string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.objects, uri)
+	delete(b.objects, uri+".prov")
+	return nil
+}
+
+// AcquireLock creates the repository lock object.
+func (b *MemoryBackend) AcquireLock(ctx context.Context, repoURI string, lock Lock) error {
+	return acquireLock(ctx, b, repoURI, lock)
+}
+
+// ReadLock returns the current holder of the repository lock.
+func (b *MemoryBackend) ReadLock(ctx context.Context, repoURI string) (Lock, bool, error) {
+	return readLock(ctx, b, repoURI)
+}
+
+// RenewLock extends the repository lock held with the lock id.
+func (b *MemoryBackend) RenewLock(ctx context.Context, repoURI string, id string, ttl time.Duration) error {
+	return renewLock(ctx, b, repoURI, id, ttl)
+}
+
+// ReleaseLock removes the repository lock if it is still held with the lock id.
+func (b *MemoryBackend) ReleaseLock(ctx context.Context, repoURI string, id string) error {
+	return releaseLock(ctx, b, repoURI, id)
+}
+
+// BreakLock removes the repository lock held with the lock id, even if it
+// has not expired, along with its lease.
+func (b *MemoryBackend) BreakLock(ctx context.Context, repoURI string, id string) error {
+	return breakLock(ctx, b, repoURI, id)
+}
+
+// WriteJournal records the operation before it modifies the repository.
+func (b *MemoryBackend) WriteJournal(ctx context.Context, repoURI string, journal Journal) error {
+	return writeJournal(ctx, b, repoURI, journal)
+}
+
+// ListJournals returns the journals of all operations that have not been completed.
+func (b *MemoryBackend) ListJournals(ctx context.Context, repoURI string) ([]Journal, error) {
+	return listJournals(ctx, b, repoURI)
+}
+
+// CompleteJournal marks the operation with the id as completed.
+func (b *MemoryBackend) CompleteJournal(ctx context.Context, repoURI string, id string) error {
+	return b.deleteObject(ctx, JournalURL(repoURI, id))
+}
+
+// createObject stores the object by uri only if it does not exist yet.
+func (b *MemoryBackend) createObject(ctx context.Context, uri string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.objects[uri]; ok {
+		return errObjectExists
+	}
+
+	b.put(uri, bytes.Clone(data), nil)
+	return nil
+}
+
+// deleteObject deletes the object by uri.
+func (b *MemoryBackend) deleteObject(ctx context.Context, uri string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.objects, uri)
+	return nil
+}
+
+// ListObjects returns all objects located directly in the directory by dirURI.
+func (b *MemoryBackend) ListObjects(ctx context.Context, dirURI string) ([]ObjectInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prefix := strings.TrimSuffix(dirURI, "/") + "/"
+
+	var objects []ObjectInfo
+	for _, uri := range slices.Sorted(maps.Keys(b.objects)) {
+		if name, ok := strings.CutPrefix(uri, prefix); ok && !strings.Contains(name, "/") {
+			obj := b.objects[uri]
+			objects = append(objects, ObjectInfo{
+				URI:          uri,
+				Size:         int64(len(obj.data)),
+				ETag:         obj.etag,
+				LastModified: obj.modified,
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+// put stores the object. b.mu must be held.
+func (b *MemoryBackend) put(uri string, data []byte, meta map[string]string) {
+	b.objects[uri] = memoryObject{
+		data:     data,
+		etag:     contentETag(data),
+		meta:     meta,
+		modified: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,159 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBackend_PutIndex(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+
+	err := b.PutIndex(ctx, "mem://bucket/charts", "", strings.NewReader("v1"))
+	require.NoError(t, err)
+
+	err = b.PutIndex(ctx, "mem://bucket/charts", "", strings.NewReader("v1"))
+	assert.ErrorIs(t, err, ErrIndexConflict, "index must not be created twice")
+
+	data, etag, err := b.FetchRaw(ctx, "mem://bucket/charts/index.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(data))
+
+	err = b.PutIndex(ctx, "mem://bucket/charts", etag, strings.NewReader("v2"))
+	require.NoError(t, err)
+
+	err = b.PutIndex(ctx, "mem://bucket/charts", etag, strings.NewReader("v3"))
+	assert.ErrorIs(t, err, ErrIndexConflict, "index must not be replaced with stale etag")
+
+	data, _, err = b.FetchRaw(ctx, "mem://bucket/charts/index.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(data))
+}
+
+func TestMemoryBackend_Traverse(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+
+	f, err := os.Open("../../testdata/foo-1.2.3.tgz")
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = b.PutChart(ctx, "mem://bucket/charts/foo-1.2.3.tgz", f, "", "", "application/gzip", false, nil)
+	require.NoError(t, err)
+	_, err = b.PutChart(ctx, "mem://bucket/charts/sub/bar-0.1.0.tgz", strings.NewReader(""), "", "", "application/gzip", false, nil)
+	require.NoError(t, err)
+
+	items, errs := b.Traverse(ctx, "mem://bucket/charts", DefaultConcurrency)
+
+	var found []ChartInfo
+	for item := range items {
+		found = append(found, item)
+	}
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	require.Len(t, found, 1)
+	assert.Equal(t, "foo-1.2.3.tgz", found[0].Filename)
+	assert.NotEmpty(t, found[0].Hash)
+}
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	first := NewLock("push", time.Minute)
+	require.NoError(t, b.AcquireLock(ctx, repo, first))
+
+	second := NewLock("delete", time.Minute)
+	err := b.AcquireLock(ctx, repo, second)
+	require.ErrorIs(t, err, ErrLocked)
+
+	var lockedErr *LockedError
+	require.ErrorAs(t, err, &lockedErr)
+	assert.Equal(t, first.ID, lockedErr.Holder.ID)
+
+	// Releasing with a foreign id must not remove the lock.
+	require.NoError(t, b.ReleaseLock(ctx, repo, second.ID))
+	holder, found, err := b.ReadLock(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, first.ID, holder.ID)
+
+	require.NoError(t, b.ReleaseLock(ctx, repo, first.ID))
+	_, found, err = b.ReadLock(ctx, repo)
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestLock_Stale(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	stale := NewLock("push", -time.Minute)
+	require.NoError(t, b.AcquireLock(ctx, repo, stale))
+
+	fresh := NewLock("push", time.Minute)
+	require.NoError(t, b.AcquireLock(ctx, repo, fresh), "stale lock must be taken over")
+
+	holder, found, err := b.ReadLock(ctx, repo)
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, fresh.ID, holder.ID)
+}
+
+func TestJournal(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	first := NewJournal("push", &JournalChart{Name: "foo", Version: "1.2.3", URL: "foo-1.2.3.tgz"})
+	second := NewJournal("reindex", nil)
+	second.Started = first.Started.Add(time.Second)
+	require.NoError(t, b.WriteJournal(ctx, repo, second))
+	require.NoError(t, b.WriteJournal(ctx, repo, first))
+
+	journals, err := b.ListJournals(ctx, repo)
+	require.NoError(t, err)
+	assert.Equal(t, []Journal{first, second}, journals, "journals must be sorted oldest first")
+
+	require.NoError(t, b.CompleteJournal(ctx, repo, first.ID))
+	journals, err = b.ListJournals(ctx, repo)
+	require.NoError(t, err)
+	assert.Equal(t, []Journal{second}, journals)
+}
+
+func TestLoadCharts(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBackend()
+	repo := "mem://bucket/charts"
+
+	data, err := os.ReadFile("../../testdata/foo-1.2.3.tgz")
+	require.NoError(t, err)
+
+	var uris []string
+	for _, name := range []string{"a.tgz", "b.tgz", "c.tgz", "d.tgz"} {
+		uris = append(uris, repo+"/"+name)
+		require.NoError(t, b.PutObject(ctx, repo+"/"+name, bytes.NewReader(data)))
+	}
+
+	items, err := LoadCharts(ctx, b, uris, 2)
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+	for i, item := range items {
+		assert.Equal(t, path.Base(uris[i]), item.Filename, "items must be in the order of uris")
+	}
+
+	_, err = LoadCharts(ctx, b, append(uris, repo+"/missing.tgz"), 2)
+	assert.ErrorIs(t, err, ErrObjectNotFound)
+}
@@ -0,0 +1,61 @@
+package oss
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/retry"
+)
+
+// RetryConfig configures the retries of the failed requests. The delay
+// between the attempts grows exponentially with full jitter.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of attempts of a request, including
+	// the first one. Defaults to 3.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+
+	// BaseDelay is the base delay between the attempts. Defaults to 200ms.
+	BaseDelay Duration `json:"baseDelay,omitempty"`
+
+	// MaxBackoff is the maximum delay between the attempts. Defaults to 20s.
+	MaxBackoff Duration `json:"maxBackoff,omitempty"`
+
+	// StatusCodes are the HTTP status codes of the responses which are
+	// retried. Defaults to 401, 408, 429 and 5xx.
+	StatusCodes []int `json:"statusCodes,omitempty"`
+}
+
+// newRetryer returns the SDK standard retryer with the configuration.
+// Connection errors are always retried.
+func newRetryer(c RetryConfig) retry.Retryer {
+	return retry.NewStandard(func(o *retry.RetryOptions) {
+		if c.MaxAttempts > 0 {
+			o.MaxAttempts = c.MaxAttempts
+		}
+		if c.BaseDelay > 0 {
+			o.BaseDelay = time.Duration(c.BaseDelay)
+		}
+		if c.MaxBackoff > 0 {
+			o.MaxBackoff = time.Duration(c.MaxBackoff)
+		}
+		if len(c.StatusCodes) > 0 {
+			o.ErrorRetryables = []retry.ErrorRetryable{
+				statusCodeRetryable(c.StatusCodes),
+				&retry.ServiceErrorCodeRetryable{},
+				&retry.ConnectionErrorRetryable{},
+			}
+		}
+	})
+}
+
+// statusCodeRetryable retries the responses with the HTTP status codes.
+type statusCodeRetryable []int
+
+func (codes statusCodeRetryable) IsErrorRetryable(err error) bool {
+	var v interface{ HttpStatusCode() int }
+	if !errors.As(err, &v) {
+		return false
+	}
+	return slices.Contains(codes, v.HttpStatusCode())
+}
@@ -0,0 +1,136 @@
+package oss
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/osstest"
+)
+
+func TestStorage_Retry(t *testing.T) {
+	backend := osstest.NewServer()
+	t.Cleanup(backend.Close)
+	backend.CreateBucket("test-bucket")
+	backend.PutObject("test-bucket", "charts/index.yaml", []byte("v1"), nil)
+
+	// The front server fails the first requests with 503.
+	backendURL, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(backendURL)
+	var failures atomic.Int32
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(front.Close)
+
+	conf := Config{
+		Endpoint:        front.URL,
+		Region:          osstest.DefaultRegion,
+		AccessKeyID:     "test-access-key-id",
+		AccessKeySecret: "test-access-key-secret",
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		retry    RetryConfig
+		failures int32
+		wantErr  bool
+	}{
+		{name: "retried", retry: RetryConfig{MaxAttempts: 3}, failures: 2},
+		{name: "attempts exhausted", retry: RetryConfig{MaxAttempts: 2}, failures: 2, wantErr: true},
+		{name: "status code not retried", retry: RetryConfig{MaxAttempts: 3, StatusCodes: []int{500}}, failures: 1, wantErr: true},
+		{name: "status code retried", retry: RetryConfig{MaxAttempts: 3, StatusCodes: []int{503}}, failures: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			failures.Store(tc.failures)
+
+			conf := conf
+			conf.Retry = tc.retry
+			conf.Retry.BaseDelay = Duration(time.Millisecond)
+			s, err := New(conf)
+			require.NoError(t, err)
+
+			data, _, err := s.FetchRaw(ctx, "oss://test-bucket/charts/index.yaml")
+			if tc.wantErr {
+				assert.ErrorContains(t, err, "Http Status Code: 503")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "v1", string(data))
+		})
+	}
+}
+
+func TestProxyFunc(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("NO_PROXY", "")
+
+	req := httptest.NewRequest(http.MethodGet, "https://bucket.oss-cn-hangzhou.aliyuncs.com/index.yaml", nil)
+
+	tests := []struct {
+		name string
+		conf Config
+		want string
+	}{
+		{name: "environment", want: "http://env-proxy:3128"},
+		{name: "configured proxy", conf: Config{Proxy: "http://proxy:8080"}, want: "http://proxy:8080"},
+		{name: "no proxy", conf: Config{Proxy: "http://proxy:8080", NoProxy: ".aliyuncs.com"}, want: ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, err := proxyFunc(tc.conf)
+			require.NoError(t, err)
+
+			u, err := proxy(req)
+			require.NoError(t, err)
+			if tc.want == "" {
+				assert.Nil(t, u)
+			} else {
+				require.NotNil(t, u)
+				assert.Equal(t, tc.want, u.String())
+			}
+		})
+	}
+
+	_, err := proxyFunc(Config{Proxy: "http://proxy:port"})
+	assert.ErrorContains(t, err, "invalid proxy URL")
+}
+
+func TestDuration(t *testing.T) {
+	var conf Config
+	k, ok := LookupConfigKey("retry.maxBackoff")
+	require.True(t, ok)
+	require.NoError(t, k.Set(&conf, "1m30s"))
+	assert.Equal(t, Duration(90*time.Second), conf.Retry.MaxBackoff)
+	assert.Equal(t, "1m30s", k.Get(&conf))
+	assert.ErrorContains(t, k.Set(&conf, "90"), "must be a duration")
+
+	k, ok = LookupConfigKey("retry.statusCodes")
+	require.True(t, ok)
+	require.NoError(t, k.Set(&conf, "500, 503"))
+	assert.Equal(t, []int{500, 503}, conf.Retry.StatusCodes)
+	assert.Equal(t, "500,503", k.Get(&conf))
+
+	data, err := conf.Retry.MaxBackoff.MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, `"1m30s"`, string(data))
+
+	var d Duration
+	require.NoError(t, d.UnmarshalJSON([]byte(`"10s"`)))
+	assert.Equal(t, Duration(10*time.Second), d)
+	assert.ErrorContains(t, d.UnmarshalJSON([]byte(`10`)), "must be a string")
+}
@@ -6,21 +6,28 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss"
 	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/credentials"
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/retry"
+	"golang.org/x/sync/singleflight"
 	"helm-oss/internal/helmutil"
-	"sigs.k8s.io/yaml"
 )
 
 var (
 	ErrBucketNotFound = errors.New("bucket not found")
 	ErrObjectNotFound = errors.New("object not found")
+
+	// ErrIndexConflict is returned by PutIndex when the index was modified
+	// by someone else since it has been fetched.
+	ErrIndexConflict = errors.New("index was modified concurrently")
 )
 
 const (
@@ -35,17 +42,28 @@ const (
 	metaChartDigest = "chart-digest"
 )
 
-type Config struct {
-	Endpoint        string `json:"endpoint"`
-	Region          string `json:"region"`
-	AccessKeyID     string `json:"accessKeyID"`
-	AccessKeySecret string `json:"accessKeySecret"`
-	SessionToken    string `json:"sessionToken"`
-}
-
 type Storage struct {
-	cfg    oss.Config
+	conf     Config
+	provider credentials.CredentialsProvider
+
+	// httpClient and retryer are shared by the clients of all locations.
+	httpClient *http.Client
+	retryer    retry.Retryer
+
+	// logger logs the requests, see loggingTransport.
+	logger *slog.Logger
+
+	// client is the client for the configured region and endpoint.
 	client *oss.Client
+
+	mu sync.Mutex
+	// buckets are the resolved locations by bucket name.
+	buckets map[string]bucketLocation
+	// locations are the clients by bucket location, shared by the buckets
+	// in the same location.
+	locations map[bucketLocation]*oss.Client
+	// discovery deduplicates the concurrent region discoveries by bucket.
+	discovery singleflight.Group
 }
 
 type ChartInfo struct {
@@ -54,64 +72,72 @@ type ChartInfo struct {
 	Hash     string
 }
 
-// New returns a new Storage.
-// It loads configuration from ~/.config/helm_plugin_oss.yaml if exists,
-// and overrides it with environment variables.
-func New() *Storage {
-	// 1. Load config from file
-	conf := &Config{}
-	home, err := os.UserHomeDir()
-	if err == nil {
-		configPath := filepath.Join(home, ".config", "helm_plugin_oss.yaml")
-		if data, err := os.ReadFile(configPath); err == nil {
-			_ = yaml.Unmarshal(data, conf)
-		}
+// New returns a new Storage with the configuration.
+// Use LoadConfig to load the configuration of the repository.
+// The requests are logged with slog.Default at debug level.
+func New(conf Config) (*Storage, error) {
+	if err := defaultLocation(conf).validate(); err != nil {
+		return nil, err
 	}
 
-	// 2. Override with Env Vars
-	if v := os.Getenv("HELM_OSS_ENDPOINT"); v != "" {
-		conf.Endpoint = v
-	}
-	if v := os.Getenv("HELM_OSS_REGION"); v != "" {
-		conf.Region = v
-	}
-	if v := os.Getenv("HELM_OSS_ACCESS_KEY_ID"); v != "" {
-		conf.AccessKeyID = v
+	provider, err := NewCredentialsProvider(conf)
+	if err != nil {
+		return nil, err
 	}
-	if v := os.Getenv("HELM_OSS_ACCESS_KEY_SECRET"); v != "" {
-		conf.AccessKeySecret = v
+
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
 	}
-	if v := os.Getenv("HELM_OSS_SESSION_TOKEN"); v != "" {
-		conf.SessionToken = v
+
+	s := &Storage{
+		conf:       conf,
+		provider:   provider,
+		httpClient: httpClient,
+		retryer:    newRetryer(conf.Retry),
+		logger:     slog.Default(),
+		buckets:    make(map[string]bucketLocation),
 	}
+	s.client = s.newClient(defaultLocation(conf))
+	s.locations = map[bucketLocation]*oss.Client{defaultLocation(conf): s.client}
 
-	// 3. Create Credentials Provider
-	provider := credentials.NewStaticCredentialsProvider(
-		conf.AccessKeyID,
-		conf.AccessKeySecret,
-		conf.SessionToken,
+	s.logger.Debug("oss client created",
+		"endpoint", endpointURL(defaultLocation(conf)),
+		"region", conf.Region,
 	)
+	return s, nil
+}
 
-	// 4. Create OSS Config
-	cfg := oss.LoadDefaultConfig().
-		WithCredentialsProvider(provider).
-		WithRegion(conf.Region).
-		WithEndpoint(conf.Endpoint)
-
-	// 5. Create Client
-	client := oss.NewClient(cfg)
-
-	return &Storage{
-		cfg:    *cfg,
-		client: client,
+// newClient returns the client for the location.
+func (s *Storage) newClient(loc bucketLocation) *oss.Client {
+	httpClient := newLoggingClient(s.httpClient, s.logger, loc)
+	if u, err := url.Parse(endpointURL(loc)); err == nil && loc.Mode != EndpointCNAME {
+		httpClient.Transport = &regionCheckingTransport{
+			base:   httpClient.Transport,
+			host:   u.Host,
+			forget: func(bucket string) { s.forgetLocation(bucket, loc) },
+		}
 	}
+
+	cfg := oss.LoadDefaultConfig().
+		WithCredentialsProvider(s.provider).
+		WithHttpClient(httpClient).
+		WithRetryer(s.retryer).
+		WithRegion(loc.Region).
+		WithEndpoint(loc.endpoint()).
+		WithUseCName(loc.Mode == EndpointCNAME)
+
+	return oss.NewClient(cfg, func(o *oss.Options) {
+		o.Signer = optionalSigner{o.Signer}
+	})
 }
 
-// Traverse traverses all charts in the repository.
-func (s *Storage) Traverse(ctx context.Context, repoURI string) (<-chan ChartInfo, <-chan error) {
+// Traverse traverses all charts in the repository, loading up to concurrency
+// charts in parallel.
+func (s *Storage) Traverse(ctx context.Context, repoURI string, concurrency int) (<-chan ChartInfo, <-chan error) {
 	charts := make(chan ChartInfo, 1)
 	errs := make(chan error, 1)
-	go s.traverse(ctx, repoURI, charts, errs)
+	go s.traverse(ctx, repoURI, concurrency, charts, errs)
 	return charts, errs
 }
 
@@ -120,11 +146,11 @@ func (s *Storage) Traverse(ctx context.Context, repoURI string) (<-chan ChartInf
 // It always closes both channels when returns.
 //
 //nolint:funcorder // Keep traverse near Traverse for better code organization
-func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- ChartInfo, errs chan<- error) {
+func (s *Storage) traverse(ctx context.Context, repoURI string, concurrency int, items chan<- ChartInfo, errs chan<- error) {
 	defer close(items)
 	defer close(errs)
 
-	bucket, prefixKey, err := parseURI(repoURI)
+	client, bucket, prefixKey, err := s.resolve(ctx, repoURI)
 	if err != nil {
 		errs <- err
 		return
@@ -132,7 +158,7 @@ func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- Cha
 
 	var continuationToken *string
 	for {
-		listOut, err := s.client.ListObjectsV2(ctx, &oss.ListObjectsV2Request{
+		listOut, err := client.ListObjectsV2(ctx, &oss.ListObjectsV2Request{
 			Bucket:            oss.Ptr(bucket),
 			Prefix:            oss.Ptr(prefixKey),
 			ContinuationToken: continuationToken,
@@ -142,79 +168,36 @@ func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- Cha
 			return
 		}
 
+		var uris []string
 		for _, obj := range listOut.Contents {
 			// We need to make object key relative to repo root.
 			key := strings.TrimPrefix(*obj.Key, prefixKey)
 			// Additionally trim prefix slash if exists
 			key = strings.TrimPrefix(key, "/")
 
-			if strings.Contains(key, "/") {
-				// This is a subfolder. Ignore it.
+			if !isChartKey(key) {
+				// Ignore subfolders and any file that isn't a chart.
 				continue
 			}
 
-			if !strings.HasSuffix(key, ".tgz") {
-				// Ignore any file that isn't a chart
-				continue
-			}
+			uris = append(uris, "oss://"+bucket+"/"+*obj.Key)
+		}
 
-			metaOut, err := s.client.HeadObject(ctx, &oss.HeadObjectRequest{
-				Bucket: oss.Ptr(bucket),
-				Key:    obj.Key,
-			})
-			if err != nil {
-				errs <- fmt.Errorf("head oss object %q: %w", key, err)
-				return
-			}
+		// Charts of the page are loaded concurrently, but reported in the
+		// listing order.
+		pageItems, err := LoadCharts(ctx, s, uris, concurrency)
+		if err != nil {
+			errs <- err
+			return
+		}
 
-			reindexItem := ChartInfo{Filename: key}
-
-			// Try to get metadata with case-insensitivity handling
-			// OSS might capitalize keys.
-			serializedChartMeta := getMetadataValue(metaOut.Metadata, metaChartMetadata)
-			chartDigest := getMetadataValue(metaOut.Metadata, metaChartDigest)
-
-			if serializedChartMeta == "" || chartDigest == "" {
-				// Metadata missing, fallback to downloading
-				objectOut, err := s.client.GetObject(ctx, &oss.GetObjectRequest{
-					Bucket: oss.Ptr(bucket),
-					Key:    obj.Key,
-				})
-				if err != nil {
-					errs <- fmt.Errorf("get oss object %q: %w", key, err)
-					return
-				}
-
-				buf := &bytes.Buffer{}
-				tr := io.TeeReader(objectOut.Body, buf)
-
-				ch, err := helmutil.LoadArchive(tr)
-				objectOut.Body.Close()
-				if err != nil {
-					errs <- fmt.Errorf("load archive from oss object %q: %w", key, err)
-					return
-				}
-
-				digest, err := helmutil.Digest(buf)
-				if err != nil {
-					errs <- fmt.Errorf("get chart hash for %q: %w", key, err)
-					return
-				}
-
-				reindexItem.Meta = ch.Metadata()
-				reindexItem.Hash = digest
-			} else {
-				meta := helmutil.NewChartMetadata()
-				if err := meta.UnmarshalJSON([]byte(serializedChartMeta)); err != nil {
-					errs <- fmt.Errorf("unserialize chart meta for %q: %w", key, err)
-					return
-				}
-
-				reindexItem.Meta = meta
-				reindexItem.Hash = chartDigest
+		for _, item := range pageItems {
+			select {
+			case items <- item:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
 			}
-
-			items <- reindexItem
 		}
 
 		if !listOut.IsTruncated {
@@ -227,48 +210,110 @@ func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- Cha
 	}
 }
 
-// FetchRaw downloads the object from URI and returns it in the form of byte slice.
+// LoadChart returns the information about the chart object by uri. It is
+// taken from the object metadata if present, otherwise the object is
+// downloaded.
+func (s *Storage) LoadChart(ctx context.Context, uri string) (ChartInfo, error) {
+	client, bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return ChartInfo{}, err
+	}
+	filename := path.Base(key)
+
+	metaOut, err := client.HeadObject(ctx, &oss.HeadObjectRequest{
+		Bucket: oss.Ptr(bucket),
+		Key:    oss.Ptr(key),
+	})
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("head oss object %q: %w", filename, err)
+	}
+
+	item, ok, err := chartInfoFromMetadata(filename, metaOut.Metadata)
+	if err != nil || ok {
+		return item, err
+	}
+
+	// Metadata missing, fallback to downloading
+	objectOut, err := client.GetObject(ctx, &oss.GetObjectRequest{
+		Bucket: oss.Ptr(bucket),
+		Key:    oss.Ptr(key),
+	})
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("get oss object %q: %w", filename, err)
+	}
+	defer objectOut.Body.Close()
+
+	return chartInfoFromArchive(filename, objectOut.Body)
+}
+
+// FetchRaw downloads the object from URI and returns it in the form of byte slice
+// along with the object ETag.
 // uri must be in the form of oss protocol: oss://bucket-name/key[...].
-func (s *Storage) FetchRaw(ctx context.Context, uri string) ([]byte, error) {
-	bucket, key, err := parseURI(uri)
+func (s *Storage) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
+	client, bucket, key, err := s.resolve(ctx, uri)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	result, err := s.client.GetObject(ctx, &oss.GetObjectRequest{
+	result, err := client.GetObject(ctx, &oss.GetObjectRequest{
 		Bucket: oss.Ptr(bucket),
 		Key:    oss.Ptr(key),
 	})
 	if err != nil {
-		var serviceErr *oss.ServiceError
-		if errors.As(err, &serviceErr) {
-			if serviceErr.StatusCode == http.StatusNotFound || serviceErr.Code == "NoSuchKey" {
-				return nil, ErrObjectNotFound
-			}
-			if serviceErr.Code == "NoSuchBucket" {
-				return nil, ErrBucketNotFound
-			}
-		}
-		return nil, fmt.Errorf("fetch object from oss: %w", err)
+		return nil, "", fetchError(err)
 	}
 	defer result.Body.Close()
 
 	data, err := io.ReadAll(result.Body)
 	if err != nil {
-		return nil, fmt.Errorf("read object body: %w", err)
+		return nil, "", fmt.Errorf("read object body: %w", err)
+	}
+
+	return data, oss.ToString(result.ETag), nil
+}
+
+// Open returns the reader streaming the object from URI.
+// uri must be in the form of oss protocol: oss://bucket-name/key[...].
+func (s *Storage) Open(ctx context.Context, uri string) (io.ReadCloser, error) {
+	client, bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := client.GetObject(ctx, &oss.GetObjectRequest{
+		Bucket: oss.Ptr(bucket),
+		Key:    oss.Ptr(key),
+	})
+	if err != nil {
+		return nil, fetchError(err)
 	}
 
-	return data, nil
+	return result.Body, nil
+}
+
+// fetchError converts the error of GetObject to ErrObjectNotFound or
+// ErrBucketNotFound if applicable.
+func fetchError(err error) error {
+	var serviceErr *oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		if serviceErr.StatusCode == http.StatusNotFound || serviceErr.Code == "NoSuchKey" {
+			return ErrObjectNotFound
+		}
+		if serviceErr.Code == "NoSuchBucket" {
+			return ErrBucketNotFound
+		}
+	}
+	return fmt.Errorf("fetch object from oss: %w", err)
 }
 
 // Exists returns true if an object exists in the storage.
 func (s *Storage) Exists(ctx context.Context, uri string) (bool, error) {
-	bucket, key, err := parseURI(uri)
+	client, bucket, key, err := s.resolve(ctx, uri)
 	if err != nil {
 		return false, err
 	}
 
-	_, err = s.client.HeadObject(ctx, &oss.HeadObjectRequest{
+	_, err = client.HeadObject(ctx, &oss.HeadObjectRequest{
 		Bucket: oss.Ptr(bucket),
 		Key:    oss.Ptr(key),
 	})
@@ -290,43 +335,106 @@ func (s *Storage) Exists(ctx context.Context, uri string) (bool, error) {
 	return true, nil
 }
 
-// IndexExists returns true if index file exists in the storage for repository
-// with the provided uri.
+// StatObject returns the information about the object by uri, including its
+// metadata.
 // uri must be in the form of oss protocol: oss://bucket-name/key[...].
-func (s *Storage) IndexExists(ctx context.Context, uri string) (bool, error) {
-	if strings.HasPrefix(uri, "index.yaml") {
-		return false, errors.New("uri must not contain \"index.yaml\" suffix, it appends automatically")
+func (s *Storage) StatObject(ctx context.Context, uri string) (ObjectInfo, error) {
+	client, bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return ObjectInfo{}, err
 	}
-	uri = helmutil.IndexFileURL(uri)
 
-	return s.Exists(ctx, uri)
+	headOut, err := client.HeadObject(ctx, &oss.HeadObjectRequest{
+		Bucket: oss.Ptr(bucket),
+		Key:    oss.Ptr(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, fetchError(err)
+	}
+
+	return ObjectInfo{
+		URI:          uri,
+		Size:         headOut.ContentLength,
+		ETag:         oss.ToString(headOut.ETag),
+		LastModified: oss.ToTime(headOut.LastModified),
+		Metadata:     headOut.Metadata,
+	}, nil
 }
 
 // PutIndex puts the index file to the storage.
 // uri must be in the form of oss protocol: oss://bucket-name/key[...].
-func (s *Storage) PutIndex(ctx context.Context, uri string, r io.Reader) error {
+//
+// If etag is empty, the index is only created if it does not exist yet, which
+// OSS guarantees with x-oss-forbid-overwrite. If etag is not empty, the index
+// is only replaced if its current ETag matches. OSS does not support
+// conditional overwrites, so the ETag is checked with a HEAD request right
+// before the upload: this detects a stale index, but does not exclude
+// concurrent writers, which is done by the repository lock.
+// ErrIndexConflict is returned when the condition fails.
+func (s *Storage) PutIndex(ctx context.Context, uri string, etag string, r io.Reader) error {
 	if strings.HasPrefix(uri, "index.yaml") {
 		return errors.New("uri must not contain \"index.yaml\" suffix, it appends automatically")
 	}
 	uri = helmutil.IndexFileURL(uri)
 
-	bucket, key, err := parseURI(uri)
+	client, bucket, key, err := s.resolve(ctx, uri)
 	if err != nil {
 		return err
 	}
 
-	_, err = s.client.PutObject(ctx, &oss.PutObjectRequest{
+	req := &oss.PutObjectRequest{
 		Bucket: oss.Ptr(bucket),
 		Key:    oss.Ptr(key),
 		Body:   r,
-	})
+	}
+	if etag != "" {
+		headOut, err := client.HeadObject(ctx, &oss.HeadObjectRequest{
+			Bucket: oss.Ptr(bucket),
+			Key:    oss.Ptr(key),
+		})
+		if err != nil {
+			if errors.Is(fetchError(err), ErrObjectNotFound) {
+				return ErrIndexConflict
+			}
+			return fmt.Errorf("head index object: %w", err)
+		}
+		if oss.ToString(headOut.ETag) != etag {
+			return ErrIndexConflict
+		}
+	} else {
+		req.ForbidOverwrite = oss.Ptr("true")
+	}
+
+	_, err = client.PutObject(ctx, req)
 	if err != nil {
+		if isConflict(err) {
+			return ErrIndexConflict
+		}
 		return fmt.Errorf("upload index to OSS bucket: %w", err)
 	}
 
 	return nil
 }
 
+// PutObject puts the object by uri.
+func (s *Storage) PutObject(ctx context.Context, uri string, r io.Reader) error {
+	client, bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObject(ctx, &oss.PutObjectRequest{
+		Bucket: oss.Ptr(bucket),
+		Key:    oss.Ptr(key),
+		Body:   r,
+	})
+	if err != nil {
+		return fmt.Errorf("upload object to oss: %w", err)
+	}
+
+	return nil
+}
+
 // PutChart puts the chart file to the storage.
 // uri must be in the form of oss protocol: oss://bucket-name/key[...].
 func (s *Storage) PutChart(
@@ -339,12 +447,12 @@ func (s *Storage) PutChart(
 	prov bool,
 	provReader io.Reader,
 ) (string, error) {
-	bucket, key, err := parseURI(uri)
+	client, bucket, key, err := s.resolve(ctx, uri)
 	if err != nil {
 		return "", err
 	}
 
-	_, err = s.client.PutObject(ctx, &oss.PutObjectRequest{
+	_, err = client.PutObject(ctx, &oss.PutObjectRequest{
 		Bucket:      oss.Ptr(bucket),
 		Key:         oss.Ptr(key),
 		Body:        r,
@@ -356,7 +464,7 @@ func (s *Storage) PutChart(
 	}
 
 	if prov {
-		_, err := s.client.PutObject(ctx, &oss.PutObjectRequest{
+		_, err := client.PutObject(ctx, &oss.PutObjectRequest{
 			Bucket: oss.Ptr(bucket),
 			Key:    oss.Ptr(key + ".prov"),
 			Body:   provReader,
@@ -372,7 +480,7 @@ func (s *Storage) PutChart(
 // DeleteChart deletes the chart object by uri. Also deletes .prov file if exists.
 // uri must be in the form of oss protocol: oss://bucket-name/key[...].
 func (s *Storage) DeleteChart(ctx context.Context, uri string) error {
-	bucket, key, err := parseURI(uri)
+	client, bucket, key, err := s.resolve(ctx, uri)
 	if err != nil {
 		return err
 	}
@@ -383,7 +491,7 @@ func (s *Storage) DeleteChart(ctx context.Context, uri string) error {
 		{Key: oss.Ptr(key + ".prov")},
 	}
 
-	_, err = s.client.DeleteMultipleObjects(ctx, &oss.DeleteMultipleObjectsRequest{
+	_, err = client.DeleteMultipleObjects(ctx, &oss.DeleteMultipleObjectsRequest{
 		Bucket:  oss.Ptr(bucket),
 		Objects: objects,
 		Quiet:   true,
@@ -395,6 +503,131 @@ func (s *Storage) DeleteChart(ctx context.Context, uri string) error {
 	return nil
 }
 
+// AcquireLock creates the repository lock object. The object is created with
+// forbid-overwrite semantics, so only one process can hold the lock at a time.
+func (s *Storage) AcquireLock(ctx context.Context, repoURI string, lock Lock) error {
+	return acquireLock(ctx, s, repoURI, lock)
+}
+
+// ReadLock returns the current holder of the repository lock.
+func (s *Storage) ReadLock(ctx context.Context, repoURI string) (Lock, bool, error) {
+	return readLock(ctx, s, repoURI)
+}
+
+// RenewLock extends the repository lock held with the lock id.
+func (s *Storage) RenewLock(ctx context.Context, repoURI string, id string, ttl time.Duration) error {
+	return renewLock(ctx, s, repoURI, id, ttl)
+}
+
+// ReleaseLock removes the repository lock if it is still held with the lock id.
+func (s *Storage) ReleaseLock(ctx context.Context, repoURI string, id string) error {
+	return releaseLock(ctx, s, repoURI, id)
+}
+
+// BreakLock removes the repository lock held with the lock id, even if it
+// has not expired, along with its lease.
+func (s *Storage) BreakLock(ctx context.Context, repoURI string, id string) error {
+	return breakLock(ctx, s, repoURI, id)
+}
+
+// WriteJournal records the operation before it modifies the repository.
+func (s *Storage) WriteJournal(ctx context.Context, repoURI string, journal Journal) error {
+	return writeJournal(ctx, s, repoURI, journal)
+}
+
+// ListJournals returns the journals of all operations that have not been completed.
+func (s *Storage) ListJournals(ctx context.Context, repoURI string) ([]Journal, error) {
+	return listJournals(ctx, s, repoURI)
+}
+
+// CompleteJournal marks the operation with the id as completed.
+func (s *Storage) CompleteJournal(ctx context.Context, repoURI string, id string) error {
+	return s.deleteObject(ctx, JournalURL(repoURI, id))
+}
+
+// createObject uploads the object by uri only if it does not exist yet.
+// Returns errObjectExists otherwise.
+func (s *Storage) createObject(ctx context.Context, uri string, data []byte) error {
+	client, bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutObject(ctx, &oss.PutObjectRequest{
+		Bucket:          oss.Ptr(bucket),
+		Key:             oss.Ptr(key),
+		Body:            bytes.NewReader(data),
+		ForbidOverwrite: oss.Ptr("true"),
+	})
+	if err != nil {
+		if isConflict(err) {
+			return errObjectExists
+		}
+		return fmt.Errorf("upload object to oss: %w", err)
+	}
+
+	return nil
+}
+
+// deleteObject deletes the object by uri.
+func (s *Storage) deleteObject(ctx context.Context, uri string) error {
+	client, bucket, key, err := s.resolve(ctx, uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteObject(ctx, &oss.DeleteObjectRequest{
+		Bucket: oss.Ptr(bucket),
+		Key:    oss.Ptr(key),
+	})
+	if err != nil {
+		return fmt.Errorf("delete object from oss: %w", err)
+	}
+
+	return nil
+}
+
+// ListObjects returns all objects located directly in the directory by dirURI.
+func (s *Storage) ListObjects(ctx context.Context, dirURI string) ([]ObjectInfo, error) {
+	client, bucket, prefix, err := s.resolve(ctx, dirURI)
+	if err != nil {
+		return nil, err
+	}
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+
+	var objects []ObjectInfo
+	var continuationToken *string
+	for {
+		listOut, err := client.ListObjectsV2(ctx, &oss.ListObjectsV2Request{
+			Bucket:            oss.Ptr(bucket),
+			Prefix:            oss.Ptr(prefix),
+			Delimiter:         oss.Ptr("/"),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list oss bucket objects: %w", err)
+		}
+
+		for _, obj := range listOut.Contents {
+			objects = append(objects, ObjectInfo{
+				URI:          "oss://" + bucket + "/" + oss.ToString(obj.Key),
+				Size:         obj.Size,
+				ETag:         oss.ToString(obj.ETag),
+				LastModified: oss.ToTime(obj.LastModified),
+			})
+		}
+
+		if !listOut.IsTruncated || oss.ToString(listOut.NextContinuationToken) == "" {
+			break
+		}
+		continuationToken = listOut.NextContinuationToken
+	}
+
+	return objects, nil
+}
+
 func parseURI(uri string) (bucket, key string, err error) {
 	if !strings.HasPrefix(uri, "oss://") {
 		return "", "", fmt.Errorf("uri %s protocol is not oss", uri)
@@ -409,14 +642,29 @@ func parseURI(uri string) (bucket, key string, err error) {
 	return bucket, key, nil
 }
 
+// isConflict reports whether err is a failed precondition of a conditional write.
+func isConflict(err error) bool {
+	var serviceErr *oss.ServiceError
+	if !errors.As(err, &serviceErr) {
+		return false
+	}
+	return serviceErr.StatusCode == http.StatusPreconditionFailed ||
+		serviceErr.Code == "PreconditionFailed" ||
+		serviceErr.Code == "FileAlreadyExists"
+}
+
 // Helper to check if error is generic NotFound if SDK error type check fails.
 func IsNotFound(err error) bool {
 	return errors.Is(err, ErrObjectNotFound) || errors.Is(err, ErrBucketNotFound)
 }
 
 // assembleObjectMetadata assembles and returns OSS object metadata.
-// May return empty metadata if chart metadata is too big.
+// May return empty metadata if chart metadata is too big or not set.
 func assembleObjectMetadata(chartMeta, chartDigest string) map[string]string {
+	if chartMeta == "" && chartDigest == "" {
+		return nil
+	}
+
 	meta := map[string]string{
 		metaChartMetadata: chartMeta,
 		metaChartDigest:   chartDigest,
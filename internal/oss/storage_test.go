@@ -0,0 +1,171 @@
+package oss
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/helmutil"
+	"helm-oss/internal/osstest"
+)
+
+func TestStorage(t *testing.T) {
+	srv := osstest.NewTestServer(t, "test-bucket")
+	ctx := context.Background()
+	repo := "oss://test-bucket/charts"
+	conf, err := LoadConfig(repo, ConfigOptions{})
+	require.NoError(t, err)
+	s, err := New(conf)
+	require.NoError(t, err)
+
+	t.Run("index", func(t *testing.T) {
+		exists, err := IndexExists(ctx, s, repo)
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		_, _, err = s.FetchRaw(ctx, repo+"/index.yaml")
+		assert.ErrorIs(t, err, ErrObjectNotFound)
+		assert.ErrorIs(t, s.PutIndex(ctx, repo, `"ETAG"`, strings.NewReader("v1")), ErrIndexConflict)
+
+		require.NoError(t, s.PutIndex(ctx, repo, "", strings.NewReader("v1")))
+		assert.ErrorIs(t, s.PutIndex(ctx, repo, "", strings.NewReader("v1")), ErrIndexConflict)
+
+		data, etag, err := s.FetchRaw(ctx, repo+"/index.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(data))
+		assert.NotEmpty(t, etag)
+
+		r, err := s.Open(ctx, repo+"/index.yaml")
+		require.NoError(t, err)
+		data, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Equal(t, "v1", string(data))
+
+		_, err = s.Open(ctx, repo+"/missing.yaml")
+		assert.ErrorIs(t, err, ErrObjectNotFound)
+
+		require.NoError(t, s.PutIndex(ctx, repo, etag, strings.NewReader("v2")))
+		assert.ErrorIs(t, s.PutIndex(ctx, repo, etag, strings.NewReader("v3")), ErrIndexConflict)
+
+		exists, err = IndexExists(ctx, s, repo)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("charts", func(t *testing.T) {
+		chartPath := "../../testdata/foo-1.2.3.tgz"
+		chart, err := helmutil.LoadChart(chartPath)
+		require.NoError(t, err)
+		chartMeta, err := chart.Metadata().MarshalJSON()
+		require.NoError(t, err)
+		digest, err := helmutil.DigestFile(chartPath)
+		require.NoError(t, err)
+		data, err := os.ReadFile(chartPath)
+		require.NoError(t, err)
+
+		_, err = s.PutChart(
+			ctx, repo+"/foo-1.2.3.tgz", bytes.NewReader(data), string(chartMeta), digest,
+			"application/gzip", true, strings.NewReader("prov"),
+		)
+		require.NoError(t, err)
+
+		obj, ok := srv.GetObject("test-bucket", "charts/foo-1.2.3.tgz")
+		require.True(t, ok)
+		assert.Equal(t, digest, obj.Metadata[metaChartDigest])
+		assert.Equal(t, "application/gzip", obj.ContentType)
+
+		// A chart without metadata, which has to be downloaded on traverse.
+		srv.PutObject("test-bucket", "charts/foo-1.2.4.tgz", data, nil)
+		// Objects that are not charts in the repository root are ignored.
+		srv.PutObject("test-bucket", "charts/sub/foo-1.2.5.tgz", data, nil)
+		srv.PutObject("test-bucket", "charts/README.md", []byte("readme"), nil)
+
+		// Force pagination to check continuation tokens.
+		srv.PageSize = 1
+
+		items, errs := s.Traverse(ctx, repo, 2)
+		var found []string
+		for item := range items {
+			found = append(found, item.Filename)
+			assert.Equal(t, digest, item.Hash)
+		}
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []string{"foo-1.2.3.tgz", "foo-1.2.4.tgz"}, found)
+
+		objects, err := s.ListObjects(ctx, repo)
+		require.NoError(t, err)
+		var uris []string
+		for _, obj := range objects {
+			uris = append(uris, obj.URI)
+			assert.NotEmpty(t, obj.ETag)
+			assert.False(t, obj.LastModified.IsZero())
+		}
+		assert.Equal(t, []string{
+			repo + "/README.md",
+			repo + "/foo-1.2.3.tgz",
+			repo + "/foo-1.2.3.tgz.prov",
+			repo + "/foo-1.2.4.tgz",
+			repo + "/index.yaml",
+		}, uris, "objects in subfolders must not be listed")
+
+		item, err := s.LoadChart(ctx, repo+"/foo-1.2.4.tgz")
+		require.NoError(t, err)
+		assert.Equal(t, "foo-1.2.4.tgz", item.Filename)
+		assert.Equal(t, digest, item.Hash)
+
+		require.NoError(t, s.DeleteChart(ctx, repo+"/foo-1.2.3.tgz"))
+		assert.NotContains(t, srv.Keys("test-bucket"), "charts/foo-1.2.3.tgz")
+		assert.NotContains(t, srv.Keys("test-bucket"), "charts/foo-1.2.3.tgz.prov")
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		first := NewLock("push", time.Minute)
+		require.NoError(t, s.AcquireLock(ctx, repo, first))
+		assert.ErrorIs(t, s.AcquireLock(ctx, repo, NewLock("push", time.Minute)), ErrLocked)
+
+		holder, found, err := s.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, first.ID, holder.ID)
+
+		require.NoError(t, s.RenewLock(ctx, repo, first.ID, time.Hour))
+		holder, _, err = s.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		assert.True(t, holder.Expires.After(first.Expires))
+
+		require.NoError(t, s.ReleaseLock(ctx, repo, first.ID))
+		_, found, err = s.ReadLock(ctx, repo)
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.NotContains(t, srv.Keys("test-bucket"), "charts/.helm-oss.lock.lease-"+first.ID)
+	})
+
+	t.Run("journal", func(t *testing.T) {
+		journal := NewJournal("reindex", nil)
+		require.NoError(t, s.WriteJournal(ctx, repo, journal))
+
+		journals, err := s.ListJournals(ctx, repo)
+		require.NoError(t, err)
+		require.Len(t, journals, 1)
+		assert.Equal(t, journal.ID, journals[0].ID)
+
+		require.NoError(t, s.CompleteJournal(ctx, repo, journal.ID))
+		journals, err = s.ListJournals(ctx, repo)
+		require.NoError(t, err)
+		assert.Empty(t, journals)
+	})
+
+	t.Run("missing bucket", func(t *testing.T) {
+		_, _, err := s.FetchRaw(ctx, "oss://no-such-bucket/charts/index.yaml")
+		assert.True(t, IsNotFound(err))
+	})
+}
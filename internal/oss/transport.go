@@ -0,0 +1,115 @@
+package oss
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss"
+	"github.com/aliyun/alibabacloud-oss-go-sdk-v2/oss/transport"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// TLSConfig configures TLS of the connections to the endpoint, e.g. to an
+// OSS-compatible gateway with an internal certificate authority or mutual
+// TLS.
+type TLSConfig struct {
+	// CertFile and KeyFile are the client certificate and key files.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+
+	// CAFile is the file with the certificate authorities trusted instead of
+	// the system ones.
+	CAFile string `json:"caFile,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the server certificate.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// clientConfig loads the files and returns the TLS client configuration.
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec // Explicitly enabled by the user.
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("tls: both certFile and keyFile must be set for the client certificate")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("tls: no certificates found in CA file %s", c.CAFile)
+		}
+		conf.RootCAs = pool
+	}
+
+	return conf, nil
+}
+
+// newHTTPClient returns the HTTP client for the requests to the endpoint.
+// It is the SDK default client with the timeouts, the proxy and the TLS
+// configuration applied.
+func newHTTPClient(conf Config) (*http.Client, error) {
+	tlsConf, err := conf.TLS.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy, err := proxyFunc(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tconf := &transport.Config{}
+	if conf.ConnectTimeout > 0 {
+		tconf.ConnectTimeout = oss.Ptr(time.Duration(conf.ConnectTimeout))
+	}
+	if conf.ReadWriteTimeout > 0 {
+		tconf.ReadWriteTimeout = oss.Ptr(time.Duration(conf.ReadWriteTimeout))
+	}
+
+	return transport.NewHttpClient(tconf, func(t *http.Transport) {
+		t.TLSClientConfig = tlsConf
+		t.Proxy = proxy
+	}), nil
+}
+
+// proxyFunc returns the proxy selection of the requests. The proxy and the
+// hosts accessed directly are taken from the configuration, or from
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables (or the
+// lowercase versions thereof).
+func proxyFunc(conf Config) (func(*http.Request) (*url.URL, error), error) {
+	pc := httpproxy.FromEnvironment()
+	if conf.Proxy != "" {
+		if _, err := url.Parse(conf.Proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		pc.HTTPProxy, pc.HTTPSProxy = conf.Proxy, conf.Proxy
+	}
+	if conf.NoProxy != "" {
+		pc.NoProxy = conf.NoProxy
+	}
+
+	proxy := pc.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxy(r.URL)
+	}, nil
+}
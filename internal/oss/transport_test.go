@@ -0,0 +1,136 @@
+package oss
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm-oss/internal/osstest"
+)
+
+func TestStorage_TLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+
+	srv := osstest.NewUnstartedServer()
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	srv.CreateBucket("test-bucket")
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	const repo = "oss://test-bucket/charts"
+	conf := Config{
+		Endpoint:        srv.URL,
+		Region:          osstest.DefaultRegion,
+		AccessKeyID:     "test-access-key-id",
+		AccessKeySecret: "test-access-key-secret",
+	}
+	ctx := context.Background()
+
+	t.Run("mutual tls", func(t *testing.T) {
+		conf := conf
+		conf.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+		s, err := New(conf)
+		require.NoError(t, err)
+
+		require.NoError(t, s.PutIndex(ctx, repo, "", strings.NewReader("v1")))
+		data, _, err := s.FetchRaw(ctx, repo+"/index.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(data))
+	})
+
+	t.Run("unknown authority", func(t *testing.T) {
+		conf := conf
+		conf.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile}
+		s, err := New(conf)
+		require.NoError(t, err)
+
+		_, _, err = s.FetchRaw(ctx, repo+"/index.yaml")
+		assert.ErrorContains(t, err, "certificate signed by unknown authority")
+	})
+
+	t.Run("no client certificate", func(t *testing.T) {
+		conf := conf
+		conf.TLS = TLSConfig{CAFile: caFile}
+		s, err := New(conf)
+		require.NoError(t, err)
+
+		_, _, err = s.FetchRaw(ctx, repo+"/index.yaml")
+		assert.Error(t, err)
+	})
+}
+
+func TestTLSConfig_clientConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, _, _ := writeClientCert(t, dir)
+
+	_, err := TLSConfig{CertFile: certFile}.clientConfig()
+	assert.ErrorContains(t, err, "both certFile and keyFile must be set")
+
+	_, err = TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}.clientConfig()
+	assert.ErrorContains(t, err, "read CA file")
+
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+	_, err = TLSConfig{CAFile: empty}.clientConfig()
+	assert.ErrorContains(t, err, "no certificates found")
+
+	conf, err := TLSConfig{InsecureSkipVerify: true}.clientConfig()
+	require.NoError(t, err)
+	assert.True(t, conf.InsecureSkipVerify)
+}
+
+// writeClientCert writes the self-signed client certificate and its key to
+// the directory.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "helm-oss"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client-key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile, cert
+}
@@ -0,0 +1,446 @@
+// Package osstest provides a fake Alibaba Cloud OSS server for integration tests.
+//
+// The server speaks the subset of the OSS REST API used by the plugin:
+// PutObject (with user metadata and x-oss-forbid-overwrite), GetObject,
+// HeadObject, DeleteObject, ListObjectsV2 (with continuation tokens) and
+// DeleteMultipleObjects. Like OSS, it honours If-Match and If-None-Match
+// conditional headers on GetObject and HeadObject only; PutObject can only be
+// made conditional with x-oss-forbid-overwrite. Requests are not
+// authenticated.
+//
+// The server listens on a loopback IP address, so the OSS SDK addresses it
+// in path style: http://127.0.0.1:port/bucket/key.
+package osstest
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // MD5 is only used to compute ETags, as OSS does.
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	// DefaultRegion is the region the clients of the server should use.
+	DefaultRegion = "cn-hangzhou"
+
+	// userMetaPrefix is the prefix of HTTP headers that carry user metadata.
+	userMetaPrefix = "X-Oss-Meta-"
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Object is an object stored in the fake server.
+type Object struct {
+	Data         []byte
+	ETag         string
+	ContentType  string
+	Metadata     map[string]string
+	LastModified time.Time
+}
+
+// Server is a fake OSS server.
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the maximum number of keys returned by ListObjectsV2
+	// when the request does not specify max-keys. Defaults to 1000.
+	PageSize int
+
+	mu        sync.Mutex
+	buckets   map[string]map[string]Object
+	requestID atomic.Int64
+}
+
+// NewServer starts and returns a new fake OSS server with no buckets.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new fake OSS server with no buckets, but
+// does not start it. The caller may change the configuration, e.g. TLS,
+// before calling Start or StartTLS.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		PageSize: 1000,
+		buckets:  make(map[string]map[string]Object),
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// NewTestServer starts a new fake OSS server with the provided buckets and
+// points the plugin configuration at it with HELM_OSS_* environment variables
+// for the duration of the test. HOME is set to an empty temporary directory,
+// so that the user configuration file does not interfere with the test.
+func NewTestServer(t testing.TB, buckets ...string) *Server {
+	t.Helper()
+
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	for _, bucket := range buckets {
+		s.CreateBucket(bucket)
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("HELM_OSS_ENDPOINT", s.URL)
+	t.Setenv("HELM_OSS_REGION", DefaultRegion)
+	t.Setenv("HELM_OSS_ACCESS_KEY_ID", "test-access-key-id")
+	t.Setenv("HELM_OSS_ACCESS_KEY_SECRET", "test-access-key-secret")
+
+	return s
+}
+
+// CreateBucket creates an empty bucket if it does not exist.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = make(map[string]Object)
+	}
+}
+
+// PutObject stores the object, creating the bucket if needed.
+func (s *Server) PutObject(bucket, key string, data []byte, metadata map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket]; !ok {
+		s.buckets[bucket] = make(map[string]Object)
+	}
+	s.buckets[bucket][key] = newObject(data, "", metadata)
+}
+
+// GetObject returns the stored object.
+func (s *Server) GetObject(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.buckets[bucket][key]
+	return obj, ok
+}
+
+// Keys returns sorted keys of all objects in the bucket.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.buckets[bucket]))
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Oss-Request-Id", fmt.Sprintf("%024X", s.requestID.Add(1)))
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		s.writeError(w, http.StatusBadRequest, "InvalidBucketName", "The bucket name is empty.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Get("list-type") == "2":
+		s.listObjectsV2(w, r, bucket, objects)
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		s.deleteMultipleObjects(w, r, objects)
+	case key == "":
+		s.writeError(w, http.StatusNotImplemented, "NotImplemented", "The operation is not supported by the fake server.")
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, objects, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		s.getObject(w, r, objects, key)
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed.")
+	}
+}
+
+// putObject stores the object. OSS ignores If-Match and If-None-Match on
+// PutObject, and so does the server.
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, objects map[string]Object, key string) {
+	if _, exists := objects[key]; exists && r.Header.Get("X-Oss-Forbid-Overwrite") == "true" {
+		s.writeError(w, http.StatusConflict, "FileAlreadyExists", "The object you specified already exists and can not be overwritten.")
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "InvalidRequest", err.Error())
+		return
+	}
+
+	metadata := make(map[string]string)
+	for name, values := range r.Header {
+		if meta, ok := strings.CutPrefix(http.CanonicalHeaderKey(name), userMetaPrefix); ok && len(values) > 0 {
+			metadata[strings.ToLower(meta)] = values[0]
+		}
+	}
+
+	obj := newObject(data, r.Header.Get("Content-Type"), metadata)
+	objects[key] = obj
+
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set("X-Oss-Hash-Crc64ecma", strconv.FormatUint(crc64.Checksum(data, crcTable), 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, objects map[string]Object, key string) {
+	obj, ok := objects[key]
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if !checkPreconditions(r, obj, true) {
+		s.writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	h.Set("Content-Length", strconv.Itoa(len(obj.Data)))
+	h.Set("X-Oss-Hash-Crc64ecma", strconv.FormatUint(crc64.Checksum(obj.Data, crcTable), 10))
+	if obj.ContentType != "" {
+		h.Set("Content-Type", obj.ContentType)
+	}
+	for k, v := range obj.Metadata {
+		h.Set(userMetaPrefix+k, v)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.Data)
+	}
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name        `xml:"ListBucketResult"`
+	Name                  string          `xml:"Name"`
+	Prefix                string          `xml:"Prefix"`
+	MaxKeys               int             `xml:"MaxKeys"`
+	IsTruncated           bool            `xml:"IsTruncated"`
+	ContinuationToken     string          `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string          `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int             `xml:"KeyCount"`
+	Contents              []listedObject  `xml:"Contents"`
+	CommonPrefixes        []listedDelimit `xml:"CommonPrefixes,omitempty"`
+	StartAfter            string          `xml:"StartAfter,omitempty"`
+	Delimiter             string          `xml:"Delimiter,omitempty"`
+}
+
+type listedObject struct {
+	Key          string `xml:"Key"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+	StorageClass string `xml:"StorageClass"`
+	Type         string `xml:"Type"`
+}
+
+type listedDelimit struct {
+	Prefix string `xml:"Prefix"`
+}
+
+func (s *Server) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]Object) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	token := query.Get("continuation-token")
+	startAfter := query.Get("start-after")
+
+	maxKeys := s.PageSize
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid max-keys.")
+			return
+		}
+		maxKeys = n
+	}
+
+	// The continuation token is simply the last returned key.
+	after := startAfter
+	if token != "" {
+		after = token
+	}
+
+	result := listBucketResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        startAfter,
+		Delimiter:         delimiter,
+	}
+
+	seenPrefixes := make(map[string]bool)
+	for _, key := range slices.Sorted(maps.Keys(objects)) {
+		if !strings.HasPrefix(key, prefix) || key <= after {
+			continue
+		}
+
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[p] {
+					seenPrefixes[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, listedDelimit{Prefix: p})
+					result.KeyCount++
+				}
+				result.NextContinuationToken = key
+				continue
+			}
+		}
+
+		obj := objects[key]
+		result.Contents = append(result.Contents, listedObject{
+			Key:          key,
+			ETag:         obj.ETag,
+			Size:         len(obj.Data),
+			LastModified: obj.LastModified.Format(time.RFC3339),
+			StorageClass: "Standard",
+			Type:         "Normal",
+		})
+		result.KeyCount++
+		result.NextContinuationToken = key
+	}
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+
+	s.writeXML(w, http.StatusOK, result)
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	Deleted []struct {
+		Key string `xml:"Key"`
+	} `xml:"Deleted"`
+}
+
+func (s *Server) deleteMultipleObjects(w http.ResponseWriter, r *http.Request, objects map[string]Object) {
+	var req deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	var result deleteResult
+	for _, obj := range req.Objects {
+		delete(objects, obj.Key)
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, struct {
+				Key string `xml:"Key"`
+			}{Key: obj.Key})
+		}
+	}
+
+	s.writeXML(w, http.StatusOK, result)
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, code, message string) {
+	s.writeXML(w, status, errorResponse{
+		Code:      code,
+		Message:   message,
+		RequestID: w.Header().Get("X-Oss-Request-Id"),
+	})
+}
+
+func (s *Server) writeXML(w http.ResponseWriter, status int, v any) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match request headers
+// against the current object.
+func checkPreconditions(r *http.Request, current Object, exists bool) bool {
+	if v := r.Header.Get("If-Match"); v != "" {
+		if !exists || !etagMatches(v, current.ETag) {
+			return false
+		}
+	}
+	if v := r.Header.Get("If-None-Match"); v != "" {
+		if exists && (v == "*" || etagMatches(v, current.ETag)) {
+			return false
+		}
+	}
+	return true
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+func newObject(data []byte, contentType string, metadata map[string]string) Object {
+	sum := md5.Sum(data) //nolint:gosec // See import comment.
+	return Object{
+		Data:         bytes.Clone(data),
+		ETag:         `"` + strings.ToUpper(hex.EncodeToString(sum[:])) + `"`,
+		ContentType:  contentType,
+		Metadata:     metadata,
+		LastModified: time.Now().UTC().Truncate(time.Second),
+	}
+}